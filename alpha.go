@@ -0,0 +1,70 @@
+package pdq
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// What a PDQHasher does with the alpha channel of transparent images. The
+// colour of fully transparent pixels is whatever the encoder left there,
+// often black or garbage, so ignoring alpha lets it decide the hash.
+// Compositing over a fixed background makes the hash depend only on what
+// is visible.
+const ALPHA_POLICY_IGNORE = "ignore"
+const ALPHA_POLICY_WHITE = "white"
+const ALPHA_POLICY_BLACK = "black"
+
+// Composites over PDQHasher.AlphaColor.
+const ALPHA_POLICY_COLOR = "color"
+
+var ALPHA_POLICIES = []string{ALPHA_POLICY_IGNORE, ALPHA_POLICY_WHITE, ALPHA_POLICY_BLACK, ALPHA_POLICY_COLOR}
+
+/**
+ * Parses an alpha policy name, or a background colour as #rrggbb, which
+ * selects ALPHA_POLICY_COLOR. The colour is only meaningful for the
+ * latter.
+ */
+func ParseAlphaPolicy(s string) (string, color.RGBA, error) {
+	switch s {
+	case ALPHA_POLICY_IGNORE, ALPHA_POLICY_WHITE, ALPHA_POLICY_BLACK:
+		return s, color.RGBA{}, nil
+	}
+	hex, ok := strings.CutPrefix(s, "#")
+	if !ok || len(hex) != 6 {
+		return "", color.RGBA{}, fmt.Errorf("unknown alpha policy %q, expected one of %v or #rrggbb", s, ALPHA_POLICIES[:3])
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return "", color.RGBA{}, fmt.Errorf("invalid colour %q: %w", s, err)
+	}
+	return ALPHA_POLICY_COLOR, color.RGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 0xff}, nil
+}
+
+// The background transparent pixels are composited over, or false if
+// alpha is ignored.
+func (p *PDQHasher) alphaBackground() ([3]float64, bool) {
+	switch p.AlphaPolicy {
+	case ALPHA_POLICY_WHITE:
+		return [3]float64{255, 255, 255}, true
+	case ALPHA_POLICY_BLACK:
+		return [3]float64{0, 0, 0}, true
+	case ALPHA_POLICY_COLOR:
+		return [3]float64{float64(p.AlphaColor.R), float64(p.AlphaColor.G), float64(p.AlphaColor.B)}, true
+	}
+	return [3]float64{}, false
+}
+
+/**
+ * The luma of a pixel given as straight (not premultiplied) r, g, b and
+ * alpha from 0 to 255, composited over background. Both the vips and the
+ * pure Go paths end here, so they treat alpha alike.
+ */
+func compositeLuma(r, g, b, alpha float64, background [3]float64) float64 {
+	a := alpha / 255
+	r = a*r + (1-a)*background[0]
+	g = a*g + (1-a)*background[1]
+	b = a*b + (1-a)*background[2]
+	return LUMA_FROM_R_COEFF*r + LUMA_FROM_G_COEFF*g + LUMA_FROM_B_COEFF*b
+}
@@ -0,0 +1,353 @@
+// Package archive walks the files inside zip, tar and tar.gz archives,
+// including archives nested in archives, with limits on nesting depth and
+// decompressed size so that zip bombs cannot exhaust memory or disk.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/h2non/filetype"
+)
+
+// SEPARATOR joins an archive path and the path of an entry inside it, as
+// in "drop.zip!/photos/a.jpg".
+const SEPARATOR = "!/"
+
+// Bytes peeked from each entry to recognise nested archives and images.
+const PEEK_BYTES = 512
+
+type Kind int
+
+const (
+	KIND_NONE Kind = iota
+	KIND_ZIP
+	KIND_TAR
+	KIND_GZIP
+)
+
+// An archive is nested deeper than Limits.MaxDepth.
+var ErrTooDeep = errors.New("archive nested too deeply")
+
+// An entry decompresses to more than Limits.MaxEntryBytes.
+var ErrTooLarge = errors.New("archive entry too large")
+
+// An archive decompresses to more than Limits.MaxTotalBytes in total.
+// Unlike the other errors this stops the walk.
+var ErrTotalTooLarge = errors.New("archive too large")
+
+type Limits struct {
+	// Number of archives that may be nested inside each other, the
+	// outermost counting as one. Values below 1 mean 1.
+	MaxDepth int
+	// Largest decompressed size of one entry; nested zip archives are
+	// held in memory whole, so this also bounds them. 0 means no limit.
+	MaxEntryBytes int64
+	// Largest decompressed size of all entries, nested ones included, and
+	// of a zip archive given to WalkReader. 0 means no limit.
+	MaxTotalBytes int64
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		MaxDepth:      4,
+		MaxEntryBytes: 256 << 20,
+		MaxTotalBytes: 8 << 30,
+	}
+}
+
+/**
+ * Visit is called for every regular file in an archive, in archive order.
+ * r yields the decompressed content and is only valid during the call.
+ * For entries that cannot be read, are over a limit or are corrupt nested
+ * archives, r is nil and err says why. Returning an error stops the walk
+ * and is returned by Walk.
+ */
+type Visit func(path string, r io.Reader, err error) error
+
+// Detect recognises an archive from the first PEEK_BYTES of a file.
+func Detect(header []byte) Kind {
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return KIND_ZIP
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return KIND_GZIP
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return KIND_TAR
+	}
+	return KIND_NONE
+}
+
+func DetectFile(path string) (Kind, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return KIND_NONE, err
+	}
+	defer f.Close()
+
+	header := make([]byte, PEEK_BYTES)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return KIND_NONE, err
+	}
+	return Detect(header[:n]), nil
+}
+
+// Walk visits the files in the archive at path.
+func Walk(path string, limits Limits, visit Visit) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	w := newWalker(limits, visit)
+	header := make([]byte, PEEK_BYTES)
+	n, err := f.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	kind := Detect(header[:n])
+	if kind == KIND_ZIP {
+		return unwrapStop(w.walkZip(path, f, info.Size(), 1))
+	}
+	return unwrapStop(w.walk(kind, path, bufio.NewReaderSize(f, PEEK_BYTES), 1))
+}
+
+// WalkReader is Walk for an archive that is not a file, such as an
+// upload. A zip archive is read into memory first, failing with
+// ErrTotalTooLarge if it is larger than Limits.MaxTotalBytes.
+func WalkReader(name string, r io.Reader, limits Limits, visit Visit) error {
+	w := newWalker(limits, visit)
+	br := bufio.NewReaderSize(r, PEEK_BYTES)
+	header, _ := br.Peek(PEEK_BYTES)
+	kind := Detect(header)
+	if kind == KIND_ZIP && limits.MaxTotalBytes > 0 {
+		remaining := limits.MaxTotalBytes
+		br = bufio.NewReaderSize(&limitedReader{br, &remaining, ErrTotalTooLarge}, PEEK_BYTES)
+	}
+	return unwrapStop(w.walk(kind, name, br, 1))
+}
+
+// HashImages hashes every image in the archive at path, calling fn with
+// the hash or the reason the entry could not be hashed.
+func HashImages(hasher *pdq.PDQHasher, path string, limits Limits, fn func(path string, hash pdq.HashAndQuality, err error) error) error {
+	return Walk(path, limits, func(entryPath string, r io.Reader, err error) error {
+		if err != nil {
+			return fn(entryPath, pdq.HashAndQuality{}, err)
+		}
+		br := bufio.NewReaderSize(r, PEEK_BYTES)
+		header, _ := br.Peek(PEEK_BYTES)
+		if !filetype.IsImage(header) {
+			return nil
+		}
+		hash, err := hasher.FromReader(br)
+		return fn(entryPath, hash, err)
+	})
+}
+
+// Wraps errors returned by Visit so that they are not mistaken for a
+// problem with a nested archive.
+type stopError struct {
+	err error
+}
+
+func (e *stopError) Error() string {
+	return e.err.Error()
+}
+
+func unwrapStop(err error) error {
+	var stop *stopError
+	if errors.As(err, &stop) {
+		return stop.err
+	}
+	return err
+}
+
+type walker struct {
+	limits Limits
+	visit  Visit
+	// Decompressed bytes still allowed by MaxTotalBytes.
+	remaining int64
+}
+
+func newWalker(limits Limits, visit Visit) *walker {
+	w := &walker{limits: limits, visit: visit, remaining: limits.MaxTotalBytes}
+	if w.limits.MaxDepth < 1 {
+		w.limits.MaxDepth = 1
+	}
+	return w
+}
+
+func (w *walker) walk(kind Kind, name string, r *bufio.Reader, depth int) error {
+	switch kind {
+	case KIND_ZIP:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return w.walkZip(name, bytes.NewReader(data), int64(len(data)), depth)
+	case KIND_TAR:
+		return w.walkTar(name, tar.NewReader(r), depth)
+	case KIND_GZIP:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		inner := bufio.NewReaderSize(gz, PEEK_BYTES)
+		header, _ := inner.Peek(PEEK_BYTES)
+		if Detect(header) == KIND_TAR {
+			return w.walkTar(name, tar.NewReader(inner), depth)
+		}
+		// A single gzipped file.
+		member := gz.Name
+		if member == "" {
+			member = strings.TrimSuffix(path.Base(name), ".gz")
+		}
+		return w.entry(name+SEPARATOR+member, inner, -1, depth)
+	}
+	return fmt.Errorf("%s: not a zip, tar or gzip archive", name)
+}
+
+func (w *walker) walkZip(name string, r io.ReaderAt, size int64, depth int) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, file := range zr.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		entryPath := name + SEPARATOR + cleanEntryName(file.Name)
+		rc, err := file.Open()
+		if err != nil {
+			if err := w.report(entryPath, err); err != nil {
+				return err
+			}
+			continue
+		}
+		err = w.entry(entryPath, rc, int64(file.UncompressedSize64), depth)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *walker) walkTar(name string, tr *tar.Reader, depth int) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		err = w.entry(name+SEPARATOR+cleanEntryName(header.Name), tr, header.Size, depth)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Visits one entry, descending into it if it is an archive itself. size
+// is the declared size, -1 if unknown; it is not trusted.
+func (w *walker) entry(entryPath string, r io.Reader, size int64, depth int) error {
+	if w.limits.MaxEntryBytes > 0 && size > w.limits.MaxEntryBytes {
+		return w.report(entryPath, ErrTooLarge)
+	}
+
+	if w.limits.MaxTotalBytes > 0 {
+		r = &limitedReader{r, &w.remaining, ErrTotalTooLarge}
+	}
+	if w.limits.MaxEntryBytes > 0 {
+		remaining := w.limits.MaxEntryBytes
+		r = &limitedReader{r, &remaining, ErrTooLarge}
+	}
+	br := bufio.NewReaderSize(r, PEEK_BYTES)
+	header, err := br.Peek(PEEK_BYTES)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return w.report(entryPath, err)
+	}
+
+	kind := Detect(header)
+	if kind == KIND_NONE {
+		if err := w.visit(entryPath, br, nil); err != nil {
+			return &stopError{err}
+		}
+		return w.exhausted()
+	}
+
+	if depth >= w.limits.MaxDepth {
+		return w.report(entryPath, ErrTooDeep)
+	}
+	err = w.walk(kind, entryPath, br, depth+1)
+	var stop *stopError
+	if err == nil || errors.As(err, &stop) || errors.Is(err, ErrTotalTooLarge) {
+		return err
+	}
+	return w.report(entryPath, err)
+}
+
+// Passes an entry-level problem to visit. The total size budget running
+// out while reading an entry is reported once and ends the walk.
+func (w *walker) report(entryPath string, err error) error {
+	if errors.Is(err, ErrTotalTooLarge) {
+		return err
+	}
+	if err := w.visit(entryPath, nil, err); err != nil {
+		return &stopError{err}
+	}
+	return w.exhausted()
+}
+
+// Whether the visitor ran through the total size budget while reading.
+func (w *walker) exhausted() error {
+	if w.limits.MaxTotalBytes > 0 && w.remaining < 0 {
+		return ErrTotalTooLarge
+	}
+	return nil
+}
+
+// Reads until remaining drops below zero, then fails with err. remaining
+// may be shared between readers.
+type limitedReader struct {
+	r         io.Reader
+	remaining *int64
+	err       error
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if *l.remaining < 0 {
+		return 0, l.err
+	}
+	n, err := l.r.Read(p)
+	*l.remaining -= int64(n)
+	if *l.remaining < 0 {
+		return n, l.err
+	}
+	return n, err
+}
+
+func cleanEntryName(name string) string {
+	return path.Clean("/" + name)[1:]
+}
@@ -0,0 +1,195 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type file struct {
+	name string
+	data []byte
+}
+
+func zipOf(t *testing.T, files ...file) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		assert.ErrorIs(t, err, nil)
+		w.Write(f.data)
+	}
+	assert.ErrorIs(t, zw.Close(), nil)
+	return buf.Bytes()
+}
+
+func tarOf(t *testing.T, files ...file) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.data)), Typeflag: tar.TypeReg})
+		assert.ErrorIs(t, err, nil)
+		tw.Write(f.data)
+	}
+	assert.ErrorIs(t, tw.Close(), nil)
+	return buf.Bytes()
+}
+
+func gzipOf(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write(data)
+	assert.ErrorIs(t, gw.Close(), nil)
+	return buf.Bytes()
+}
+
+type visited struct {
+	path string
+	data string
+	err  error
+}
+
+func collect(entries *[]visited) Visit {
+	return func(path string, r io.Reader, err error) error {
+		if err != nil {
+			*entries = append(*entries, visited{path, "", err})
+			return nil
+		}
+		data, err := io.ReadAll(r)
+		*entries = append(*entries, visited{path, string(data), err})
+		return nil
+	}
+}
+
+func writeTemp(t *testing.T, name string, data []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.ErrorIs(t, os.WriteFile(path, data, 0o644), nil)
+	return path
+}
+
+func TestDetect(t *testing.T) {
+	assert.Equal(t, KIND_ZIP, Detect(zipOf(t, file{"a", []byte("a")})))
+	assert.Equal(t, KIND_ZIP, Detect(zipOf(t)))
+	assert.Equal(t, KIND_TAR, Detect(tarOf(t, file{"a", []byte("a")})))
+	assert.Equal(t, KIND_GZIP, Detect(gzipOf(t, []byte("a"))))
+	assert.Equal(t, KIND_NONE, Detect([]byte("\xff\xd8\xff\xe0 JFIF")))
+	assert.Equal(t, KIND_NONE, Detect(nil))
+}
+
+func TestWalkNested(t *testing.T) {
+	inner := tarOf(t, file{"c.txt", []byte("c")}, file{"d/e.txt", []byte("e")})
+	outer := zipOf(t,
+		file{"a.txt", []byte("a")},
+		file{"dir/", nil},
+		file{"../b.txt", []byte("b")},
+		file{"inner.tar.gz", gzipOf(t, inner)},
+	)
+	path := writeTemp(t, "drop.zip", outer)
+
+	var entries []visited
+	assert.ErrorIs(t, Walk(path, DefaultLimits(), collect(&entries)), nil)
+	assert.Equal(t, []visited{
+		{path + "!/a.txt", "a", nil},
+		{path + "!/b.txt", "b", nil},
+		{path + "!/inner.tar.gz!/c.txt", "c", nil},
+		{path + "!/inner.tar.gz!/d/e.txt", "e", nil},
+	}, entries)
+
+	// The same through a reader, and from a tar.gz at the top.
+	entries = nil
+	assert.ErrorIs(t, WalkReader("drop.zip", bytes.NewReader(outer), DefaultLimits(), collect(&entries)), nil)
+	assert.Len(t, entries, 4)
+	assert.Equal(t, "drop.zip!/inner.tar.gz!/d/e.txt", entries[3].path)
+
+	entries = nil
+	assert.ErrorIs(t, Walk(writeTemp(t, "x.tar.gz", gzipOf(t, tarOf(t, file{"zip.zip", outer}))), DefaultLimits(), collect(&entries)), nil)
+	assert.Len(t, entries, 4)
+	assert.True(t, strings.HasSuffix(entries[2].path, "x.tar.gz!/zip.zip!/inner.tar.gz!/c.txt"))
+}
+
+func TestWalkDepthLimit(t *testing.T) {
+	inner := zipOf(t, file{"c.txt", []byte("c")})
+	path := writeTemp(t, "drop.zip", zipOf(t, file{"a.txt", []byte("a")}, file{"inner.zip", inner}))
+
+	var entries []visited
+	assert.ErrorIs(t, Walk(path, Limits{MaxDepth: 1}, collect(&entries)), nil)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, path+"!/inner.zip", entries[1].path)
+	assert.ErrorIs(t, entries[1].err, ErrTooDeep)
+}
+
+func TestWalkSizeLimits(t *testing.T) {
+	big := bytes.Repeat([]byte("0"), 1<<20)
+	path := writeTemp(t, "bomb.zip", zipOf(t, file{"a.txt", []byte("a")}, file{"big.txt", big}, file{"c.txt", []byte("c")}))
+
+	var entries []visited
+	assert.ErrorIs(t, Walk(path, Limits{MaxEntryBytes: 1024}, collect(&entries)), nil)
+	assert.Len(t, entries, 3)
+	assert.ErrorIs(t, entries[1].err, ErrTooLarge)
+	assert.Equal(t, "c", entries[2].data)
+
+	// A gzip member has no declared size, so the limit trips while reading.
+	entries = nil
+	gz := writeTemp(t, "big.txt.gz", gzipOf(t, big))
+	assert.ErrorIs(t, Walk(gz, Limits{MaxEntryBytes: 1024}, collect(&entries)), nil)
+	assert.Len(t, entries, 1)
+	assert.ErrorIs(t, entries[0].err, ErrTooLarge)
+
+	entries = nil
+	err := Walk(path, Limits{MaxTotalBytes: 1024}, collect(&entries))
+	assert.ErrorIs(t, err, ErrTotalTooLarge)
+	assert.Len(t, entries, 2)
+}
+
+func TestWalkReaderSizeLimit(t *testing.T) {
+	// Random data does not compress, so the zip is as large as its entry.
+	noise := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(noise)
+	upload := zipOf(t, file{"noise.bin", noise})
+
+	var entries []visited
+	err := WalkReader("upload.zip", bytes.NewReader(upload), Limits{MaxTotalBytes: 1024}, collect(&entries))
+	assert.ErrorIs(t, err, ErrTotalTooLarge)
+	assert.Empty(t, entries)
+
+	assert.ErrorIs(t, WalkReader("upload.zip", bytes.NewReader(upload), Limits{MaxTotalBytes: 1 << 20}, collect(&entries)), nil)
+	assert.Len(t, entries, 1)
+}
+
+func TestWalkCorruptNestedArchive(t *testing.T) {
+	corrupt := zipOf(t, file{"c.txt", []byte("c")})[:10]
+	path := writeTemp(t, "drop.zip", zipOf(t, file{"bad.zip", corrupt}, file{"a.txt", []byte("a")}))
+
+	var entries []visited
+	assert.ErrorIs(t, Walk(path, DefaultLimits(), collect(&entries)), nil)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, path+"!/bad.zip", entries[0].path)
+	assert.NotNil(t, entries[0].err)
+	assert.Equal(t, "a", entries[1].data)
+}
+
+func TestWalkStops(t *testing.T) {
+	stop := errors.New("stop")
+	path := writeTemp(t, "drop.tar", tarOf(t, file{"a.txt", []byte("a")}, file{"b.txt", []byte("b")}))
+
+	calls := 0
+	err := Walk(path, DefaultLimits(), func(path string, r io.Reader, err error) error {
+		calls++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, calls)
+
+	err = Walk(writeTemp(t, "a.txt", []byte("plain")), DefaultLimits(), collect(new([]visited)))
+	assert.ErrorContains(t, err, "not a zip, tar or gzip archive")
+}
@@ -0,0 +1,158 @@
+package pdq
+
+import (
+	"fmt"
+	"math"
+)
+
+// Largest luma difference (0..255) from a border's colour that still
+// counts as border; enough to absorb JPEG ringing in black bars.
+const DEFAULT_BORDER_TOLERANCE = 10.0
+
+// Largest fraction of the width or height trimmed from each side.
+const DEFAULT_MAX_BORDER_FRACTION = 0.25
+
+/**
+ * Settings for removing letterboxing and solid frames before hashing.
+ * Reposts often add these, and since the decimation samples a 64x64 grid
+ * over the whole image, they shift every sample and change most bits.
+ */
+type BorderTrim struct {
+	// Largest difference from the colour of a side's outermost line for
+	// which a line still counts as border.
+	Tolerance float64
+	// Largest fraction of the width (height) trimmed from the left and
+	// from the right (top and bottom) each.
+	MaxFraction float64
+}
+
+func DefaultBorderTrim() *BorderTrim {
+	return &BorderTrim{Tolerance: DEFAULT_BORDER_TOLERANCE, MaxFraction: DEFAULT_MAX_BORDER_FRACTION}
+}
+
+// Rejects a negative Tolerance, and a MaxFraction outside [0, 0.5), with
+// which opposite sides could meet and trim away the whole image.
+func (b *BorderTrim) Validate() error {
+	if !(b.Tolerance >= 0) {
+		return fmt.Errorf("border tolerance %v is negative", b.Tolerance)
+	}
+	if !(b.MaxFraction >= 0 && b.MaxFraction < 0.5) {
+		return fmt.Errorf("max border fraction %v is outside [0, 0.5)", b.MaxFraction)
+	}
+	return nil
+}
+
+// A rectangle of an image, such as the part that was hashed after
+// trimming its borders or a region to hash, in pixels of the decoded
+// image. Right and Bottom are exclusive.
+type CropBox struct {
+	Left   int
+	Top    int
+	Right  int
+	Bottom int
+}
+
+func (b *CropBox) String() string {
+	return fmt.Sprintf("%d,%d,%d,%d", b.Left, b.Top, b.Right, b.Bottom)
+}
+
+// Maps a box from an image of fromCols x fromRows to one of toCols x
+// toRows, such as from the thumbnail back to the decoded image.
+func (b *CropBox) scale(fromCols, fromRows, toCols, toRows int) *CropBox {
+	if b == nil || (fromCols == toCols && fromRows == toRows) {
+		return b
+	}
+	x := func(v int) int { return int(math.Round(float64(v) * float64(toCols) / float64(fromCols))) }
+	y := func(v int) int { return int(math.Round(float64(v) * float64(toRows) / float64(fromRows))) }
+	return &CropBox{x(b.Left), y(b.Top), x(b.Right), y(b.Bottom)}
+}
+
+/**
+ * With p.BorderTrim set, finds uniform borders of the numRows x numCols
+ * luma buffer and moves the rest to the start of the buffer. Returns the
+ * new dimensions and the box that was kept, which is nil when there was
+ * nothing to trim, or an error if the settings are invalid.
+ */
+func (p *PDQHasher) trimBorders(luma []float64, numRows, numCols int) (int, int, *CropBox, error) {
+	return trimLuma(p.BorderTrim, luma, numRows, numCols)
+}
+
+// trimBorders for luma of either precision. An image that would be
+// trimmed to nothing is hashed whole.
+func trimLuma[F lumaFloat](trim *BorderTrim, luma []F, numRows, numCols int) (int, int, *CropBox, error) {
+	if trim == nil {
+		return numRows, numCols, nil, nil
+	}
+	if err := trim.Validate(); err != nil {
+		return numRows, numCols, nil, err
+	}
+	box := findBorders(luma, numRows, numCols, trim.Tolerance, trim.MaxFraction)
+	if box.Left == 0 && box.Top == 0 && box.Right == numCols && box.Bottom == numRows {
+		return numRows, numCols, nil, nil
+	}
+	if box.Left >= box.Right || box.Top >= box.Bottom {
+		return numRows, numCols, nil, nil
+	}
+
+	rows := box.Bottom - box.Top
+	cols := box.Right - box.Left
+	// Rows only move towards the start, so copying forwards is safe.
+	for i := 0; i < rows; i++ {
+		src := (box.Top+i)*numCols + box.Left
+		copy(luma[i*cols:(i+1)*cols], luma[src:src+cols])
+	}
+	return rows, cols, box, nil
+}
+
+/**
+ * Trims the top and bottom first, along full rows, then the left and
+ * right along what is left of the columns, so that the corners of a frame
+ * do not count twice. A side's colour is the mean of its outermost line,
+ * which must itself be uniform.
+ */
+func findBorders[F lumaFloat](luma []F, numRows, numCols int, tolerance, maxFraction float64) *CropBox {
+	box := &CropBox{0, 0, numCols, numRows}
+	maxRows := int(float64(numRows) * maxFraction)
+	maxCols := int(float64(numCols) * maxFraction)
+
+	row := func(i int) func(k int) float64 {
+		return func(k int) float64 { return float64(luma[i*numCols+k]) }
+	}
+	col := func(j int) func(k int) float64 {
+		return func(k int) float64 { return float64(luma[k*numCols+j]) }
+	}
+
+	bottom := func(n int) func(k int) float64 { return row(numRows - 1 - n) }
+	right := func(n int) func(k int) float64 { return col(numCols - 1 - n) }
+
+	box.Top = borderWidth(maxRows, row, 0, numCols, tolerance)
+	box.Bottom = numRows - borderWidth(maxRows, bottom, 0, numCols, tolerance)
+	box.Left = borderWidth(maxCols, col, box.Top, box.Bottom, tolerance)
+	box.Right = numCols - borderWidth(maxCols, right, box.Top, box.Bottom, tolerance)
+	return box
+}
+
+// Counts the lines, from the outermost one inwards, whose values from
+// start to end all lie within tolerance of the outermost line's mean.
+func borderWidth(maxLines int, line func(n int) func(k int) float64, start, end int, tolerance float64) int {
+	if maxLines <= 0 || end <= start {
+		return 0
+	}
+	outer := line(0)
+	sum := 0.0
+	for k := start; k < end; k++ {
+		sum += outer(k)
+	}
+	colour := sum / float64(end-start)
+
+	n := 0
+	for ; n < maxLines; n++ {
+		values := line(n)
+		for k := start; k < end; k++ {
+			if math.Abs(values(k)-colour) > tolerance {
+				return n
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,181 @@
+// Package calibration helps choose a match threshold. Given the Hamming
+// distances of image pairs labelled as the same or different images, it
+// computes the distance histogram of each class, the ROC and
+// precision/recall curves over every threshold, and the largest threshold
+// that keeps the false positive rate under a target.
+//
+// A pair matches at a threshold when its distance is at most the
+// threshold, so same pairs that match are true positives and different
+// pairs that match are false positives.
+package calibration
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Largest possible distance between two 256 bit hashes.
+const MAX_DISTANCE = 256
+
+// False positive rate Calibrate aims for when none is given.
+const DEFAULT_TARGET_FPR = 0.001
+
+var CSV_HEADER = []string{"threshold", "same", "different", "tp", "fp", "tn", "fn", "tpr", "fpr", "precision", "recall"}
+
+// Label spellings accepted by ReadPairs.
+var sameLabels = map[string]bool{
+	"same": true, "match": true, "1": true, "true": true, "yes": true,
+	"different": false, "nomatch": false, "0": false, "false": false, "no": false,
+}
+
+// Two images or hashes and whether they show the same image.
+type Pair struct {
+	A    string
+	B    string
+	Same bool
+}
+
+/**
+ * Reads pairs from CSV rows of A, B and label, where the label is one of
+ * same/different, match/nomatch, 1/0, true/false or yes/no. A first row
+ * whose label is none of these is taken as a header.
+ */
+func ReadPairs(r io.Reader) ([]Pair, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	var pairs []Pair
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		same, ok := sameLabels[strings.ToLower(record[2])]
+		if !ok {
+			if row == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("row %d: unknown label %q", row, record[2])
+		}
+		pairs = append(pairs, Pair{record[0], record[1], same})
+	}
+	return pairs, nil
+}
+
+// The outcome of matching every pair at one threshold.
+type Point struct {
+	Threshold int
+	TP        int
+	FP        int
+	TN        int
+	FN        int
+	TPR       float64
+	FPR       float64
+	// 1 when nothing matches.
+	Precision float64
+}
+
+type Report struct {
+	// Number of pairs at each distance.
+	Same      [MAX_DISTANCE + 1]int
+	Different [MAX_DISTANCE + 1]int
+	// One point per threshold from 0 to MAX_DISTANCE.
+	Curve []Point
+	// Area under the ROC curve.
+	AUC       float64
+	TargetFPR float64
+	// The largest threshold whose false positive rate is at most
+	// TargetFPR, or -1 if even 0 exceeds it.
+	Threshold int
+}
+
+// Builds the report from the distances of same and of different pairs.
+func Calibrate(same, different []int, targetFPR float64) (*Report, error) {
+	if len(same) == 0 || len(different) == 0 {
+		return nil, errors.New("need both same and different pairs")
+	}
+	r := &Report{TargetFPR: targetFPR, Threshold: -1}
+	for _, d := range same {
+		if d < 0 || d > MAX_DISTANCE {
+			return nil, fmt.Errorf("distance %d out of range", d)
+		}
+		r.Same[d]++
+	}
+	for _, d := range different {
+		if d < 0 || d > MAX_DISTANCE {
+			return nil, fmt.Errorf("distance %d out of range", d)
+		}
+		r.Different[d]++
+	}
+
+	positives, negatives := len(same), len(different)
+	tp, fp := 0, 0
+	prevTPR, prevFPR := 0.0, 0.0
+	for t := 0; t <= MAX_DISTANCE; t++ {
+		tp += r.Same[t]
+		fp += r.Different[t]
+		p := Point{
+			Threshold: t,
+			TP:        tp,
+			FP:        fp,
+			TN:        negatives - fp,
+			FN:        positives - tp,
+			TPR:       float64(tp) / float64(positives),
+			FPR:       float64(fp) / float64(negatives),
+			Precision: 1,
+		}
+		if tp+fp > 0 {
+			p.Precision = float64(tp) / float64(tp+fp)
+		}
+		r.Curve = append(r.Curve, p)
+		r.AUC += (p.FPR - prevFPR) * (p.TPR + prevTPR) / 2
+		prevTPR, prevFPR = p.TPR, p.FPR
+		if p.FPR <= targetFPR {
+			r.Threshold = t
+		}
+	}
+	return r, nil
+}
+
+// The point at the chosen threshold, if there is one.
+func (r *Report) Chosen() (Point, bool) {
+	if r.Threshold < 0 {
+		return Point{}, false
+	}
+	return r.Curve[r.Threshold], true
+}
+
+// Writes one CSV row per threshold.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write(CSV_HEADER)
+	for _, p := range r.Curve {
+		cw.Write([]string{
+			strconv.Itoa(p.Threshold),
+			strconv.Itoa(r.Same[p.Threshold]),
+			strconv.Itoa(r.Different[p.Threshold]),
+			strconv.Itoa(p.TP),
+			strconv.Itoa(p.FP),
+			strconv.Itoa(p.TN),
+			strconv.Itoa(p.FN),
+			formatRate(p.TPR),
+			formatRate(p.FPR),
+			formatRate(p.Precision),
+			formatRate(p.TPR),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatRate(f float64) string {
+	return strconv.FormatFloat(f, 'f', 6, 64)
+}
@@ -0,0 +1,83 @@
+package calibration
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadPairs(t *testing.T) {
+	input := "a,b,label\n# comment\nx.jpg,y.jpg,same\nx.jpg, z.jpg, Different\np,q,1\np,r,0\n"
+	pairs, err := ReadPairs(strings.NewReader(input))
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, []Pair{
+		{"x.jpg", "y.jpg", true},
+		{"x.jpg", "z.jpg", false},
+		{"p", "q", true},
+		{"p", "r", false},
+	}, pairs)
+
+	_, err = ReadPairs(strings.NewReader("x,y,same\nx,z,maybe\n"))
+	assert.ErrorContains(t, err, `row 2: unknown label "maybe"`)
+	_, err = ReadPairs(strings.NewReader("x,y\n"))
+	assert.Error(t, err)
+}
+
+func TestCalibrate(t *testing.T) {
+	report, err := Calibrate([]int{0, 2, 4, 10}, []int{8, 50, 100, 120}, 0.25)
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, report.Curve, MAX_DISTANCE+1)
+	assert.Equal(t, 1, report.Same[2])
+	assert.Equal(t, 1, report.Different[8])
+
+	assert.Equal(t, Point{Threshold: 4, TP: 3, FP: 0, TN: 4, FN: 1, TPR: 0.75, FPR: 0, Precision: 1}, report.Curve[4])
+	assert.Equal(t, Point{Threshold: 8, TP: 3, FP: 1, TN: 3, FN: 1, TPR: 0.75, FPR: 0.25, Precision: 0.75}, report.Curve[8])
+	// The largest threshold with at most one false positive.
+	assert.Equal(t, 49, report.Threshold)
+	chosen, ok := report.Chosen()
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, chosen.TPR)
+	// One different pair is closer than one same pair.
+	assert.InDelta(t, 1-1.0/16, report.AUC, 1e-9)
+
+	report, err = Calibrate([]int{5}, []int{0}, 0)
+	assert.ErrorIs(t, err, nil)
+	_, ok = report.Chosen()
+	assert.False(t, ok)
+	assert.Equal(t, -1, report.Threshold)
+	assert.Equal(t, 0.0, report.AUC)
+
+	_, err = Calibrate([]int{1}, nil, 0.1)
+	assert.Error(t, err)
+	_, err = Calibrate([]int{257}, []int{1}, 0.1)
+	assert.Error(t, err)
+}
+
+func TestWriteReports(t *testing.T) {
+	report, err := Calibrate([]int{0, 2, 4, 10}, []int{8, 50, 100, 120}, 0.25)
+	assert.ErrorIs(t, err, nil)
+
+	var b bytes.Buffer
+	assert.ErrorIs(t, report.WriteCSV(&b), nil)
+	records, err := csv.NewReader(&b).ReadAll()
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, records, MAX_DISTANCE+2)
+	assert.Equal(t, CSV_HEADER, records[0])
+	assert.Equal(t, []string{"8", "0", "1", "3", "1", "3", "1", "0.750000", "0.250000", "0.750000", "0.750000"}, records[9])
+
+	b.Reset()
+	assert.ErrorIs(t, report.WriteHTML(&b), nil)
+	html := b.String()
+	assert.True(t, strings.HasPrefix(html, "<!DOCTYPE html>"))
+	assert.Equal(t, 3, strings.Count(html, "<svg "))
+	assert.Contains(t, html, "<tr><th>Threshold</th><td>49</td></tr>")
+	assert.Contains(t, html, "<polyline")
+
+	report, _ = Calibrate([]int{5}, []int{0}, 0)
+	b.Reset()
+	assert.ErrorIs(t, report.WriteHTML(&b), nil)
+	assert.Contains(t, b.String(), "none meets the target")
+}
@@ -0,0 +1,181 @@
+package calibration
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// Size of each chart in SVG units, inside a margin for the axes.
+const CHART_WIDTH = 480
+const CHART_HEIGHT = 320
+const CHART_MARGIN = 40
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>PDQ threshold calibration</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+svg { border: 1px solid #ccc; margin: 0 1em 1em 0; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 0.8em; text-align: right; border-bottom: 1px solid #ddd; }
+.same { fill: #2a7ab0; stroke: #2a7ab0; }
+.different { fill: #d0632a; stroke: #d0632a; }
+.curve { fill: none; stroke: #2a7ab0; stroke-width: 2; }
+.axis { stroke: #444; }
+.marker { stroke: #999; stroke-dasharray: 4 3; }
+text { font-size: 12px; }
+</style>
+</head>
+<body>
+<h1>PDQ threshold calibration</h1>
+<table>
+<tr><th>Same pairs</th><td>{{.Positives}}</td></tr>
+<tr><th>Different pairs</th><td>{{.Negatives}}</td></tr>
+<tr><th>ROC AUC</th><td>{{printf "%.4f" .AUC}}</td></tr>
+<tr><th>Target false positive rate</th><td>{{.TargetFPR}}</td></tr>
+{{with .Chosen}}
+<tr><th>Threshold</th><td>{{.Threshold}}</td></tr>
+<tr><th>True positive rate</th><td>{{printf "%.4f" .TPR}}</td></tr>
+<tr><th>False positive rate</th><td>{{printf "%.6f" .FPR}}</td></tr>
+<tr><th>Precision</th><td>{{printf "%.4f" .Precision}}</td></tr>
+{{else}}
+<tr><th>Threshold</th><td>none meets the target</td></tr>
+{{end}}
+</table>
+
+<h2>Distance histograms</h2>
+{{.Histogram}}
+
+<h2>ROC and precision/recall</h2>
+{{.ROC}}
+{{.PR}}
+</body>
+</html>
+`))
+
+// Writes a standalone HTML page with the summary and the charts as inline
+// SVG.
+func (r *Report) WriteHTML(w io.Writer) error {
+	positives, negatives := 0, 0
+	for d := range r.Same {
+		positives += r.Same[d]
+		negatives += r.Different[d]
+	}
+	var chosen *Point
+	if p, ok := r.Chosen(); ok {
+		chosen = &p
+	}
+
+	roc := make([][2]float64, 0, len(r.Curve)+1)
+	roc = append(roc, [2]float64{0, 0})
+	pr := make([][2]float64, 0, len(r.Curve))
+	for _, p := range r.Curve {
+		roc = append(roc, [2]float64{p.FPR, p.TPR})
+		pr = append(pr, [2]float64{p.TPR, p.Precision})
+	}
+	var rocMarker, prMarker *[2]float64
+	if chosen != nil {
+		rocMarker = &[2]float64{chosen.FPR, chosen.TPR}
+		prMarker = &[2]float64{chosen.TPR, chosen.Precision}
+	}
+
+	return htmlTemplate.Execute(w, map[string]any{
+		"Positives": positives,
+		"Negatives": negatives,
+		"AUC":       r.AUC,
+		"TargetFPR": r.TargetFPR,
+		"Chosen":    chosen,
+		"Histogram": r.histogramSVG(),
+		"ROC":       curveSVG("ROC", "false positive rate", "true positive rate", roc, rocMarker),
+		"PR":        curveSVG("Precision/recall", "recall", "precision", pr, prMarker),
+	})
+}
+
+// Both classes as fractions of their pair count, so that classes of very
+// different sizes can be compared.
+func (r *Report) histogramSVG() template.HTML {
+	var same, different [MAX_DISTANCE + 1]float64
+	positives, negatives := 0, 0
+	for d := range r.Same {
+		positives += r.Same[d]
+		negatives += r.Different[d]
+	}
+	peak := 0.0
+	for d := range r.Same {
+		same[d] = float64(r.Same[d]) / float64(max(positives, 1))
+		different[d] = float64(r.Different[d]) / float64(max(negatives, 1))
+		peak = max(peak, same[d], different[d])
+	}
+	if peak == 0 {
+		peak = 1
+	}
+
+	var b strings.Builder
+	openSVG(&b, "distance", "fraction of pairs")
+	barWidth := float64(CHART_WIDTH) / (MAX_DISTANCE + 1)
+	for d := range same {
+		for _, bar := range []struct {
+			class string
+			value float64
+		}{{"same", same[d]}, {"different", different[d]}} {
+			if bar.value == 0 {
+				continue
+			}
+			h := bar.value / peak * CHART_HEIGHT
+			fmt.Fprintf(&b, `<rect class="%s" fill-opacity="0.6" x="%.2f" y="%.2f" width="%.2f" height="%.2f"/>`,
+				bar.class, CHART_MARGIN+float64(d)*barWidth, CHART_MARGIN+CHART_HEIGHT-h, barWidth, h)
+		}
+	}
+	if r.Threshold >= 0 {
+		x := CHART_MARGIN + (float64(r.Threshold)+1)*barWidth
+		fmt.Fprintf(&b, `<line class="marker" x1="%.2f" y1="%d" x2="%.2f" y2="%d"/>`, x, CHART_MARGIN, x, CHART_MARGIN+CHART_HEIGHT)
+	}
+	for _, tick := range []int{0, 64, 128, 192, 256} {
+		fmt.Fprintf(&b, `<text x="%.2f" y="%d" text-anchor="middle">%d</text>`, CHART_MARGIN+float64(tick)*barWidth, CHART_MARGIN+CHART_HEIGHT+15, tick)
+	}
+	fmt.Fprintf(&b, `<text class="same" x="%d" y="25">same</text><text class="different" x="%d" y="25">different</text>`, CHART_WIDTH-70, CHART_WIDTH-30)
+	b.WriteString("</svg>\n")
+	return template.HTML(b.String())
+}
+
+// A curve through points with both coordinates from 0 to 1.
+func curveSVG(title, xLabel, yLabel string, points [][2]float64, marker *[2]float64) template.HTML {
+	var b strings.Builder
+	openSVG(&b, xLabel, yLabel)
+	fmt.Fprintf(&b, `<text x="%d" y="25">%s</text>`, CHART_MARGIN, template.HTMLEscapeString(title))
+	coords := make([]string, len(points))
+	for i, p := range points {
+		x, y := chartPoint(p)
+		coords[i] = fmt.Sprintf("%.2f,%.2f", x, y)
+	}
+	fmt.Fprintf(&b, `<polyline class="curve" points="%s"/>`, strings.Join(coords, " "))
+	if marker != nil {
+		x, y := chartPoint(*marker)
+		fmt.Fprintf(&b, `<circle class="different" cx="%.2f" cy="%.2f" r="4"/>`, x, y)
+	}
+	for _, tick := range []float64{0, 0.5, 1} {
+		x, y := chartPoint([2]float64{tick, tick})
+		fmt.Fprintf(&b, `<text x="%.2f" y="%d" text-anchor="middle">%g</text>`, x, CHART_MARGIN+CHART_HEIGHT+15, tick)
+		fmt.Fprintf(&b, `<text x="%d" y="%.2f" text-anchor="end">%g</text>`, CHART_MARGIN-4, y+4, tick)
+	}
+	b.WriteString("</svg>\n")
+	return template.HTML(b.String())
+}
+
+func chartPoint(p [2]float64) (float64, float64) {
+	return CHART_MARGIN + p[0]*CHART_WIDTH, CHART_MARGIN + (1-p[1])*CHART_HEIGHT
+}
+
+// Opens an SVG element with the axes and their labels drawn.
+func openSVG(b *strings.Builder, xLabel, yLabel string) {
+	width, height := CHART_WIDTH+2*CHART_MARGIN, CHART_HEIGHT+2*CHART_MARGIN
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, width, height, width, height)
+	fmt.Fprintf(b, `<line class="axis" x1="%d" y1="%d" x2="%d" y2="%d"/>`, CHART_MARGIN, CHART_MARGIN+CHART_HEIGHT, CHART_MARGIN+CHART_WIDTH, CHART_MARGIN+CHART_HEIGHT)
+	fmt.Fprintf(b, `<line class="axis" x1="%d" y1="%d" x2="%d" y2="%d"/>`, CHART_MARGIN, CHART_MARGIN, CHART_MARGIN, CHART_MARGIN+CHART_HEIGHT)
+	fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="middle">%s</text>`, CHART_MARGIN+CHART_WIDTH/2, height-5, template.HTMLEscapeString(xLabel))
+	fmt.Fprintf(b, `<text x="12" y="%d" text-anchor="middle" transform="rotate(-90 12 %d)">%s</text>`, CHART_MARGIN+CHART_HEIGHT/2, CHART_MARGIN+CHART_HEIGHT/2, template.HTMLEscapeString(yLabel))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/MTRNord/pdqhash-go/calibration"
+	"github.com/MTRNord/pdqhash-go/types"
+)
+
+func (c *cli) runCalibrate(args []string) int {
+	fs := c.flagSet("calibrate", "[flags] PAIRS")
+	targetFPR := fs.Float64("target-fpr", calibration.DEFAULT_TARGET_FPR, "False positive rate the chosen threshold must not exceed")
+	csvPath := fs.String("csv", "", "Write the histograms and curves per threshold to this CSV file")
+	htmlPath := fs.String("html", "", "Write a standalone HTML report to this file")
+	if code, ok := c.parse(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return EXIT_ERROR
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return c.fail(err)
+	}
+	pairs, err := calibration.ReadPairs(f)
+	f.Close()
+	if err != nil {
+		return c.fail(fmt.Errorf("%s: %w", fs.Arg(0), err))
+	}
+
+	// Paths in the pairs file are relative to it, and most images appear
+	// in several pairs.
+	dir := filepath.Dir(fs.Arg(0))
+	hasher := pdq.NewPDQHasher()
+	hashes := map[string]*types.Hash256{}
+	resolve := func(arg string) (*types.Hash256, error) {
+		if hash, ok := hashes[arg]; ok {
+			return hash, nil
+		}
+		path := arg
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			path = arg
+		}
+		hash, err := hashOrImage(hasher, path)
+		if err != nil {
+			return nil, err
+		}
+		hashes[arg] = hash
+		return hash, nil
+	}
+
+	var same, different []int
+	for _, pair := range pairs {
+		a, err := resolve(pair.A)
+		if err != nil {
+			return c.fail(err)
+		}
+		b, err := resolve(pair.B)
+		if err != nil {
+			return c.fail(err)
+		}
+		if pair.Same {
+			same = append(same, a.HammingDistance(b))
+		} else {
+			different = append(different, a.HammingDistance(b))
+		}
+	}
+
+	report, err := calibration.Calibrate(same, different, *targetFPR)
+	if err != nil {
+		return c.fail(err)
+	}
+	if *csvPath != "" {
+		if err := writeFile(*csvPath, report.WriteCSV); err != nil {
+			return c.fail(err)
+		}
+	}
+	if *htmlPath != "" {
+		if err := writeFile(*htmlPath, report.WriteHTML); err != nil {
+			return c.fail(err)
+		}
+	}
+
+	fmt.Fprintf(c.stdout, "%d same and %d different pairs, ROC AUC %.4f\n", len(same), len(different), report.AUC)
+	point, ok := report.Chosen()
+	if !ok {
+		fmt.Fprintf(c.stdout, "no threshold has a false positive rate of at most %g\n", *targetFPR)
+		return EXIT_NO_MATCH
+	}
+	fmt.Fprintf(c.stdout, "threshold %d: true positive rate %.4f, false positive rate %.6f, precision %.4f\n", point.Threshold, point.TPR, point.FPR, point.Precision)
+	return EXIT_OK
+}
+
+// Creates path and fills it with write.
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
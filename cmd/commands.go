@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/MTRNord/pdqhash-go/hashlist"
+	"github.com/MTRNord/pdqhash-go/index"
+	"github.com/MTRNord/pdqhash-go/server"
+	"github.com/MTRNord/pdqhash-go/types"
+)
+
+func (c *cli) runDihedral(args []string) int {
+	fs := c.flagSet("dihedral", "[flags] FILE...")
+	if code, ok := c.parse(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return EXIT_ERROR
+	}
+
+	files, err := c.hashImages(scanOptions{Dihedral: true}, fs.Args())
+	if err != nil {
+		return c.fail(err)
+	}
+	for _, file := range files {
+		for _, hash := range file.dihedral {
+			fmt.Fprintf(c.stdout, "%s,%d,%s,%s\n", hash.Hash.String(), file.Quality, hash.Name, file.Path)
+		}
+	}
+	return EXIT_OK
+}
+
+func (c *cli) runCompare(args []string) int {
+	fs := c.flagSet("compare", "[flags] HASH|FILE HASH|FILE")
+	threshold := fs.Int("threshold", index.DEFAULT_MATCH_THRESHOLD, "Largest Hamming distance counted as a match")
+	if code, ok := c.parse(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return EXIT_ERROR
+	}
+
+	hasher := pdq.NewPDQHasher()
+	a, err := hashOrImage(hasher, fs.Arg(0))
+	if err != nil {
+		return c.fail(err)
+	}
+	b, err := hashOrImage(hasher, fs.Arg(1))
+	if err != nil {
+		return c.fail(err)
+	}
+
+	distance := a.HammingDistance(b)
+	fmt.Fprintf(c.stdout, "%d\n", distance)
+	if distance > *threshold {
+		return EXIT_NO_MATCH
+	}
+	return EXIT_OK
+}
+
+// Accepts either a 64-character hex hash or the path of an image.
+func hashOrImage(hasher *pdq.PDQHasher, arg string) (*types.Hash256, error) {
+	if _, err := os.Stat(arg); err != nil {
+		hash, hexErr := types.Hash256FromHexString(arg)
+		if hexErr != nil {
+			return nil, fmt.Errorf("%q is neither a hash nor a readable file: %w", arg, err)
+		}
+		return hash, nil
+	}
+	hashAndQuality, err := hashFile(hasher, arg)
+	if err != nil {
+		return nil, err
+	}
+	return hashAndQuality.Hash, nil
+}
+
+func (c *cli) runIndex(args []string) int {
+	if len(args) == 0 || args[0] != "build" {
+		fmt.Fprintf(c.stderr, "Usage: scanner index build [flags] PATH...\n")
+		return EXIT_ERROR
+	}
+
+	fs := c.flagSet("index build", "[flags] PATH...")
+	output := fs.String("o", "", "Hash list to write, stdout when empty; a .tsv extension selects the HMA format")
+	precision := precisionFlag(fs)
+	if code, ok := c.parse(fs, args[1:]); !ok {
+		return code
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return EXIT_ERROR
+	}
+
+	files, err := c.hashImages(scanOptions{Precision: string(*precision)}, fs.Args())
+	if err != nil {
+		return c.fail(err)
+	}
+
+	write := func(out io.Writer, format hashlist.Format) error {
+		w := hashlist.NewWriter(out, format)
+		for _, file := range files {
+			err := w.Write(&hashlist.Entry{Hash: file.hash, Quality: file.Quality, ID: file.Path})
+			if err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	}
+	if *output == "" {
+		err = write(c.stdout, hashlist.ThreatExchange)
+	} else {
+		// Closed before exiting, so that a list cut short by a failed
+		// write to disk is not reported as built.
+		err = writeFile(*output, func(f io.Writer) error {
+			return write(f, hashlist.FormatForPath(*output))
+		})
+	}
+	if err != nil {
+		return c.fail(err)
+	}
+	return EXIT_OK
+}
+
+func (c *cli) runDedupe(args []string) int {
+	fs := c.flagSet("dedupe", "[flags] PATH...")
+	threshold := fs.Int("threshold", index.DEFAULT_MATCH_THRESHOLD, "Largest Hamming distance counted as a duplicate")
+	if code, ok := c.parse(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return EXIT_ERROR
+	}
+
+	files, err := c.hashImages(scanOptions{}, fs.Args())
+	if err != nil {
+		return c.fail(err)
+	}
+
+	idx := index.New()
+	for i, file := range files {
+		idx.Add(&hashlist.Entry{Hash: file.hash, Quality: file.Quality, ID: strconv.Itoa(i)})
+	}
+
+	// Union-find over the match graph, so that chains of near-duplicates
+	// end up in one group.
+	parent := make([]int, len(files))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i, file := range files {
+		for _, match := range idx.Query(file.hash, *threshold) {
+			j, _ := strconv.Atoi(match.Entry.ID)
+			a, b := find(i), find(j)
+			if a < b {
+				parent[b] = a
+			} else {
+				parent[a] = b
+			}
+		}
+	}
+
+	groups := map[int][]int{}
+	var roots []int
+	for i := range files {
+		root := find(i)
+		if _, ok := groups[root]; !ok {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], i)
+	}
+
+	// Groups are separated by a blank line; each line carries the distance
+	// to the first file of its group.
+	found := false
+	for _, root := range roots {
+		members := groups[root]
+		if len(members) < 2 {
+			continue
+		}
+		if found {
+			fmt.Fprintln(c.stdout)
+		}
+		found = true
+		first := files[members[0]]
+		for _, i := range members {
+			fmt.Fprintf(c.stdout, "%s,%d,%d,%s\n", files[i].Hash, files[i].Quality, files[i].hash.HammingDistance(first.hash), files[i].Path)
+		}
+	}
+	if !found {
+		return EXIT_NO_MATCH
+	}
+	return EXIT_OK
+}
+
+func (c *cli) runServe(args []string) int {
+	fs := c.flagSet("serve", "[flags]")
+	opts := server.DefaultOptions()
+	opts.RegisterFlags(fs)
+	if code, ok := c.parse(fs, args); !ok {
+		return code
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return c.serve(ctx, opts)
+}
+
+func (c *cli) serve(ctx context.Context, opts server.Options) int {
+	if err := server.Serve(ctx, opts); err != nil {
+		return c.fail(err)
+	}
+	return EXIT_OK
+}
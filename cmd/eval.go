@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/MTRNord/pdqhash-go/robustness"
+)
+
+var SAMPLES_CSV_HEADER = []string{"path", "transform", "distance", "quality", "error"}
+
+// One line of the eval summary; the recall columns follow the thresholds.
+type statsRow struct {
+	robustness.Stats
+}
+
+func (r *statsRow) csvRecord() []string {
+	record := []string{
+		r.Transform,
+		strconv.Itoa(r.Samples),
+		strconv.Itoa(r.Failed),
+		strconv.FormatFloat(r.Mean, 'f', 2, 64),
+		strconv.Itoa(r.Min),
+		strconv.Itoa(r.P50),
+		strconv.Itoa(r.P90),
+		strconv.Itoa(r.Max),
+	}
+	for _, recall := range r.Recall {
+		record = append(record, strconv.FormatFloat(recall.Recall, 'f', 3, 64))
+	}
+	return record
+}
+
+func (r *statsRow) plain(detailed bool) (string, bool) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-12s samples=%d failed=%d mean=%.2f min=%d p50=%d p90=%d max=%d", r.Transform, r.Samples, r.Failed, r.Mean, r.Min, r.P50, r.P90, r.Max)
+	for _, recall := range r.Recall {
+		fmt.Fprintf(&b, " recall@%d=%.3f", recall.Threshold, recall.Recall)
+	}
+	return b.String(), false
+}
+
+func statsHeader(thresholds []int) []string {
+	header := []string{"transform", "samples", "failed", "mean", "min", "p50", "p90", "max"}
+	for _, threshold := range thresholds {
+		header = append(header, "recall@"+strconv.Itoa(threshold))
+	}
+	return header
+}
+
+func (c *cli) runEval(args []string) int {
+	fs := c.flagSet("eval", "[flags] PATH...")
+	defaults := robustness.DefaultOptions()
+	transforms := fs.String("transforms", strings.Join(robustness.DEFAULT_TRANSFORMS, ","), "Comma separated transforms to apply, e.g. jpeg-q50,crop-10,rotate90")
+	thresholds := fs.String("thresholds", joinInts(defaults.Thresholds), "Comma separated distances to report recall at")
+	output := fs.String("output", OUTPUT_PLAIN, fmt.Sprintf("Output format, one of %v", OUTPUT_FORMATS))
+	samplesPath := fs.String("samples", "", "Write the distance of every variant to this CSV file")
+	workers := fs.Int("workers", 1, "Number of images evaluated in parallel")
+	if code, ok := c.parse(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return EXIT_ERROR
+	}
+
+	opts := robustness.Options{Workers: *workers}
+	var err error
+	opts.Transforms, err = robustness.ParseTransforms(strings.Split(*transforms, ","))
+	if err != nil {
+		return c.fail(err)
+	}
+	opts.Thresholds, err = parseInts(*thresholds)
+	if err != nil {
+		return c.fail(fmt.Errorf("-thresholds: %w", err))
+	}
+	out, err := newResultWriter(*output, statsHeader(opts.Thresholds), false, c.stdout, c.stderr)
+	if err != nil {
+		return c.fail(err)
+	}
+
+	// Files that cannot be read count as failed samples of every
+	// transform rather than stopping the walk.
+	var paths []string
+	var unreadable []robustness.Sample
+	walkImages(fs.Args(), func(path string, err error) {
+		if err == nil {
+			paths = append(paths, path)
+			return
+		}
+		for _, transform := range opts.Transforms {
+			unreadable = append(unreadable, robustness.Sample{Path: path, Transform: transform.Name, Err: err})
+		}
+	})
+	if len(paths) == 0 && len(unreadable) == 0 {
+		return c.fail(errors.New("no images found"))
+	}
+
+	report := robustness.Evaluate(paths, opts)
+	report.Samples = append(report.Samples, unreadable...)
+	if *samplesPath != "" {
+		if err := writeSamples(*samplesPath, report.Samples); err != nil {
+			return c.fail(err)
+		}
+	}
+	for _, stats := range report.Stats() {
+		if err := out.Write(&statsRow{stats}); err != nil {
+			return c.fail(err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		return c.fail(err)
+	}
+	return EXIT_OK
+}
+
+func writeSamples(path string, samples []robustness.Sample) error {
+	return writeFile(path, func(f io.Writer) error {
+		w := csv.NewWriter(f)
+		w.Write(SAMPLES_CSV_HEADER)
+		for _, s := range samples {
+			var errText string
+			if s.Err != nil {
+				errText = s.Err.Error()
+			}
+			w.Write([]string{s.Path, s.Transform, strconv.Itoa(s.Distance), strconv.Itoa(s.Quality), errText})
+		}
+		w.Flush()
+		return w.Error()
+	})
+}
+
+func parseInts(s string) ([]int, error) {
+	var values []int
+	for _, field := range strings.Split(s, ",") {
+		value, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func joinInts(values []int) string {
+	fields := make([]string, len(values))
+	for i, value := range values {
+		fields[i] = strconv.Itoa(value)
+	}
+	return strings.Join(fields, ",")
+}
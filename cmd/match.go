@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/MTRNord/pdqhash-go/archive"
+	"github.com/MTRNord/pdqhash-go/hashlist"
+	"github.com/MTRNord/pdqhash-go/index"
+)
+
+// The transform of matches found by the hash of the query as displayed.
+const TRANSFORM_DISPLAYED = "displayed"
+
+var MATCH_CSV_HEADER = []string{"query", "query_hash", "query_quality", "reference", "reference_hash", "reference_quality", "distance", "transform", "region"}
+
+// A query image and a reference within the match threshold. Reference is
+// the path of a reference image or the ID of a hash list entry, whose
+// quality may be hashlist.QUALITY_UNKNOWN.
+type matchResult struct {
+	Query            string `json:"query"`
+	QueryHash        string `json:"query_hash"`
+	QueryQuality     int    `json:"query_quality"`
+	Reference        string `json:"reference"`
+	ReferenceHash    string `json:"reference_hash"`
+	ReferenceQuality int    `json:"reference_quality"`
+	Distance         int    `json:"distance"`
+	// With -dihedral, the transform of the query closest to the reference,
+	// or with -orientation both, TRANSFORM_DISPLAYED if the query as
+	// displayed was closer than its stored pixels.
+	Transform string `json:"transform,omitempty"`
+	// With -tiles or -windows, the region of the query as
+	// left,top,right,bottom, if it was closer than the whole image.
+	Region string `json:"region,omitempty"`
+}
+
+func (m *matchResult) csvRecord() []string {
+	return []string{
+		m.Query,
+		m.QueryHash,
+		strconv.Itoa(m.QueryQuality),
+		m.Reference,
+		m.ReferenceHash,
+		strconv.Itoa(m.ReferenceQuality),
+		strconv.Itoa(m.Distance),
+		m.Transform,
+		m.Region,
+	}
+}
+
+func (m *matchResult) plain(detailed bool) (string, bool) {
+	if m.Transform != "" {
+		return fmt.Sprintf("%d,%d,%d,%s,%s,%s", m.Distance, m.QueryQuality, m.ReferenceQuality, m.Transform, m.Query, m.Reference), false
+	}
+	if m.Region != "" {
+		return fmt.Sprintf("%d,%d,%d,%s,%s,%s", m.Distance, m.QueryQuality, m.ReferenceQuality, m.Query, m.Reference, m.Region), false
+	}
+	return fmt.Sprintf("%d,%d,%d,%s,%s", m.Distance, m.QueryQuality, m.ReferenceQuality, m.Query, m.Reference), false
+}
+
+func (c *cli) runMatch(args []string) int {
+	fs := c.flagSet("match", "-hashlist LIST|-reference PATH [flags] PATH...")
+	list := fs.String("hashlist", "", "Hash list (CSV or TSV) to match against")
+	reference := fs.String("reference", "", "Folder or file of reference images to match against")
+	threshold := fs.Int("threshold", index.DEFAULT_MATCH_THRESHOLD, "Largest Hamming distance counted as a match")
+	dihedral := fs.Bool("dihedral", false, "Also match the rotations and flips of the query images")
+	output := fs.String("output", OUTPUT_PLAIN, fmt.Sprintf("Output format, one of %v", OUTPUT_FORMATS))
+	workers := fs.Int("workers", 1, "Number of files hashed in parallel")
+	tiles := fs.String("tiles", "", "Also match a grid of tiles of the query images, e.g. 3x3")
+	windows := fs.String("windows", "", "Also match sliding windows of the query images, as SIZE,STRIDE fractions of the image, e.g. 0.5,0.25")
+	borderTrim := borderFlags(fs)
+	alpha := alphaFlag(fs)
+	orientation := orientationFlag(fs)
+	precision := precisionFlag(fs)
+	if code, ok := c.parse(fs, args); !ok {
+		return code
+	}
+	if (*list == "") == (*reference == "") || fs.NArg() == 0 {
+		fs.Usage()
+		return EXIT_ERROR
+	}
+	regions, err := parseRegions(*tiles, *windows)
+	if err != nil {
+		return c.fail(err)
+	}
+	if regions != nil && *dihedral {
+		return c.fail(errors.New("-dihedral cannot be combined with -tiles or -windows"))
+	}
+
+	out, err := newResultWriter(*output, MATCH_CSV_HEADER, false, c.stdout, c.stderr)
+	if err != nil {
+		return c.fail(err)
+	}
+	opts := scanOptions{Workers: *workers, Ordered: true, BorderTrim: borderTrim(), AlphaPolicy: alpha.policy, AlphaColor: alpha.color, Orientation: string(*orientation), Precision: string(*precision), Archives: true, ArchiveLimits: archive.DefaultLimits()}
+
+	var idx *index.Index
+	if *list != "" {
+		entries, err := hashlist.ReadFile(*list)
+		if err != nil {
+			return c.fail(err)
+		}
+		idx = index.FromEntries(entries)
+	} else {
+		idx = index.New()
+		_, err := processPaths([]string{*reference}, func(result *fileResult) error {
+			if result.Error != "" {
+				return c.reportFailure(result)
+			}
+			idx.Add(&hashlist.Entry{Hash: result.hash, Quality: result.Quality, ID: result.Path})
+			return nil
+		}, opts)
+		if err != nil {
+			return c.fail(err)
+		}
+	}
+
+	found := false
+	opts.Dihedral = *dihedral
+	opts.Regions = regions
+	_, err = processPaths(fs.Args(), func(result *fileResult) error {
+		if result.Error != "" {
+			return c.reportFailure(result)
+		}
+		for _, match := range matchResults(idx, result, *threshold) {
+			found = true
+			if err := out.Write(match); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, opts)
+	if err != nil {
+		return c.fail(err)
+	}
+	if err := out.Close(); err != nil {
+		return c.fail(err)
+	}
+	if !found {
+		return EXIT_NO_MATCH
+	}
+	return EXIT_OK
+}
+
+func (c *cli) reportFailure(result *fileResult) error {
+	line, _ := result.plain(false)
+	_, err := fmt.Fprintln(c.stderr, line)
+	return err
+}
+
+// Returns the references within threshold of a hashed query, nearest
+// first. With dihedral or region hashes, each reference is reported once,
+// for the transform or region closest to it.
+func matchResults(idx *index.Index, query *fileResult, threshold int) []*matchResult {
+	best := map[*hashlist.Entry]*matchResult{}
+	add := func(transform, region string, matches []index.Match) {
+		for _, match := range matches {
+			if m, ok := best[match.Entry]; ok && m.Distance <= match.Distance {
+				continue
+			}
+			best[match.Entry] = &matchResult{
+				Query:            query.Path,
+				QueryHash:        query.Hash,
+				QueryQuality:     query.Quality,
+				Reference:        match.Entry.ID,
+				ReferenceHash:    match.Entry.Hash.String(),
+				ReferenceQuality: match.Entry.Quality,
+				Distance:         match.Distance,
+				Transform:        transform,
+				Region:           region,
+			}
+		}
+	}
+	if query.dihedral == nil {
+		add("", "", idx.Query(query.hash, threshold))
+	}
+	if query.displayed != nil && *query.displayed != *query.hash {
+		add(TRANSFORM_DISPLAYED, "", idx.Query(query.displayed, threshold))
+	}
+	for _, hash := range query.dihedral {
+		add(hash.Name, "", idx.Query(hash.Hash, threshold))
+	}
+	for _, region := range query.regions {
+		add("", region.Box.String(), idx.Query(region.Hash, threshold))
+	}
+
+	results := make([]*matchResult, 0, len(best))
+	for _, m := range best {
+		results = append(results, m)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Distance != results[j].Distance {
+			return results[i].Distance < results[j].Distance
+		}
+		return results[i].Reference < results[j].Reference
+	})
+	return results
+}
+
+// Parses the -tiles and -windows flags into a layout placing both, or nil
+// if neither is set.
+func parseRegions(tiles, windows string) (pdq.RegionLayout, error) {
+	var layouts []pdq.RegionLayout
+	if tiles != "" {
+		rows, cols, ok := strings.Cut(tiles, "x")
+		r, err1 := strconv.Atoi(rows)
+		c, err2 := strconv.Atoi(cols)
+		if !ok || err1 != nil || err2 != nil || r < 1 || c < 1 {
+			return nil, fmt.Errorf("-tiles: expected ROWSxCOLS, got %q", tiles)
+		}
+		layouts = append(layouts, pdq.Tiles(r, c))
+	}
+	if windows != "" {
+		size, stride, ok := strings.Cut(windows, ",")
+		s, err1 := strconv.ParseFloat(size, 64)
+		t, err2 := strconv.ParseFloat(stride, 64)
+		if !ok || err1 != nil || err2 != nil || s <= 0 || s > 1 || t <= 0 {
+			return nil, fmt.Errorf("-windows: expected SIZE,STRIDE with a size up to 1, got %q", windows)
+		}
+		layouts = append(layouts, pdq.Windows(s, t))
+	}
+	if len(layouts) == 0 {
+		return nil, nil
+	}
+	return func(width, height int) []pdq.CropBox {
+		var boxes []pdq.CropBox
+		for _, layout := range layouts {
+			boxes = append(boxes, layout(width, height)...)
+		}
+		return boxes
+	}, nil
+}
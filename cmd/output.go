@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/MTRNord/pdqhash-go/archive"
+	"github.com/MTRNord/pdqhash-go/types"
+)
+
+const OUTPUT_PLAIN = "plain"
+const OUTPUT_CSV = "csv"
+const OUTPUT_JSON = "json"
+const OUTPUT_NDJSON = "ndjson"
+
+var OUTPUT_FORMATS = []string{OUTPUT_PLAIN, OUTPUT_CSV, OUTPUT_JSON, OUTPUT_NDJSON}
+
+// Failure reason for archive entries skipped by the archive limits.
+const REASON_ARCHIVE_LIMIT = "archive_limit"
+
+var CSV_HEADER = []string{"hash", "quality", "norm", "delta", "width", "height", "mime", "low_quality", "crop", "orientation", "displayed_hash", "error", "reason", "path"}
+
+// The outcome of hashing one file. Delta is the Hamming distance to the
+// previously hashed file.
+type fileResult struct {
+	Path    string `json:"path"`
+	Hash    string `json:"hash,omitempty"`
+	Quality int    `json:"quality"`
+	Norm    int    `json:"norm"`
+	Delta   int    `json:"delta"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	MIME    string `json:"mime,omitempty"`
+	// The pdq.LOW_QUALITY_* reason for flagged or rejected images.
+	LowQuality string `json:"low_quality,omitempty"`
+	// With border trimming, the part of the image that was hashed as
+	// left,top,right,bottom.
+	Crop string `json:"crop,omitempty"`
+	// The EXIF orientation of the image, if it has one.
+	Orientation int `json:"orientation,omitempty"`
+	// With -orientation both, the hash of the image as displayed.
+	DisplayedHash string `json:"displayed_hash,omitempty"`
+	Error         string `json:"error,omitempty"`
+	// Category of Error, one of the pdq.FAILURE_* reasons or
+	// REASON_ARCHIVE_LIMIT.
+	Reason string `json:"reason,omitempty"`
+
+	hash      *types.Hash256
+	displayed *types.Hash256
+	// Taken from the scan cache rather than hashed.
+	cached bool
+	// All eight transforms, with scanOptions.Dihedral.
+	dihedral []pdq.DihedralHash
+	// Hashes of the regions, with scanOptions.Regions.
+	regions []pdq.RegionHash
+}
+
+func (r *fileResult) setHash(hash *types.Hash256, quality int) {
+	r.hash = hash
+	r.Hash = hash.String()
+	r.Quality = quality
+	r.Norm = hash.HammingNorm()
+}
+
+// Sets the hash and everything reported along with it.
+func (r *fileResult) setHashAndQuality(hashAndQuality pdq.HashAndQuality) {
+	r.setHash(hashAndQuality.Hash, hashAndQuality.Quality)
+	r.LowQuality = hashAndQuality.LowQuality
+	if hashAndQuality.Crop != nil {
+		r.Crop = hashAndQuality.Crop.String()
+	}
+	r.Width = hashAndQuality.Width
+	r.Height = hashAndQuality.Height
+	r.Orientation = hashAndQuality.Orientation
+	if hashAndQuality.DisplayedHash != nil {
+		r.displayed = hashAndQuality.DisplayedHash
+		r.DisplayedHash = hashAndQuality.DisplayedHash.String()
+	}
+}
+
+func (r *fileResult) setError(err error) {
+	r.Error = err.Error()
+	r.Reason = pdq.FAILURE_IO
+	var hashErr *pdq.HashError
+	if errors.As(err, &hashErr) {
+		r.Reason = hashErr.Reason
+	}
+	var lowQualityErr *pdq.LowQualityError
+	if errors.As(err, &lowQualityErr) {
+		r.Quality = lowQualityErr.Quality
+		r.LowQuality = lowQualityErr.Reason
+	}
+	if errors.Is(err, archive.ErrTooDeep) || errors.Is(err, archive.ErrTooLarge) || errors.Is(err, archive.ErrTotalTooLarge) {
+		r.Reason = REASON_ARCHIVE_LIMIT
+	}
+}
+
+func (r *fileResult) csvRecord() []string {
+	return []string{
+		r.Hash,
+		strconv.Itoa(r.Quality),
+		strconv.Itoa(r.Norm),
+		strconv.Itoa(r.Delta),
+		strconv.Itoa(r.Width),
+		strconv.Itoa(r.Height),
+		r.MIME,
+		r.LowQuality,
+		r.Crop,
+		orientationColumn(r.Orientation),
+		r.DisplayedHash,
+		r.Error,
+		r.Reason,
+		r.Path,
+	}
+}
+
+func orientationColumn(orientation int) string {
+	if orientation == 0 {
+		return ""
+	}
+	return strconv.Itoa(orientation)
+}
+
+// The lines the scanner logged before -output, without the log timestamps.
+// Newer fields such as the dimensions are only in CSV and JSON.
+func (r *fileResult) plain(detailed bool) (string, bool) {
+	if r.Error != "" {
+		return fmt.Sprintf("%s: %s (%s)", r.Path, r.Error, r.Reason), true
+	}
+	if detailed {
+		return fmt.Sprintf("hash=%s,norm=%d,delta=%d,quality=%d,filename=%s", r.Hash, r.Norm, r.Delta, r.Quality, r.Path), false
+	}
+	return fmt.Sprintf("%s,%d,%s", r.Hash, r.Quality, r.Path), false
+}
+
+// One line of output. Rows are marshalled as they are for JSON.
+type row interface {
+	// The columns of the CSV header the writer was created with.
+	csvRecord() []string
+	// The line for plain output, and whether it reports a failure.
+	plain(detailed bool) (string, bool)
+}
+
+type resultWriter interface {
+	Write(r row) error
+	// Close terminates the output (e.g. the closing bracket of a JSON
+	// array) but does not close the underlying writer.
+	Close() error
+}
+
+// header is the CSV header for the rows that will be written.
+func newResultWriter(format string, header []string, detailed bool, stdout, stderr io.Writer) (resultWriter, error) {
+	switch format {
+	case OUTPUT_PLAIN:
+		return &plainWriter{stdout, stderr, detailed}, nil
+	case OUTPUT_CSV:
+		return &csvWriter{w: csv.NewWriter(stdout), header: header}, nil
+	case OUTPUT_JSON:
+		return &jsonWriter{w: stdout}, nil
+	case OUTPUT_NDJSON:
+		return &ndjsonWriter{json.NewEncoder(stdout)}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, expected one of %v", format, OUTPUT_FORMATS)
+}
+
+// Adapts a writer to the sink of scanFiles.
+func writeTo(out resultWriter) func(*fileResult) error {
+	return func(result *fileResult) error {
+		return out.Write(result)
+	}
+}
+
+// Failures go to stderr so that stdout stays one row per line.
+type plainWriter struct {
+	stdout   io.Writer
+	stderr   io.Writer
+	detailed bool
+}
+
+func (w *plainWriter) Write(r row) error {
+	line, failed := r.plain(w.detailed)
+	out := w.stdout
+	if failed {
+		out = w.stderr
+	}
+	_, err := fmt.Fprintln(out, line)
+	return err
+}
+
+func (w *plainWriter) Close() error {
+	return nil
+}
+
+type csvWriter struct {
+	w             *csv.Writer
+	header        []string
+	headerWritten bool
+}
+
+func (w *csvWriter) Write(r row) error {
+	if !w.headerWritten {
+		w.headerWritten = true
+		if err := w.w.Write(w.header); err != nil {
+			return err
+		}
+	}
+	if err := w.w.Write(r.csvRecord()); err != nil {
+		return err
+	}
+	// Flush per row so that output from a long scan appears as it goes.
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *csvWriter) Close() error {
+	if !w.headerWritten {
+		w.headerWritten = true
+		w.w.Write(w.header)
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// Streams a JSON array, one element per line, rather than buffering the
+// whole scan.
+type jsonWriter struct {
+	w       io.Writer
+	written int
+}
+
+func (w *jsonWriter) Write(r row) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	prefix := ",\n  "
+	if w.written == 0 {
+		prefix = "[\n  "
+	}
+	w.written++
+	_, err = fmt.Fprintf(w.w, "%s%s", prefix, line)
+	return err
+}
+
+func (w *jsonWriter) Close() error {
+	var err error
+	if w.written == 0 {
+		_, err = io.WriteString(w.w, "[]\n")
+	} else {
+		_, err = io.WriteString(w.w, "\n]\n")
+	}
+	return err
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (w *ndjsonWriter) Write(r row) error {
+	return w.enc.Encode(r)
+}
+
+func (w *ndjsonWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/MTRNord/pdqhash-go/server"
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+func main() {
+	opts := server.DefaultOptions()
+	opts.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	vips.LoggingSettings(nil, vips.LogLevelMessage)
+	vips.Startup(&vips.Config{
+		ConcurrencyLevel: 0,
+		MaxCacheFiles:    5,
+		MaxCacheMem:      50 * 1024 * 1024,
+		MaxCacheSize:     100,
+		ReportLeaks:      false,
+		CacheTrace:       false,
+		CollectStats:     false,
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := server.Serve(ctx, opts)
+	stop()
+	vips.Shutdown()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+var LOW_QUALITY_CSV_HEADER = []string{"path", "quality", "reason", "width", "height", "rejected"}
+
+// Lists the images flagged or rejected by the quality policy as CSV, with
+// the reason their hash is unreliable.
+type lowQualityReport struct {
+	w *csv.Writer
+}
+
+func newLowQualityReport(w io.Writer) (*lowQualityReport, error) {
+	r := &lowQualityReport{csv.NewWriter(w)}
+	if err := r.w.Write(LOW_QUALITY_CSV_HEADER); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *lowQualityReport) add(result *fileResult) error {
+	if result.LowQuality == "" {
+		return nil
+	}
+	return r.w.Write([]string{
+		result.Path,
+		strconv.Itoa(result.Quality),
+		result.LowQuality,
+		strconv.Itoa(result.Width),
+		strconv.Itoa(result.Height),
+		strconv.FormatBool(result.Error != ""),
+	})
+}
+
+func (r *lowQualityReport) flush() error {
+	r.w.Flush()
+	return r.w.Error()
+}
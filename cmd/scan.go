@@ -0,0 +1,450 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/MTRNord/pdqhash-go/archive"
+	"github.com/MTRNord/pdqhash-go/scancache"
+	"github.com/MTRNord/pdqhash-go/types"
+	"github.com/h2non/filetype"
+)
+
+// Returned by producers once the queue reports that the scan was stopped.
+var errScanStopped = errors.New("scan stopped")
+
+type scanOptions struct {
+	// Number of files hashed in parallel; values below 1 mean 1.
+	Workers int
+	// Emit results in submission order. Otherwise they are emitted as
+	// soon as they are hashed, which with several workers is not
+	// deterministic.
+	Ordered bool
+	// Optional; files whose size and mtime, or failing that content
+	// digest, match an entry are not hashed again.
+	Cache *scancache.Cache
+	// Hash every file, replacing the cached entries.
+	Rehash bool
+	// Also hash the rotations and flips of every image. The scan cache is
+	// not used, as it only holds the original hash.
+	Dihedral bool
+	// Passed on to the hashers; see pdq.PDQHasher.
+	QualityPolicy string
+	MinQuality    int
+	// Optional; see pdq.PDQHasher. The scan cache is not used, as its
+	// entries do not record whether borders were trimmed.
+	BorderTrim *pdq.BorderTrim
+	// Passed on to the hashers. The scan cache is not used when alpha is
+	// composited, as its entries do not record the policy.
+	AlphaPolicy string
+	AlphaColor  color.RGBA
+	// Passed on to the hashers. The scan cache is not used unless the
+	// orientation is ignored, as its entries only hold the stored pixels'
+	// hash.
+	Orientation string
+	// Passed on to the hashers. The scan cache is not used with float32,
+	// as its entries hold float64 hashes.
+	Precision string
+	// Optional; also hash these regions of every image. The scan cache is
+	// not used, as it only holds the whole image's hash.
+	Regions pdq.RegionLayout
+	// Descend into zip, tar and tar.gz files within ArchiveLimits.
+	Archives      bool
+	ArchiveLimits archive.Limits
+}
+
+type scanJob struct {
+	seq  int
+	path string
+	mime string
+	// Set for files that failed before hashing, e.g. while walking.
+	err error
+	// Content of archive entries, which have no path to read from.
+	data []byte
+}
+
+type scanResult struct {
+	seq int
+	*fileResult
+}
+
+// Hands files to the scan workers. Both methods return false once the
+// scan has been stopped, after which the producer should return.
+type scanQueue struct {
+	jobs chan<- scanJob
+	stop <-chan struct{}
+	opts scanOptions
+	seq  int
+}
+
+// The path that stands for stdin, both as an argument and in results.
+const STDIN_PATH = "-"
+
+// Queues a path given by the user: a file, which is hashed whatever its
+// type, or a folder, whose images are hashed. Paths that cannot be read
+// are recorded as failures.
+func (q *scanQueue) submitPath(path string) bool {
+	// Check if folder exists and is a folder
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return q.fail(path, err)
+	}
+	if !fileInfo.IsDir() {
+		return q.submitFile(path, true)
+	}
+
+	err = filepath.Walk(path, func(fullPath string, item os.FileInfo, err error) error {
+		// Unreadable files and folders are reported and skipped.
+		if err != nil {
+			if !q.fail(fullPath, err) {
+				return errScanStopped
+			}
+			return nil
+		}
+		if !item.IsDir() && !q.submitFile(fullPath, false) {
+			return errScanStopped
+		}
+		return nil
+	})
+	return err == nil
+}
+
+// Queues one file found on disk. Images are hashed and archives
+// descended into; other files are skipped unless named is set, meaning
+// the user asked for this file explicitly.
+func (q *scanQueue) submitFile(path string, named bool) bool {
+	// Check if file is an image
+	filetypeRef, err := filetype.MatchFile(path)
+	if err != nil {
+		return q.fail(path, err)
+	}
+	if filetypeRef.MIME.Type != "image" && q.opts.Archives {
+		kind, err := archive.DetectFile(path)
+		if err != nil {
+			return q.fail(path, err)
+		}
+		if kind != archive.KIND_NONE {
+			return q.submitArchive(path, q.opts.ArchiveLimits)
+		}
+	}
+	if named || filetypeRef.MIME.Type == "image" {
+		return q.submit(path, filetypeRef.MIME.Value)
+	}
+	return true
+}
+
+func (q *scanQueue) submit(path, mime string) bool {
+	return q.push(scanJob{path: path, mime: mime})
+}
+
+func (q *scanQueue) submitData(path, mime string, data []byte) bool {
+	return q.push(scanJob{path: path, mime: mime, data: data})
+}
+
+// Records a file that could not be hashed, keeping its place in the
+// output order.
+func (q *scanQueue) fail(path string, err error) bool {
+	return q.push(scanJob{path: path, err: err})
+}
+
+func (q *scanQueue) push(job scanJob) bool {
+	job.seq = q.seq
+	select {
+	case q.jobs <- job:
+		q.seq++
+		return true
+	case <-q.stop:
+		return false
+	}
+}
+
+// Counts of a finished scan, with failures broken down by reason.
+type scanSummary struct {
+	Hashed int
+	// Of Hashed, how many came from the scan cache.
+	Cached int
+	// Flagged or rejected by the quality policy; rejected images are
+	// also counted in Failed.
+	LowQuality int
+	Failed     int
+	Reasons    map[string]int
+}
+
+func (s *scanSummary) add(result *fileResult) {
+	if result.LowQuality != "" {
+		s.LowQuality++
+	}
+	if result.Error == "" {
+		s.Hashed++
+		if result.cached {
+			s.Cached++
+		}
+		return
+	}
+	s.Failed++
+	if s.Reasons == nil {
+		s.Reasons = map[string]int{}
+	}
+	s.Reasons[result.Reason]++
+}
+
+func (s scanSummary) String() string {
+	summary := fmt.Sprintf("%d hashed, %d failed", s.Hashed, s.Failed)
+	if s.Cached > 0 {
+		summary = fmt.Sprintf("%d hashed (%d cached), %d failed", s.Hashed, s.Cached, s.Failed)
+	}
+	if s.Failed > 0 {
+		reasons := make([]string, 0, len(s.Reasons))
+		for reason, count := range s.Reasons {
+			reasons = append(reasons, fmt.Sprintf("%s: %d", reason, count))
+		}
+		sort.Strings(reasons)
+		summary += " (" + strings.Join(reasons, ", ") + ")"
+	}
+	if s.LowQuality > 0 {
+		summary += fmt.Sprintf(", %d low quality", s.LowQuality)
+	}
+	return summary
+}
+
+func (opts scanOptions) newHasher() *pdq.PDQHasher {
+	hasher := pdq.NewPDQHasher()
+	hasher.QualityPolicy = opts.QualityPolicy
+	hasher.MinQuality = opts.MinQuality
+	hasher.BorderTrim = opts.BorderTrim
+	hasher.AlphaPolicy = opts.AlphaPolicy
+	hasher.AlphaColor = opts.AlphaColor
+	hasher.Orientation = opts.Orientation
+	hasher.Precision = opts.Precision
+	return hasher
+}
+
+func (opts scanOptions) composites() bool {
+	return opts.AlphaPolicy != "" && opts.AlphaPolicy != pdq.ALPHA_POLICY_IGNORE
+}
+
+func (opts scanOptions) orients() bool {
+	return opts.Orientation != "" && opts.Orientation != pdq.ORIENTATION_IGNORE
+}
+
+// The cache does not keep low quality reasons, so entries that the
+// quality policy would act on are hashed again.
+func (opts scanOptions) usable(entry *scancache.Entry) bool {
+	policy := opts.QualityPolicy
+	return policy == "" || policy == pdq.QUALITY_POLICY_KEEP || entry.Quality >= opts.MinQuality
+}
+
+func (opts scanOptions) hashBuffer(hasher *pdq.PDQHasher, path, mime string, buf []byte) *fileResult {
+	if opts.Dihedral {
+		return dihedralResult(hasher, path, mime, buf)
+	}
+	if opts.Regions != nil {
+		return regionsResult(hasher, path, mime, buf, opts.Regions)
+	}
+	return bufferResult(hasher, path, mime, buf)
+}
+
+func (opts scanOptions) hash(hasher *pdq.PDQHasher, path, mime string) *fileResult {
+	if opts.Cache == nil || opts.Dihedral || opts.BorderTrim != nil || opts.Regions != nil || opts.composites() || opts.orients() || opts.Precision == pdq.PRECISION_FLOAT32 {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return failedResult(path, err)
+		}
+		return opts.hashBuffer(hasher, path, mime, buf)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return failedResult(path, err)
+	}
+	if !opts.Rehash {
+		if entry, ok := opts.Cache.Lookup(path, info); ok && opts.usable(entry) {
+			return cachedResult(entry)
+		}
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return failedResult(path, err)
+	}
+	digest := scancache.Digest(buf)
+
+	// A file that was touched or copied keeps its hash.
+	entry, ok := opts.Cache.Get(path)
+	if !opts.Rehash && ok && entry.Digest == digest && opts.usable(entry) {
+		updated := *entry
+		updated.Size = info.Size()
+		updated.ModTime = info.ModTime()
+		if err := opts.Cache.Put(&updated); err != nil {
+			return failedResult(path, err)
+		}
+		return cachedResult(&updated)
+	}
+
+	result := bufferResult(hasher, path, mime, buf)
+	if result.Error == "" {
+		err := opts.Cache.Put(&scancache.Entry{
+			Path:        path,
+			Size:        info.Size(),
+			ModTime:     info.ModTime(),
+			Digest:      digest,
+			Hash:        result.Hash,
+			Quality:     result.Quality,
+			Width:       result.Width,
+			Height:      result.Height,
+			MIME:        result.MIME,
+			Orientation: result.Orientation,
+		})
+		if err != nil {
+			return failedResult(path, err)
+		}
+	}
+	return result
+}
+
+func cachedResult(entry *scancache.Entry) *fileResult {
+	hash, err := types.Hash256FromHexString(entry.Hash)
+	if err != nil {
+		return failedResult(entry.Path, fmt.Errorf("corrupt scan cache entry: %w", err))
+	}
+	return &fileResult{
+		Path:        entry.Path,
+		Hash:        entry.Hash,
+		Quality:     entry.Quality,
+		Norm:        hash.HammingNorm(),
+		Width:       entry.Width,
+		Height:      entry.Height,
+		MIME:        entry.MIME,
+		Orientation: entry.Orientation,
+		hash:        hash,
+		cached:      true,
+	}
+}
+
+// Queues the images inside an archive. Entries are read in the producer,
+// since tar streams cannot be read out of order, and hashed by the pool.
+func (q *scanQueue) submitArchive(path string, limits archive.Limits) bool {
+	err := archive.Walk(path, limits, func(entryPath string, r io.Reader, err error) error {
+		if err == nil {
+			var data []byte
+			data, err = io.ReadAll(r)
+			if err == nil {
+				kind, _ := filetype.Match(data)
+				if kind.MIME.Type != "image" {
+					return nil
+				}
+				if !q.submitData(entryPath, kind.MIME.Value, data) {
+					return errScanStopped
+				}
+				return nil
+			}
+		}
+		if !q.fail(entryPath, err) {
+			return errScanStopped
+		}
+		return nil
+	})
+	if errors.Is(err, errScanStopped) {
+		return false
+	}
+	if err != nil {
+		return q.fail(path, err)
+	}
+	return true
+}
+
+/**
+ * Hashes the files queued by produce on a pool of workers, each with its
+ * own hasher, and passes the results to sink. The delta column is the
+ * distance to the previous hash actually written, so with Ordered it
+ * matches a sequential scan.
+ *
+ * Files that cannot be hashed are written as error rows and counted in
+ * the summary; only a failure to write the output stops the scan.
+ */
+func scanFiles(sink func(*fileResult) error, opts scanOptions, produce func(q *scanQueue) error) (scanSummary, error) {
+	workers := max(opts.Workers, 1)
+
+	jobs := make(chan scanJob)
+	results := make(chan scanResult)
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hasher := opts.newHasher()
+			for job := range jobs {
+				if job.err != nil {
+					results <- scanResult{job.seq, failedResult(job.path, job.err)}
+				} else if job.data != nil {
+					results <- scanResult{job.seq, opts.hashBuffer(hasher, job.path, job.mime, job.data)}
+				} else {
+					results <- scanResult{job.seq, opts.hash(hasher, job.path, job.mime)}
+				}
+			}
+		}()
+	}
+
+	var produceErr error
+	go func() {
+		produceErr = produce(&scanQueue{jobs: jobs, stop: stop, opts: opts})
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	var summary scanSummary
+	var prevHash *types.Hash256
+	emit := func(result *fileResult) error {
+		if result.hash != nil {
+			if prevHash != nil {
+				result.Delta = result.hash.HammingDistance(prevHash)
+			}
+			prevHash = result.hash
+		}
+		summary.add(result)
+		return sink(result)
+	}
+
+	var err error
+	pending := map[int]*fileResult{}
+	next := 0
+	for result := range results {
+		if err != nil {
+			// Drain so that the workers can exit.
+			continue
+		}
+		if !opts.Ordered {
+			err = emit(result.fileResult)
+		} else {
+			pending[result.seq] = result.fileResult
+			for err == nil {
+				r, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				err = emit(r)
+			}
+		}
+		if err != nil {
+			close(stop)
+		}
+	}
+
+	if err != nil {
+		return summary, err
+	}
+	return summary, produceErr
+}
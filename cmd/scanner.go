@@ -1,112 +1,590 @@
+// Command scanner hashes, compares, matches and deduplicates images.
+//
+//	scanner hash [-detailed] PATH...
+//	find . -print0 | scanner hash -0 -
+//	scanner hash -stdin-image < image.jpg
+//	scanner dihedral FILE...
+//	scanner compare [-threshold N] HASH|FILE HASH|FILE
+//	scanner match -hashlist LIST|-reference PATH [-threshold N] [-dihedral] PATH...
+//	scanner match -reference PATH -tiles 3x3 PATH...
+//	scanner index build [-o LIST] PATH...
+//	scanner dedupe [-threshold N] PATH...
+//	scanner calibrate [-target-fpr RATE] [-csv FILE] [-html FILE] PAIRS
+//	scanner eval [-transforms LIST] [-thresholds LIST] PATH...
+//	scanner serve [-addr ADDR] [-hashlist LIST] ...
+//	scanner watch [-o FILE] [-hashlist LIST] DIR...
+//
+// Exit status is 0 on success, 1 when nothing matched and 2 on errors.
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"image/color"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime/pprof"
+	"slices"
+	"strconv"
+	"strings"
 
 	pdq "github.com/MTRNord/pdqhash-go"
-	"github.com/MTRNord/pdqhash-go/types"
+	"github.com/MTRNord/pdqhash-go/archive"
+	"github.com/MTRNord/pdqhash-go/scancache"
 	"github.com/davidbyttow/govips/v2/vips"
 	"github.com/h2non/filetype"
 )
 
-// This is an example. It is not meant to be run in prod.
+// Exit codes follow grep: EXIT_NO_MATCH means the command ran fine but
+// found nothing (no match, no duplicates, distance above threshold).
+const EXIT_OK = 0
+const EXIT_NO_MATCH = 1
+const EXIT_ERROR = 2
 
-func processFolder(filename string, detailed bool) error {
-	pdqhasher := pdq.NewPDQHasher()
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(c *cli, args []string) int
+}
 
-	numPDQHash := 0
-	var prevHash *types.Hash256
-	err := filepath.Walk(filename, func(fullPath string, item os.FileInfo, err error) error {
-		if err != nil {
-			return err
+var commands = []command{
+	{"hash", "[flags] PATH...", "Hash image files and folders", (*cli).runHash},
+	{"dihedral", "[flags] FILE...", "Hash images and their seven rotations and flips", (*cli).runDihedral},
+	{"compare", "[flags] HASH|FILE HASH|FILE", "Print the distance between two hashes or images", (*cli).runCompare},
+	{"match", "-hashlist LIST|-reference PATH [flags] PATH...", "Match images against a hash list or reference images", (*cli).runMatch},
+	{"index", "build [flags] PATH...", "Build a hash list from images", (*cli).runIndex},
+	{"dedupe", "[flags] PATH...", "Group near-duplicate images", (*cli).runDedupe},
+	{"calibrate", "[flags] PAIRS", "Choose a match threshold from labelled image pairs", (*cli).runCalibrate},
+	{"eval", "[flags] PATH...", "Measure hash distances under common image edits", (*cli).runEval},
+	{"serve", "[flags]", "Serve the hashing and matching API", (*cli).runServe},
+	{"watch", "[flags] DIR...", "Hash files as they are added to folders", (*cli).runWatch},
+}
+
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func main() {
+	vips.LoggingSettings(nil, vips.LogLevelMessage)
+	vips.Startup(&vips.Config{
+		ConcurrencyLevel: 0,
+		MaxCacheFiles:    5,
+		MaxCacheMem:      50 * 1024 * 1024,
+		MaxCacheSize:     100,
+		ReportLeaks:      false,
+		CacheTrace:       false,
+		CollectStats:     false,
+	})
+
+	code := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+
+	vips.Shutdown()
+	os.Exit(code)
+}
+
+// run dispatches to a subcommand. libvips must already be started. For
+// compatibility with the original scanner, arguments that start with a
+// flag (e.g. "-folder images") run the hash command.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+
+	if len(args) == 0 {
+		c.usage()
+		return EXIT_ERROR
+	}
+	if strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "-help" && args[0] != "--help" {
+		return c.runHash(args)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(c, args[1:])
 		}
-		if !item.IsDir() {
-			// Check if file is an image
-			filetypeRef, err := filetype.MatchFile(fullPath)
-			if err != nil {
-				log.Fatal(err)
-			}
-			if filetypeRef.MIME.Type == "image" {
-				hashAndQuality := pdqhasher.FromFile(fullPath)
-				delta := 0
-				if numPDQHash == 0 {
-					delta = 0
-				} else {
-					delta = hashAndQuality.Hash.HammingDistance(prevHash)
-				}
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		c.usage()
+		return EXIT_OK
+	}
 
-				if detailed {
-					log.Printf("hash=%s,norm=%d,delta=%d,quality=%d,filename=%s", hashAndQuality.Hash.String(), hashAndQuality.Hash.HammingNorm(), delta, hashAndQuality.Quality, fullPath)
-				} else {
-					log.Printf("%s,%d,%s", hashAndQuality.Hash.String(), hashAndQuality.Quality, fullPath)
-				}
+	fmt.Fprintf(stderr, "unknown command %q\n\n", args[0])
+	c.usage()
+	return EXIT_ERROR
+}
 
-				prevHash = hashAndQuality.Hash
-				numPDQHash++
-			}
+func (c *cli) usage() {
+	fmt.Fprintf(c.stderr, "Usage: scanner COMMAND [flags] ARGS...\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(c.stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(c.stderr, "\nRun 'scanner COMMAND -h' for the flags of a command.\n")
+}
+
+func (c *cli) flagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: scanner %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Parses flags and reports the exit code to use if parsing did not
+// succeed.
+func (c *cli) parse(fs *flag.FlagSet, args []string) (int, bool) {
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return EXIT_OK, false
+	}
+	if err != nil {
+		return EXIT_ERROR, false
+	}
+	return EXIT_OK, true
+}
+
+// Registers the border trimming flags. The returned function gives the
+// settings once the flags are parsed, or nil if trimming is off.
+func borderFlags(fs *flag.FlagSet) func() *pdq.BorderTrim {
+	trim := fs.Bool("trim-borders", false, "Remove uniform borders and letterboxing before hashing")
+	settings := pdq.DefaultBorderTrim()
+	fs.Var(&borderValue{settings, &settings.Tolerance}, "border-tolerance", "Largest luma difference (0-255) within a border, with -trim-borders")
+	fs.Var(&borderValue{settings, &settings.MaxFraction}, "max-border-fraction", "Largest fraction of the width or height trimmed from each side, below 0.5, with -trim-borders")
+	return func() *pdq.BorderTrim {
+		if !*trim {
+			return nil
 		}
-		return nil
-	})
-	return err
+		return settings
+	}
 }
 
-func processFile(filename string, detailed bool) {
-	pdqhasher := pdq.NewPDQHasher()
+// One of the numbers of the border trimming settings, which are checked
+// as it is set.
+type borderValue struct {
+	settings *pdq.BorderTrim
+	field    *float64
+}
 
-	hashAndQuality := pdqhasher.FromFile(filename)
-	delta := 0
-	if detailed {
-		log.Printf("hash=%s,norm=%d,delta=%d,quality=%d,filename=%s", hashAndQuality.Hash.String(), hashAndQuality.Hash.HammingNorm(), delta, hashAndQuality.Quality, filename)
-	} else {
-		log.Printf("%s,%d,%s", hashAndQuality.Hash.String(), hashAndQuality.Quality, filename)
+func (v *borderValue) String() string {
+	if v.field == nil {
+		return ""
 	}
+	return strconv.FormatFloat(*v.field, 'g', -1, 64)
 }
 
-func main() {
+func (v *borderValue) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	old := *v.field
+	*v.field = f
+	if err := v.settings.Validate(); err != nil {
+		*v.field = old
+		return err
+	}
+	return nil
+}
+
+// The -alpha flag: a pdq.ALPHA_POLICY_* name or a background colour as
+// #rrggbb.
+type alphaValue struct {
+	text   string
+	policy string
+	color  color.RGBA
+}
+
+func (v *alphaValue) String() string {
+	return v.text
+}
+
+func (v *alphaValue) Set(s string) error {
+	policy, c, err := pdq.ParseAlphaPolicy(s)
+	if err != nil {
+		return err
+	}
+	v.text, v.policy, v.color = s, policy, c
+	return nil
+}
+
+func alphaFlag(fs *flag.FlagSet) *alphaValue {
+	v := &alphaValue{text: pdq.ALPHA_POLICY_IGNORE, policy: pdq.ALPHA_POLICY_IGNORE}
+	fs.Var(v, "alpha", "How to treat transparent images: ignore alpha, composite over white or black, or over a colour given as #rrggbb")
+	return v
+}
+
+// The -orientation flag, one of pdq.ORIENTATION_MODES.
+type orientationValue string
+
+func (v *orientationValue) String() string {
+	return string(*v)
+}
+
+func (v *orientationValue) Set(s string) error {
+	if !slices.Contains(pdq.ORIENTATION_MODES, s) {
+		return fmt.Errorf("unknown orientation mode %q, expected one of %v", s, pdq.ORIENTATION_MODES)
+	}
+	*v = orientationValue(s)
+	return nil
+}
+
+func orientationFlag(fs *flag.FlagSet) *orientationValue {
+	v := orientationValue(pdq.ORIENTATION_IGNORE)
+	fs.Var(&v, "orientation", fmt.Sprintf("What to do with the EXIF orientation, one of %v: hash the stored pixels, the image as displayed, or both", pdq.ORIENTATION_MODES))
+	return &v
+}
+
+// The -precision flag, one of pdq.PRECISIONS.
+type precisionValue string
+
+func (v *precisionValue) String() string {
+	return string(*v)
+}
+
+func (v *precisionValue) Set(s string) error {
+	if !slices.Contains(pdq.PRECISIONS, s) {
+		return fmt.Errorf("unknown precision %q, expected one of %v", s, pdq.PRECISIONS)
+	}
+	*v = precisionValue(s)
+	return nil
+}
+
+func precisionFlag(fs *flag.FlagSet) *precisionValue {
+	v := precisionValue(pdq.PRECISION_FLOAT64)
+	fs.Var(&v, "precision", fmt.Sprintf("Precision of the hashing pipeline, one of %v; float32 uses less memory", pdq.PRECISIONS))
+	return &v
+}
+
+func (c *cli) fail(err error) int {
+	fmt.Fprintf(c.stderr, "scanner: %v\n", err)
+	return EXIT_ERROR
+}
+
+func (c *cli) runHash(args []string) (code int) {
+	fs := c.flagSet("hash", "[flags] PATH...")
 	var folder string
-	var detailedOutput bool
+	fs.StringVar(&folder, "folder", "", "Folder or file to scan (same as a PATH argument); - reads a list of paths from stdin")
+	nulSeparated := fs.Bool("0", false, "Paths read from stdin are separated by NUL bytes (as from find -print0) rather than newlines")
+	stdinImage := fs.Bool("stdin-image", false, "Hash the single image read from stdin")
+	detailed := fs.Bool("detailed", false, "Detailed output")
+	output := fs.String("output", OUTPUT_PLAIN, fmt.Sprintf("Output format, one of %v", OUTPUT_FORMATS))
+	strict := fs.Bool("strict", false, "Exit with an error if any file could not be hashed")
+	workers := fs.Int("workers", 1, "Number of files hashed in parallel")
+	ordered := fs.Bool("ordered", false, "Keep walk order in the output when hashing in parallel")
+	archives := fs.Bool("archives", true, "Hash the images inside zip, tar and tar.gz files")
+	limits := archive.DefaultLimits()
+	fs.IntVar(&limits.MaxDepth, "archive-depth", limits.MaxDepth, "Largest number of archives nested inside each other")
+	fs.Int64Var(&limits.MaxEntryBytes, "archive-max-entry-bytes", limits.MaxEntryBytes, "Largest decompressed archive entry, 0 for no limit")
+	fs.Int64Var(&limits.MaxTotalBytes, "archive-max-bytes", limits.MaxTotalBytes, "Largest decompressed size of one archive, 0 for no limit")
+	minQuality := fs.Int("min-quality", pdq.DEFAULT_MIN_QUALITY, "Quality below which -quality-policy applies")
+	qualityPolicy := fs.String("quality-policy", pdq.QUALITY_POLICY_KEEP, fmt.Sprintf("What to do with low quality hashes, one of %v", pdq.QUALITY_POLICIES))
+	borderTrim := borderFlags(fs)
+	alpha := alphaFlag(fs)
+	orientation := orientationFlag(fs)
+	precision := precisionFlag(fs)
+	reportPath := fs.String("low-quality-report", "", "Write a CSV of low quality images and why to this file; implies at least -quality-policy flag")
+	cachePath := fs.String("cache", "", "Scan cache file; unchanged files are not hashed again")
+	rehash := fs.Bool("rehash", false, "Hash every file even if it is in the scan cache")
+	prune := fs.Bool("prune", false, "Drop scan cache entries for files that no longer exist")
+	cpuprofile := fs.String("cpuprofile", "", "write cpu profile to file")
+	if code, ok := c.parse(fs, args); !ok {
+		return code
+	}
+
+	paths := fs.Args()
+	if folder != "" {
+		paths = append([]string{folder}, paths...)
+	}
+	if (len(paths) == 0) != *stdinImage {
+		fs.Usage()
+		return EXIT_ERROR
+	}
+	sep := byte('\n')
+	if *nulSeparated {
+		sep = 0
+	}
+
+	// Every flag is checked before any output file is created.
+	if !slices.Contains(pdq.QUALITY_POLICIES, *qualityPolicy) {
+		return c.fail(fmt.Errorf("unknown quality policy %q, expected one of %v", *qualityPolicy, pdq.QUALITY_POLICIES))
+	}
+	if (*rehash || *prune) && *cachePath == "" {
+		return c.fail(errors.New("-rehash and -prune need -cache"))
+	}
+
+	out, err := newResultWriter(*output, CSV_HEADER, *detailed, c.stdout, c.stderr)
+	if err != nil {
+		return c.fail(err)
+	}
 
-	flag.StringVar(&folder, "folder", "", "Folder to scan")
-	flag.BoolVar(&detailedOutput, "detailed", false, "Detailed output")
-	var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
+	opts := scanOptions{
+		Workers:       *workers,
+		Ordered:       *ordered,
+		Rehash:        *rehash,
+		QualityPolicy: *qualityPolicy,
+		MinQuality:    *minQuality,
+		BorderTrim:    borderTrim(),
+		AlphaPolicy:   alpha.policy,
+		AlphaColor:    alpha.color,
+		Orientation:   string(*orientation),
+		Precision:     string(*precision),
+		Archives:      *archives,
+		ArchiveLimits: limits,
+	}
+	if *cachePath != "" {
+		opts.Cache, err = scancache.Open(*cachePath)
+		if err != nil {
+			return c.fail(err)
+		}
+		defer func() {
+			if err := opts.Cache.Close(); err != nil {
+				code = c.fail(err)
+			}
+		}()
+	}
 
-	flag.Parse()
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
-			log.Fatal(err)
+			return c.fail(err)
 		}
 		pprof.StartCPUProfile(f)
 		defer pprof.StopCPUProfile()
 	}
 
-	// Check if folder exists and is a folder
-	fileInfo, err := os.Stat(folder)
+	sink := writeTo(out)
+	if *reportPath != "" {
+		if opts.QualityPolicy == pdq.QUALITY_POLICY_KEEP {
+			opts.QualityPolicy = pdq.QUALITY_POLICY_FLAG
+		}
+		f, err := os.Create(*reportPath)
+		if err != nil {
+			return c.fail(err)
+		}
+		defer f.Close()
+		report, err := newLowQualityReport(f)
+		if err != nil {
+			return c.fail(err)
+		}
+		defer func() {
+			if err := report.flush(); err != nil {
+				code = c.fail(err)
+			}
+		}()
+		sink = func(result *fileResult) error {
+			if err := report.add(result); err != nil {
+				return err
+			}
+			return out.Write(result)
+		}
+	}
+
+	code = EXIT_OK
+	summary, err := scanFiles(sink, opts, func(q *scanQueue) error {
+		if *stdinImage {
+			data, err := io.ReadAll(c.stdin)
+			if err != nil {
+				q.fail(STDIN_PATH, err)
+				return nil
+			}
+			filetypeRef, _ := filetype.Match(data)
+			q.submitData(STDIN_PATH, filetypeRef.MIME.Value, data)
+			return nil
+		}
+		for _, path := range paths {
+			if path == STDIN_PATH {
+				if err := readPathList(c.stdin, sep, q.submitPath); err != nil {
+					return err
+				}
+			} else if !q.submitPath(path) {
+				return errScanStopped
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		log.Fatal(err)
+		code = c.fail(err)
+	}
+	fmt.Fprintf(c.stderr, "%s\n", summary)
+	if *prune {
+		fmt.Fprintf(c.stderr, "pruned %d scan cache entries\n", opts.Cache.Prune(nil))
+	}
+	if *strict && summary.Failed > 0 {
+		code = EXIT_ERROR
 	}
+	if err := out.Close(); err != nil {
+		return c.fail(err)
+	}
+	return code
+}
 
-	vips.LoggingSettings(nil, vips.LogLevelMessage)
-	vips.Startup(&vips.Config{
-		ConcurrencyLevel: 0,
-		MaxCacheFiles:    5,
-		MaxCacheMem:      50 * 1024 * 1024,
-		MaxCacheSize:     100,
-		ReportLeaks:      false,
-		CacheTrace:       false,
-		CollectStats:     false,
+func processFolder(filename string, sink func(*fileResult) error, opts scanOptions) (scanSummary, error) {
+	return processPaths([]string{filename}, sink, opts)
+}
+
+// Hashes files and the images below folders, and with opts.Archives the
+// images inside archives.
+func processPaths(paths []string, sink func(*fileResult) error, opts scanOptions) (scanSummary, error) {
+	return scanFiles(sink, opts, func(q *scanQueue) error {
+		for _, path := range paths {
+			if !q.submitPath(path) {
+				return errScanStopped
+			}
+		}
+		return nil
 	})
-	defer vips.Shutdown()
-	if !fileInfo.IsDir() {
-		processFile(folder, detailedOutput)
-	} else {
-		err := processFolder(folder, detailedOutput)
-		if err != nil {
-			log.Fatal(err)
+}
+
+// Calls fn for each path in a list separated by sep, skipping empty
+// entries, until fn returns false.
+func readPathList(r io.Reader, sep byte, fn func(path string) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, sep); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	for scanner.Scan() {
+		path := scanner.Text()
+		if sep == '\n' {
+			path = strings.TrimSuffix(path, "\r")
+		}
+		if path == "" {
+			continue
 		}
+		if !fn(path) {
+			return errScanStopped
+		}
+	}
+	return scanner.Err()
+}
+
+// Hashes one file already read. Failures are recorded in the result
+// rather than returned, so that they can be reported alongside the other
+// files.
+func bufferResult(hasher *pdq.PDQHasher, path, mime string, buf []byte) *fileResult {
+	result := &fileResult{Path: path, MIME: mime}
+	hashAndQuality, err := hasher.FromBuffer(buf)
+	if err != nil {
+		result.setError(err)
+		return result
 	}
+	result.setHashAndQuality(hashAndQuality)
+	return result
+}
+
+// Like bufferResult, but also keeps the hashes of the seven rotations and
+// flips.
+func dihedralResult(hasher *pdq.PDQHasher, path, mime string, buf []byte) *fileResult {
+	result := &fileResult{Path: path, MIME: mime}
+	hashes, err := hasher.DihedralFromBuffer(buf, pdq.PDQ_DO_DIH_ALL)
+	if err != nil {
+		result.setError(err)
+		return result
+	}
+	result.setHash(hashes.Hash, hashes.Quality)
+	result.LowQuality = hashes.LowQuality
+	if hashes.Crop != nil {
+		result.Crop = hashes.Crop.String()
+	}
+	result.Orientation = hashes.Orientation
+	result.dihedral = hashes.Hashes()
+	return result
+}
+
+// Like bufferResult, but also keeps the hashes of the regions placed by
+// layout. Regions that fail on their own, e.g. for low quality or for
+// being empty, are left out.
+func regionsResult(hasher *pdq.PDQHasher, path, mime string, buf []byte, layout pdq.RegionLayout) *fileResult {
+	result := &fileResult{Path: path, MIME: mime}
+	hashAndQuality, regions, err := hasher.FromBufferWithRegions(buf, layout)
+	if err != nil {
+		result.setError(err)
+		return result
+	}
+	result.setHashAndQuality(hashAndQuality)
+	for _, region := range regions {
+		if region.Err == nil {
+			result.regions = append(result.regions, region)
+		}
+	}
+	return result
+}
+
+func failedResult(path string, err error) *fileResult {
+	result := &fileResult{Path: path}
+	result.setError(err)
+	return result
+}
+
+// Calls fn for every image file below paths, in lexical order per folder,
+// and for every named file. Non-image files are skipped; files and folders
+// that cannot be read are passed to fn with their error and the walk
+// goes on.
+func walkImages(paths []string, fn func(path string, err error)) {
+	for _, root := range paths {
+		info, err := os.Stat(root)
+		if err != nil || !info.IsDir() {
+			fn(root, err)
+			continue
+		}
+		filepath.Walk(root, func(fullPath string, item os.FileInfo, err error) error {
+			if err != nil {
+				fn(fullPath, err)
+				return nil
+			}
+			if item.IsDir() {
+				return nil
+			}
+			filetypeRef, err := filetype.MatchFile(fullPath)
+			if err != nil {
+				fn(fullPath, err)
+			} else if filetypeRef.MIME.Type == "image" {
+				fn(fullPath, nil)
+			}
+			return nil
+		})
+	}
+}
+
+// Hashes a file, reporting failures instead of exiting like FromFile.
+func hashFile(hasher *pdq.PDQHasher, path string) (pdq.HashAndQuality, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return pdq.HashAndQuality{}, err
+	}
+	hashAndQuality, err := hasher.FromBuffer(buf)
+	if err != nil {
+		return pdq.HashAndQuality{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return hashAndQuality, nil
+}
+
+// Hashes the images below paths like the hash command, in order. Files
+// that cannot be hashed are written to stderr and left out, followed by
+// the summary; only a failure to write stops the scan.
+func (c *cli) hashImages(opts scanOptions, paths []string) ([]*fileResult, error) {
+	opts.Ordered = true
+	var files []*fileResult
+	summary, err := processPaths(paths, func(result *fileResult) error {
+		if result.Error != "" {
+			line, _ := result.plain(false)
+			_, err := fmt.Fprintln(c.stderr, line)
+			return err
+		}
+		files = append(files, result)
+		return nil
+	}, opts)
+	fmt.Fprintf(c.stderr, "%s\n", summary)
+	return files, err
 }
@@ -1,13 +1,33 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/MTRNord/pdqhash-go/hashlist"
+	"github.com/MTRNord/pdqhash-go/index"
+	"github.com/MTRNord/pdqhash-go/server"
+	"github.com/MTRNord/pdqhash-go/types"
 	"github.com/davidbyttow/govips/v2/vips"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestProcessFolder(t *testing.T) {
+const DIH_FOLDER = "../test-images/reg-test-input/dih"
+const BRIDGE_ORIGINAL = DIH_FOLDER + "/bridge-1-original.jpg"
+const BRIDGE_ORIGINAL_HASH = "d8f8f0cce0f4a84f0e370a22028f67f0b36e2ed596623e1d33e6b39c4e9c9b22"
+
+func TestMain(m *testing.M) {
 	vips.LoggingSettings(nil, vips.LogLevelMessage)
 	vips.Startup(&vips.Config{
 		ConcurrencyLevel: 0,
@@ -19,6 +39,863 @@ func TestProcessFolder(t *testing.T) {
 		CollectStats:     false,
 	})
 	defer vips.Shutdown()
-	err := processFolder("../test-images", true)
+
+	os.Exit(m.Run())
+}
+
+func runScanner(args ...string) (int, string, string) {
+	return runScannerInput("", args...)
+}
+
+func runScannerInput(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestProcessFolder(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	summary, err := processFolder("../test-images", writeTo(&plainWriter{&stdout, &stderr, true}), scanOptions{})
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, 0, summary.Failed)
+}
+
+func TestUsage(t *testing.T) {
+	code, _, stderr := runScanner()
+	assert.Equal(t, EXIT_ERROR, code)
+	assert.Contains(t, stderr, "dedupe")
+
+	code, _, stderr = runScanner("frobnicate")
+	assert.Equal(t, EXIT_ERROR, code)
+	assert.Contains(t, stderr, "unknown command")
+
+	code, _, _ = runScanner("hash", "-h")
+	assert.Equal(t, EXIT_OK, code)
+
+	code, _, _ = runScanner("hash", "-nope")
+	assert.Equal(t, EXIT_ERROR, code)
+}
+
+func TestHashCommand(t *testing.T) {
+	code, _, _ := runScanner("hash", "-detailed", "../test-images")
+	assert.Equal(t, EXIT_OK, code)
+
+	// The flag-only form of the original scanner still works.
+	code, _, _ = runScanner("-folder", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_OK, code)
+
+	code, _, _ = runScanner("hash", "../test-images/missing")
+	assert.Equal(t, EXIT_OK, code)
+
+	code, _, _ = runScanner("hash", "-strict", "../test-images/missing")
+	assert.Equal(t, EXIT_ERROR, code)
+}
+
+func TestOutputFormats(t *testing.T) {
+	code, stdout, _ := runScanner("hash", "-output", "csv", DIH_FOLDER)
+	assert.Equal(t, EXIT_OK, code)
+	records, err := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, records, 9)
+	assert.Equal(t, CSV_HEADER, records[0])
+	assert.Equal(t, []string{BRIDGE_ORIGINAL_HASH, records[1][1], records[1][2], "0", "1600", "1004", "image/jpeg", "", "", "", "", "", "", BRIDGE_ORIGINAL}, records[1])
+
+	code, stdout, _ = runScanner("hash", "-output", "json", DIH_FOLDER)
+	assert.Equal(t, EXIT_OK, code)
+	var results []fileResult
+	assert.ErrorIs(t, json.Unmarshal([]byte(stdout), &results), nil)
+	assert.Len(t, results, 8)
+	assert.Equal(t, BRIDGE_ORIGINAL_HASH, results[0].Hash)
+	assert.Equal(t, 0, results[0].Delta)
+	assert.NotEqual(t, 0, results[1].Delta)
+
+	code, stdout, _ = runScanner("hash", "-output", "ndjson", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_OK, code)
+	var result fileResult
+	assert.ErrorIs(t, json.Unmarshal([]byte(stdout), &result), nil)
+	assert.Equal(t, BRIDGE_ORIGINAL_HASH, result.Hash)
+	assert.Equal(t, "image/jpeg", result.MIME)
+
+	code, _, _ = runScanner("hash", "-output", "xml", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_ERROR, code)
+}
+
+func TestOutputErrors(t *testing.T) {
+	// Failures are reported in place and do not stop the scan.
+	code, stdout, stderr := runScanner("hash", "-output", "json", "scanner_test.go", "missing.jpg", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_OK, code)
+	var results []fileResult
+	assert.ErrorIs(t, json.Unmarshal([]byte(stdout), &results), nil)
+	assert.Len(t, results, 3)
+	assert.Equal(t, "scanner_test.go", results[0].Path)
+	assert.Equal(t, "", results[0].Hash)
+	assert.NotEqual(t, "", results[0].Error)
+	assert.Equal(t, pdq.FAILURE_UNSUPPORTED_FORMAT, results[0].Reason)
+	assert.Equal(t, "missing.jpg", results[1].Path)
+	assert.Equal(t, pdq.FAILURE_IO, results[1].Reason)
+	assert.Equal(t, BRIDGE_ORIGINAL_HASH, results[2].Hash)
+	assert.Equal(t, "1 hashed, 2 failed (io: 1, unsupported_format: 1)\n", stderr)
+
+	code, _, _ = runScanner("hash", "-strict", "-output", "json", "scanner_test.go", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_ERROR, code)
+}
+
+func TestScanSummary(t *testing.T) {
+	var summary scanSummary
+	assert.Equal(t, "0 hashed, 0 failed", summary.String())
+
+	summary.add(&fileResult{Hash: BRIDGE_ORIGINAL_HASH})
+	summary.add(&fileResult{Error: "x", Reason: pdq.FAILURE_CORRUPT})
+	summary.add(&fileResult{Error: "x", Reason: pdq.FAILURE_IO})
+	summary.add(&fileResult{Error: "x", Reason: pdq.FAILURE_CORRUPT})
+	assert.Equal(t, "1 hashed, 3 failed (corrupt: 2, io: 1)", summary.String())
+
+	summary.add(&fileResult{Hash: BRIDGE_ORIGINAL_HASH, LowQuality: pdq.LOW_QUALITY_FLAT})
+	assert.Equal(t, "2 hashed, 3 failed (corrupt: 2, io: 1), 1 low quality", summary.String())
+}
+
+func TestFlagsCheckedBeforeOutput(t *testing.T) {
+	report := filepath.Join(t.TempDir(), "low-quality.csv")
+	assert.ErrorIs(t, os.WriteFile(report, []byte("kept\n"), 0o644), nil)
+	for _, args := range [][]string{
+		{"-rehash"},
+		{"-prune"},
+		{"-quality-policy", "drop"},
+	} {
+		args = append(append([]string{"hash", "-low-quality-report", report}, args...), BRIDGE_ORIGINAL)
+		code, _, _ := runScanner(args...)
+		assert.Equal(t, EXIT_ERROR, code, args)
+		b, err := os.ReadFile(report)
+		assert.ErrorIs(t, err, nil)
+		assert.Equal(t, "kept\n", string(b), args)
+	}
+}
+
+func TestQualityPolicy(t *testing.T) {
+	misc := "../test-images/misc-images"
+	report := filepath.Join(t.TempDir(), "low-quality.csv")
+
+	// Nothing reaches a minimum above 100.
+	code, stdout, stderr := runScanner("hash", "-output", "ndjson", "-min-quality", "101", "-low-quality-report", report, misc)
+	assert.Equal(t, EXIT_OK, code)
+	assert.Equal(t, "4 hashed, 0 failed, 4 low quality\n", stderr)
+	assert.Equal(t, 4, strings.Count(stdout, `"low_quality":`))
+
+	f, err := os.Open(report)
+	assert.ErrorIs(t, err, nil)
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, records, 5)
+	assert.Equal(t, LOW_QUALITY_CSV_HEADER, records[0])
+	assert.Equal(t, []string{misc + "/wee.jpg", records[4][1], pdq.LOW_QUALITY_TINY, "34", "42", "false"}, records[4])
+
+	code, stdout, stderr = runScanner("hash", "-output", "ndjson", "-min-quality", "101", "-quality-policy", "reject", misc+"/wee.jpg")
+	assert.Equal(t, EXIT_OK, code)
+	assert.Equal(t, "0 hashed, 1 failed (low_quality: 1), 1 low quality\n", stderr)
+	var result fileResult
+	assert.ErrorIs(t, json.Unmarshal([]byte(stdout), &result), nil)
+	assert.Equal(t, "", result.Hash)
+	assert.Equal(t, pdq.FAILURE_LOW_QUALITY, result.Reason)
+	assert.Equal(t, pdq.LOW_QUALITY_TINY, result.LowQuality)
+
+	// The default policy keeps every hash without judging it.
+	_, stdout, _ = runScanner("hash", "-output", "ndjson", "-min-quality", "101", misc)
+	assert.NotContains(t, stdout, "low_quality")
+
+	code, _, _ = runScanner("hash", "-quality-policy", "drop", misc)
+	assert.Equal(t, EXIT_ERROR, code)
+}
+
+func TestParallelScan(t *testing.T) {
+	code, sequential, _ := runScanner("hash", "-output", "ndjson", "../test-images")
+	assert.Equal(t, EXIT_OK, code)
+
+	code, ordered, _ := runScanner("hash", "-output", "ndjson", "-workers", "4", "-ordered", "../test-images")
+	assert.Equal(t, EXIT_OK, code)
+	assert.Equal(t, sequential, ordered)
+
+	code, unordered, _ := runScanner("hash", "-output", "ndjson", "-workers", "4", "../test-images")
+	assert.Equal(t, EXIT_OK, code)
+	sequentialLines := strings.Split(strings.TrimSpace(sequential), "\n")
+	unorderedLines := strings.Split(strings.TrimSpace(unordered), "\n")
+	assert.Len(t, unorderedLines, len(sequentialLines))
+
+	// Without -ordered only the delta column may differ.
+	hashes := map[string]string{}
+	for _, line := range sequentialLines {
+		var result fileResult
+		assert.ErrorIs(t, json.Unmarshal([]byte(line), &result), nil)
+		hashes[result.Path] = result.Hash
+	}
+	for _, line := range unorderedLines {
+		var result fileResult
+		assert.ErrorIs(t, json.Unmarshal([]byte(line), &result), nil)
+		assert.Equal(t, hashes[result.Path], result.Hash, result.Path)
+	}
+}
+
+func TestScanCache(t *testing.T) {
+	dir := t.TempDir()
+	image := filepath.Join(dir, "bridge.jpg")
+	buf, err := os.ReadFile(BRIDGE_ORIGINAL)
+	assert.ErrorIs(t, err, nil)
+	assert.ErrorIs(t, os.WriteFile(image, buf, 0o644), nil)
+	cache := filepath.Join(t.TempDir(), "scan.cache")
+
+	code, first, stderr := runScanner("hash", "-cache", cache, dir)
+	assert.Equal(t, EXIT_OK, code)
+	assert.True(t, strings.HasPrefix(first, BRIDGE_ORIGINAL_HASH+","))
+	assert.True(t, strings.HasSuffix(first, ","+image+"\n"))
+	assert.Equal(t, "1 hashed, 0 failed\n", stderr)
+
+	code, second, stderr := runScanner("hash", "-cache", cache, dir)
+	assert.Equal(t, EXIT_OK, code)
+	assert.Equal(t, first, second)
+	assert.Equal(t, "1 hashed (1 cached), 0 failed\n", stderr)
+
+	// Touching the file is caught by the content digest.
+	later := time.Now().Add(time.Hour)
+	assert.ErrorIs(t, os.Chtimes(image, later, later), nil)
+	_, _, stderr = runScanner("hash", "-cache", cache, dir)
+	assert.Equal(t, "1 hashed (1 cached), 0 failed\n", stderr)
+
+	_, _, stderr = runScanner("hash", "-cache", cache, "-rehash", dir)
+	assert.Equal(t, "1 hashed, 0 failed\n", stderr)
+
+	assert.ErrorIs(t, os.Remove(image), nil)
+	code, _, stderr = runScanner("hash", "-cache", cache, "-prune", dir)
+	assert.Equal(t, EXIT_OK, code)
+	assert.Equal(t, "0 hashed, 0 failed\npruned 1 scan cache entries\n", stderr)
+
+	code, _, _ = runScanner("hash", "-prune", dir)
+	assert.Equal(t, EXIT_ERROR, code)
+}
+
+func TestArchives(t *testing.T) {
+	bridge, err := os.ReadFile(BRIDGE_ORIGINAL)
+	assert.ErrorIs(t, err, nil)
+	wee, err := os.ReadFile("../test-images/misc-images/wee.jpg")
+	assert.ErrorIs(t, err, nil)
+
+	var tarBuf bytes.Buffer
+	gw := gzip.NewWriter(&tarBuf)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "wee.jpg", Mode: 0o644, Size: int64(len(wee)), Typeflag: tar.TypeReg})
+	tw.Write(wee)
+	assert.ErrorIs(t, tw.Close(), nil)
+	assert.ErrorIs(t, gw.Close(), nil)
+
+	dir := t.TempDir()
+	drop := filepath.Join(dir, "drop.zip")
+	f, err := os.Create(drop)
+	assert.ErrorIs(t, err, nil)
+	zw := zip.NewWriter(f)
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{
+		{"photos/bridge.jpg", bridge},
+		{"notes.txt", []byte("not an image")},
+		{"more.tar.gz", tarBuf.Bytes()},
+	} {
+		w, err := zw.Create(entry.name)
+		assert.ErrorIs(t, err, nil)
+		w.Write(entry.data)
+	}
+	assert.ErrorIs(t, zw.Close(), nil)
+	assert.ErrorIs(t, f.Close(), nil)
+
+	code, stdout, stderr := runScanner("hash", "-output", "ndjson", dir)
+	assert.Equal(t, EXIT_OK, code)
+	assert.Equal(t, "2 hashed, 0 failed\n", stderr)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	assert.Len(t, lines, 2)
+	var result fileResult
+	assert.ErrorIs(t, json.Unmarshal([]byte(lines[0]), &result), nil)
+	assert.Equal(t, drop+"!/photos/bridge.jpg", result.Path)
+	assert.Equal(t, BRIDGE_ORIGINAL_HASH, result.Hash)
+	assert.Equal(t, "image/jpeg", result.MIME)
+	assert.ErrorIs(t, json.Unmarshal([]byte(lines[1]), &result), nil)
+	assert.Equal(t, drop+"!/more.tar.gz!/wee.jpg", result.Path)
+
+	code, stdout, _ = runScanner("hash", "-output", "ndjson", "-archive-depth", "1", drop)
+	assert.Equal(t, EXIT_OK, code)
+	lines = strings.Split(strings.TrimSpace(stdout), "\n")
+	assert.Len(t, lines, 2)
+	assert.ErrorIs(t, json.Unmarshal([]byte(lines[1]), &result), nil)
+	assert.Equal(t, drop+"!/more.tar.gz", result.Path)
+	assert.Equal(t, REASON_ARCHIVE_LIMIT, result.Reason)
+
+	_, _, stderr = runScanner("hash", "-archives=false", dir)
+	assert.Equal(t, "0 hashed, 0 failed\n", stderr)
+}
+
+func TestResultWriters(t *testing.T) {
+	results := []*fileResult{
+		{Path: "a.jpg", Hash: BRIDGE_ORIGINAL_HASH, Quality: 100, Norm: 128, Width: 2, Height: 3, MIME: "image/jpeg"},
+		{Path: "b.png", Error: "error decoding image", Reason: pdq.FAILURE_CORRUPT},
+	}
+	expected := map[string]string{
+		OUTPUT_PLAIN: BRIDGE_ORIGINAL_HASH + ",100,a.jpg\n",
+		OUTPUT_CSV: "hash,quality,norm,delta,width,height,mime,low_quality,crop,orientation,displayed_hash,error,reason,path\n" +
+			BRIDGE_ORIGINAL_HASH + ",100,128,0,2,3,image/jpeg,,,,,,,a.jpg\n" +
+			",0,0,0,0,0,,,,,,error decoding image,corrupt,b.png\n",
+		OUTPUT_JSON: "[\n" +
+			`  {"path":"a.jpg","hash":"` + BRIDGE_ORIGINAL_HASH + `","quality":100,"norm":128,"delta":0,"width":2,"height":3,"mime":"image/jpeg"},` + "\n" +
+			`  {"path":"b.png","quality":0,"norm":0,"delta":0,"width":0,"height":0,"error":"error decoding image","reason":"corrupt"}` + "\n]\n",
+		OUTPUT_NDJSON: `{"path":"a.jpg","hash":"` + BRIDGE_ORIGINAL_HASH + `","quality":100,"norm":128,"delta":0,"width":2,"height":3,"mime":"image/jpeg"}` + "\n" +
+			`{"path":"b.png","quality":0,"norm":0,"delta":0,"width":0,"height":0,"error":"error decoding image","reason":"corrupt"}` + "\n",
+	}
+
+	for _, format := range OUTPUT_FORMATS {
+		var stdout, stderr bytes.Buffer
+		w, err := newResultWriter(format, CSV_HEADER, false, &stdout, &stderr)
+		assert.ErrorIs(t, err, nil)
+		for _, r := range results {
+			assert.ErrorIs(t, w.Write(r), nil)
+		}
+		assert.ErrorIs(t, w.Close(), nil)
+		assert.Equal(t, expected[format], stdout.String(), format)
+	}
+
+	var stdout bytes.Buffer
+	w, _ := newResultWriter(OUTPUT_JSON, CSV_HEADER, false, &stdout, &stdout)
+	assert.ErrorIs(t, w.Close(), nil)
+	assert.Equal(t, "[]\n", stdout.String())
+
+	// -detailed keeps the original scanner's line, low quality or not.
+	stdout.Reset()
+	w, _ = newResultWriter(OUTPUT_PLAIN, CSV_HEADER, true, &stdout, &stdout)
+	results[0].LowQuality = pdq.LOW_QUALITY_FLAT
+	assert.ErrorIs(t, w.Write(results[0]), nil)
+	assert.ErrorIs(t, w.Close(), nil)
+	assert.Equal(t, "hash="+BRIDGE_ORIGINAL_HASH+",norm=128,delta=0,quality=100,filename=a.jpg\n", stdout.String())
+}
+
+func TestDihedralCommand(t *testing.T) {
+	code, stdout, _ := runScanner("dihedral", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_OK, code)
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	assert.Len(t, lines, 8)
+	assert.True(t, strings.HasPrefix(lines[0], BRIDGE_ORIGINAL_HASH+","))
+	assert.Contains(t, lines[0], ",original,")
+	assert.Contains(t, lines[7], ",flipminus1,")
+
+	// Unreadable files are reported and do not stop the others.
+	code, stdout, stderr := runScanner("dihedral", "missing.jpg", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_OK, code)
+	assert.Len(t, strings.Split(strings.TrimSpace(stdout), "\n"), 8)
+	assert.True(t, strings.HasPrefix(stderr, "missing.jpg: "))
+	assert.True(t, strings.HasSuffix(stderr, "1 hashed, 1 failed (io: 1)\n"))
+}
+
+func TestCompareCommand(t *testing.T) {
+	code, stdout, _ := runScanner("compare", BRIDGE_ORIGINAL, BRIDGE_ORIGINAL_HASH)
+	assert.Equal(t, EXIT_OK, code)
+	assert.Equal(t, "0\n", stdout)
+
+	code, _, _ = runScanner("compare", "-threshold", "10", BRIDGE_ORIGINAL, DIH_FOLDER+"/bridge-2-rotate-90.jpg")
+	assert.Equal(t, EXIT_NO_MATCH, code)
+
+	code, _, _ = runScanner("compare", BRIDGE_ORIGINAL, "not-a-hash")
+	assert.Equal(t, EXIT_ERROR, code)
+}
+
+func TestIndexBuildAndMatchCommands(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "dih.csv")
+	code, _, _ := runScanner("index", "build", "-o", list, DIH_FOLDER)
+	assert.Equal(t, EXIT_OK, code)
+
+	entries, err := hashlist.ReadFile(list)
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, entries, 8)
+	assert.Equal(t, BRIDGE_ORIGINAL, entries[0].ID)
+	assert.Equal(t, BRIDGE_ORIGINAL_HASH, entries[0].Hash.String())
+
+	code, stdout, _ := runScanner("match", "-hashlist", list, "-output", "ndjson", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_OK, code)
+	var match matchResult
+	assert.ErrorIs(t, json.Unmarshal([]byte(strings.Split(stdout, "\n")[0]), &match), nil)
+	assert.Equal(t, BRIDGE_ORIGINAL, match.Query)
+	assert.Equal(t, BRIDGE_ORIGINAL, match.Reference)
+	assert.Equal(t, BRIDGE_ORIGINAL_HASH, match.ReferenceHash)
+	assert.Equal(t, 0, match.Distance)
+	assert.Equal(t, match.QueryQuality, match.ReferenceQuality)
+
+	code, _, _ = runScanner("match", "-hashlist", list, "../test-images/misc-images/wee.jpg")
+	assert.Equal(t, EXIT_NO_MATCH, code)
+
+	code, _, _ = runScanner("match", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_ERROR, code)
+
+	code, _, _ = runScanner("index", "list")
+	assert.Equal(t, EXIT_ERROR, code)
+
+	code, _, stderr := runScanner("index", "build", "-o", list, "missing.jpg", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_OK, code)
+	assert.True(t, strings.HasSuffix(stderr, "1 hashed, 1 failed (io: 1)\n"))
+	entries, err = hashlist.ReadFile(list)
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, entries, 1)
+}
+
+func TestMatchAgainstFolder(t *testing.T) {
+	code, stdout, _ := runScanner("match", "-reference", DIH_FOLDER, BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_OK, code)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	assert.True(t, strings.HasPrefix(lines[0], "0,"))
+	assert.True(t, strings.HasSuffix(lines[0], ","+BRIDGE_ORIGINAL+","+BRIDGE_ORIGINAL))
+
+	// Every rotation and flip of the bridge is found through the matching
+	// transform of the query.
+	code, stdout, _ = runScanner("match", "-reference", DIH_FOLDER, "-dihedral", "-output", "csv", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_OK, code)
+	records, err := csv.NewReader(strings.NewReader(stdout)).ReadAll()
 	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, MATCH_CSV_HEADER, records[0])
+	assert.Len(t, records, 9)
+	assert.Equal(t, []string{BRIDGE_ORIGINAL, BRIDGE_ORIGINAL_HASH, records[1][2], BRIDGE_ORIGINAL, BRIDGE_ORIGINAL_HASH, records[1][2], "0", "original", ""}, records[1])
+
+	code, _, _ = runScanner("match", "-reference", DIH_FOLDER, "-hashlist", "x.csv", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_ERROR, code)
+}
+
+func TestMatchResults(t *testing.T) {
+	hash := func(s string) *types.Hash256 {
+		h, err := types.Hash256FromHexString(s)
+		assert.ErrorIs(t, err, nil)
+		return h
+	}
+	a := hash(BRIDGE_ORIGINAL_HASH)
+	flipped := a.BitwiseNOT()
+	near := a.Clone()
+	near.FlipBit(3)
+
+	idx := index.New()
+	idx.Add(&hashlist.Entry{Hash: &near, Quality: hashlist.QUALITY_UNKNOWN, ID: "near"})
+	idx.Add(&hashlist.Entry{Hash: &flipped, Quality: 80, ID: "flipped"})
+
+	query := &fileResult{Path: "q.jpg", Quality: 90}
+	query.setHash(a, 90)
+	matches := matchResults(idx, query, 31)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, &matchResult{"q.jpg", BRIDGE_ORIGINAL_HASH, 90, "near", near.String(), -1, 1, "", ""}, matches[0])
+
+	further := near.Clone()
+	further.FlipBit(4)
+	further.FlipBit(5)
+	query.dihedral = []pdq.DihedralHash{{Name: "original", Hash: a}, {Name: "flipx", Hash: &flipped}, {Name: "flipy", Hash: &further}}
+	matches = matchResults(idx, query, 31)
+	assert.Len(t, matches, 2)
+	assert.Equal(t, "flipped", matches[0].Reference)
+	assert.Equal(t, "flipx", matches[0].Transform)
+	assert.Equal(t, 0, matches[0].Distance)
+	assert.Equal(t, "near", matches[1].Reference)
+	assert.Equal(t, "original", matches[1].Transform)
+	assert.Equal(t, 1, matches[1].Distance)
+}
+
+func TestDedupeCommand(t *testing.T) {
+	// Every file appears twice, so each forms a group with its copy.
+	code, stdout, _ := runScanner("dedupe", "-threshold", "0", DIH_FOLDER, DIH_FOLDER)
+	assert.Equal(t, EXIT_OK, code)
+
+	groups := strings.Split(strings.TrimSpace(stdout), "\n\n")
+	assert.Len(t, groups, 8)
+	duplicate := strings.Split(groups[0], "\n")[1]
+	assert.True(t, strings.HasPrefix(duplicate, BRIDGE_ORIGINAL_HASH+","))
+	assert.True(t, strings.HasSuffix(duplicate, ",0,"+BRIDGE_ORIGINAL))
+
+	code, _, _ = runScanner("dedupe", "-threshold", "0", DIH_FOLDER)
+	assert.Equal(t, EXIT_NO_MATCH, code)
+
+	code, stdout, stderr := runScanner("dedupe", "-threshold", "0", BRIDGE_ORIGINAL, "missing.jpg", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_OK, code)
+	assert.Len(t, strings.Split(strings.TrimSpace(stdout), "\n"), 2)
+	assert.True(t, strings.HasSuffix(stderr, "2 hashed, 1 failed (io: 1)\n"))
+}
+
+func TestServeCommand(t *testing.T) {
+	code, _, stderr := runScanner("serve", "-addr", "127.0.0.1:0", "-hashlist", "../test-images/missing.csv")
+	assert.Equal(t, EXIT_ERROR, code)
+	assert.Contains(t, stderr, "missing.csv")
+
+	c := &cli{stdout: &bytes.Buffer{}, stderr: &bytes.Buffer{}}
+	opts := server.DefaultOptions()
+	opts.Addr = "127.0.0.1:0"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, EXIT_OK, c.serve(ctx, opts))
+}
+
+func TestWatchCommand(t *testing.T) {
+	dir := t.TempDir()
+	watched := filepath.Join(dir, "incoming")
+	assert.ErrorIs(t, os.Mkdir(watched, 0o755), nil)
+	list := filepath.Join(dir, "list.csv")
+	code, _, _ := runScanner("index", "build", "-o", list, BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_OK, code)
+	out := filepath.Join(dir, "out.ndjson")
+	matches := filepath.Join(dir, "matches.ndjson")
+
+	stderr := &bytes.Buffer{}
+	c := &cli{stdout: &bytes.Buffer{}, stderr: stderr}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan int)
+	go func() {
+		done <- c.watch(ctx, []string{"-settle", "200ms", "-output", "ndjson", "-o", out, "-hashlist", list, "-matches", matches, watched})
+	}()
+	// Give the watcher time to start.
+	time.Sleep(200 * time.Millisecond)
+
+	// Written in two parts, which must be hashed once, when complete.
+	data, err := os.ReadFile(BRIDGE_ORIGINAL)
+	assert.ErrorIs(t, err, nil)
+	f, err := os.Create(filepath.Join(watched, "bridge.jpg"))
+	assert.ErrorIs(t, err, nil)
+	f.Write(data[:len(data)/2])
+	time.Sleep(50 * time.Millisecond)
+	f.Write(data[len(data)/2:])
+	f.Close()
+	assert.ErrorIs(t, os.WriteFile(filepath.Join(watched, "notes.txt"), []byte("not an image"), 0o644), nil)
+
+	assert.Eventually(t, func() bool {
+		b, _ := os.ReadFile(matches)
+		return len(b) > 0
+	}, 10*time.Second, 50*time.Millisecond)
+	cancel()
+	assert.Equal(t, EXIT_OK, <-done)
+
+	b, err := os.ReadFile(out)
+	assert.ErrorIs(t, err, nil)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	assert.Len(t, lines, 1)
+	var result fileResult
+	assert.ErrorIs(t, json.Unmarshal([]byte(lines[0]), &result), nil)
+	assert.Equal(t, BRIDGE_ORIGINAL_HASH, result.Hash)
+	assert.Equal(t, filepath.Join(watched, "bridge.jpg"), result.Path)
+
+	b, err = os.ReadFile(matches)
+	assert.ErrorIs(t, err, nil)
+	var match matchResult
+	assert.ErrorIs(t, json.Unmarshal(b, &match), nil)
+	assert.Equal(t, BRIDGE_ORIGINAL, match.Reference)
+	assert.Equal(t, 0, match.Distance)
+	assert.Contains(t, stderr.String(), "1 hashed")
+
+	code, _, _ = runScanner("watch")
+	assert.Equal(t, EXIT_ERROR, code)
+	code, _, _ = runScanner("watch", filepath.Join(dir, "missing"))
+	assert.Equal(t, EXIT_ERROR, code)
+}
+
+func TestDebouncer(t *testing.T) {
+	d := newDebouncer(100 * time.Millisecond)
+	defer d.stop()
+
+	start := time.Now()
+	d.touch("a")
+	time.Sleep(60 * time.Millisecond)
+	d.touch("a")
+	d.touch("b")
+	d.cancel("b")
+	assert.Equal(t, "a", <-d.ready)
+	assert.GreaterOrEqual(t, time.Since(start), 160*time.Millisecond)
+
+	select {
+	case path := <-d.ready:
+		t.Errorf("unexpected %s", path)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestReadPathList(t *testing.T) {
+	var paths []string
+	collect := func(path string) bool {
+		paths = append(paths, path)
+		return true
+	}
+	assert.ErrorIs(t, readPathList(strings.NewReader("a.jpg\r\n\nb c.jpg\nd.jpg"), '\n', collect), nil)
+	assert.Equal(t, []string{"a.jpg", "b c.jpg", "d.jpg"}, paths)
+
+	paths = nil
+	assert.ErrorIs(t, readPathList(strings.NewReader("a\nb.jpg\x00c.jpg\x00"), 0, collect), nil)
+	assert.Equal(t, []string{"a\nb.jpg", "c.jpg"}, paths)
+
+	err := readPathList(strings.NewReader("a\nb\n"), '\n', func(path string) bool { return false })
+	assert.ErrorIs(t, err, errScanStopped)
+}
+
+func TestStdinInput(t *testing.T) {
+	list := BRIDGE_ORIGINAL + "\x00" + "../test-images/missing.jpg" + "\x00"
+	code, stdout, stderr := runScannerInput(list, "hash", "-0", "-output", "csv", "-")
+	assert.Equal(t, EXIT_OK, code)
+	records, err := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, records, 3)
+	assert.Equal(t, BRIDGE_ORIGINAL_HASH, records[1][0])
+	assert.Equal(t, BRIDGE_ORIGINAL, records[1][13])
+	assert.Equal(t, pdq.FAILURE_IO, records[2][12])
+	assert.Contains(t, stderr, "1 hashed")
+
+	code, stdout, _ = runScannerInput(BRIDGE_ORIGINAL+"\n", "-folder", "-")
+	assert.Equal(t, EXIT_OK, code)
+	assert.True(t, strings.HasPrefix(stdout, BRIDGE_ORIGINAL_HASH+","))
+	assert.True(t, strings.HasSuffix(stdout, ","+BRIDGE_ORIGINAL+"\n"))
+
+	data, err := os.ReadFile(BRIDGE_ORIGINAL)
+	assert.ErrorIs(t, err, nil)
+	code, stdout, _ = runScannerInput(string(data), "hash", "-stdin-image", "-output", "ndjson")
+	assert.Equal(t, EXIT_OK, code)
+	var result fileResult
+	assert.ErrorIs(t, json.Unmarshal([]byte(stdout), &result), nil)
+	assert.Equal(t, BRIDGE_ORIGINAL_HASH, result.Hash)
+	assert.Equal(t, "-", result.Path)
+	assert.Equal(t, "image/jpeg", result.MIME)
+
+	code, _, _ = runScannerInput("not an image", "hash", "-strict", "-stdin-image")
+	assert.Equal(t, EXIT_ERROR, code)
+	code, _, _ = runScannerInput("", "hash", "-stdin-image", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_ERROR, code)
+}
+
+func TestEvalCommand(t *testing.T) {
+	samples := filepath.Join(t.TempDir(), "samples.csv")
+	code, stdout, _ := runScanner("eval", "-transforms", "original,flipx", "-thresholds", "0,31", "-output", "csv", "-samples", samples, BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_OK, code)
+	records, err := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, [][]string{
+		{"transform", "samples", "failed", "mean", "min", "p50", "p90", "max", "recall@0", "recall@31"},
+		{"original", "1", "0", "0.00", "0", "0", "0", "0", "1.000", "1.000"},
+	}, records[:2])
+	assert.Equal(t, "flipx", records[2][0])
+
+	b, err := os.ReadFile(samples)
+	assert.ErrorIs(t, err, nil)
+	assert.True(t, strings.HasPrefix(string(b), strings.Join(SAMPLES_CSV_HEADER, ",")+"\n"+BRIDGE_ORIGINAL+",original,0,"))
+
+	code, stdout, _ = runScanner("eval", "-transforms", "jpeg-q50", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_OK, code)
+	assert.True(t, strings.HasPrefix(stdout, "jpeg-q50 "))
+	assert.Contains(t, stdout, "recall@31=")
+
+	code, _, stderr := runScanner("eval", "-transforms", "sharpen-2", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_ERROR, code)
+	assert.Contains(t, stderr, "sharpen-2")
+	code, _, _ = runScanner("eval", "-thresholds", "a", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_ERROR, code)
+	// A missing original is counted as failed for every transform.
+	code, stdout, _ = runScanner("eval", "-transforms", "original,flipx", "-output", "csv", "../test-images/missing")
+	assert.Equal(t, EXIT_OK, code)
+	records, err = csv.NewReader(strings.NewReader(stdout)).ReadAll()
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, records, 3)
+	assert.Equal(t, []string{"original", "1", "1"}, records[1][:3])
+	assert.Equal(t, []string{"flipx", "1", "1"}, records[2][:3])
+}
+
+func TestCalibrateCommand(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile(BRIDGE_ORIGINAL)
+	assert.ErrorIs(t, err, nil)
+	assert.ErrorIs(t, os.WriteFile(filepath.Join(dir, "bridge.jpg"), data, 0o644), nil)
+	far := strings.Repeat("0", 64)
+	pairs := filepath.Join(dir, "pairs.csv")
+	assert.ErrorIs(t, os.WriteFile(pairs, []byte(strings.Join([]string{
+		"a,b,label",
+		"bridge.jpg," + BRIDGE_ORIGINAL_HASH + ",same",
+		BRIDGE_ORIGINAL_HASH + "," + BRIDGE_ORIGINAL_HASH + ",same",
+		"bridge.jpg," + far + ",different",
+	}, "\n")), 0o644), nil)
+	report := filepath.Join(dir, "report.html")
+	curves := filepath.Join(dir, "curves.csv")
+
+	code, stdout, _ := runScanner("calibrate", "-target-fpr", "0", "-html", report, "-csv", curves, pairs)
+	assert.Equal(t, EXIT_OK, code)
+	assert.Contains(t, stdout, "2 same and 1 different pairs, ROC AUC 1.0000\n")
+	assert.Contains(t, stdout, "true positive rate 1.0000, false positive rate 0.000000")
+	b, err := os.ReadFile(report)
+	assert.ErrorIs(t, err, nil)
+	assert.Contains(t, string(b), "<svg ")
+	b, err = os.ReadFile(curves)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, 258, strings.Count(string(b), "\n"))
+
+	code, _, stderr := runScanner("calibrate", filepath.Join(dir, "missing.csv"))
+	assert.Equal(t, EXIT_ERROR, code)
+	assert.Contains(t, stderr, "missing.csv")
+	code, _, _ = runScanner("calibrate")
+	assert.Equal(t, EXIT_ERROR, code)
+}
+
+func TestTrimBorders(t *testing.T) {
+	image, err := vips.NewImageFromFile(BRIDGE_ORIGINAL)
+	assert.ErrorIs(t, err, nil)
+	defer image.Close()
+	assert.ErrorIs(t, image.Embed(0, 148, 1600, 1300, vips.ExtendBlack), nil)
+	buf, _, err := image.ExportPng(vips.NewPngExportParams())
+	assert.ErrorIs(t, err, nil)
+	letterboxed := filepath.Join(t.TempDir(), "letterboxed.png")
+	assert.ErrorIs(t, os.WriteFile(letterboxed, buf, 0o644), nil)
+
+	code, stdout, _ := runScanner("hash", "-output", "ndjson", "-trim-borders", letterboxed)
+	assert.Equal(t, EXIT_OK, code)
+	var result fileResult
+	assert.ErrorIs(t, json.Unmarshal([]byte(stdout), &result), nil)
+	assert.True(t, strings.HasPrefix(result.Crop, "0,"), result.Crop)
+	assert.Equal(t, 1600, result.Width)
+	assert.Equal(t, 1300, result.Height)
+	original, _ := types.Hash256FromHexString(BRIDGE_ORIGINAL_HASH)
+	hash, err := types.Hash256FromHexString(result.Hash)
+	assert.ErrorIs(t, err, nil)
+	assert.LessOrEqual(t, hash.HammingDistance(original), 16)
+
+	code, stdout, _ = runScanner("hash", "-output", "ndjson", letterboxed)
+	assert.Equal(t, EXIT_OK, code)
+	result = fileResult{}
+	assert.ErrorIs(t, json.Unmarshal([]byte(stdout), &result), nil)
+	assert.Equal(t, "", result.Crop)
+
+	code, stdout, _ = runScanner("match", "-reference", BRIDGE_ORIGINAL, "-threshold", "16", "-trim-borders", letterboxed)
+	assert.Equal(t, EXIT_OK, code)
+	assert.Contains(t, stdout, letterboxed)
+}
+
+func TestBorderFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-max-border-fraction", "0.5"},
+		{"-max-border-fraction", "-0.1"},
+		{"-border-tolerance", "-1"},
+	} {
+		code, _, stderr := runScanner(append([]string{"hash", "-trim-borders"}, append(args, "missing.jpg")...)...)
+		assert.Equal(t, EXIT_ERROR, code, args)
+		assert.Contains(t, stderr, "invalid value", args)
+	}
+}
+
+func TestMatchRegions(t *testing.T) {
+	image, err := vips.NewImageFromFile(BRIDGE_ORIGINAL)
+	assert.ErrorIs(t, err, nil)
+	defer image.Close()
+	assert.ErrorIs(t, image.Embed(0, 0, 3200, 2008, vips.ExtendBlack), nil)
+	buf, _, err := image.ExportPng(vips.NewPngExportParams())
+	assert.ErrorIs(t, err, nil)
+	canvas := filepath.Join(t.TempDir(), "canvas.png")
+	assert.ErrorIs(t, os.WriteFile(canvas, buf, 0o644), nil)
+
+	code, stdout, _ := runScanner("match", "-reference", BRIDGE_ORIGINAL, "-threshold", "16", "-tiles", "2x2", "-output", "csv", canvas)
+	assert.Equal(t, EXIT_OK, code)
+	records, err := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, records, 2)
+	assert.Equal(t, canvas, records[1][0])
+	assert.Equal(t, "0,0,1600,1004", records[1][8])
+
+	code, _, _ = runScanner("match", "-reference", BRIDGE_ORIGINAL, "-windows", "0.5,0.25", canvas)
+	assert.Equal(t, EXIT_OK, code)
+
+	code, _, _ = runScanner("match", "-reference", BRIDGE_ORIGINAL, "-tiles", "2x2", "-dihedral", canvas)
+	assert.Equal(t, EXIT_ERROR, code)
+}
+
+func TestParseRegions(t *testing.T) {
+	layout, err := parseRegions("", "")
+	assert.ErrorIs(t, err, nil)
+	assert.Nil(t, layout)
+
+	layout, err = parseRegions("2x3", "1,1")
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, layout(30, 20), 7)
+
+	for _, tiles := range []string{"2", "0x2", "ax2"} {
+		_, err = parseRegions(tiles, "")
+		assert.NotNil(t, err, tiles)
+	}
+	for _, windows := range []string{"0.5", "0,0.5", "1.5,0.5", "0.5,0"} {
+		_, err = parseRegions("", windows)
+		assert.NotNil(t, err, windows)
+	}
+}
+
+func TestAlphaFlag(t *testing.T) {
+	straight := "../test-images/alpha/sticker-straight.png"
+	black := "../test-images/alpha/sticker-black.png"
+	hashes := func(args ...string) []string {
+		code, stdout, _ := runScanner(append([]string{"hash", "-output", "csv"}, args...)...)
+		assert.Equal(t, EXIT_OK, code)
+		records, err := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+		assert.ErrorIs(t, err, nil)
+		assert.Len(t, records, 3)
+		return []string{records[1][0], records[2][0]}
+	}
+
+	ignored := hashes(straight, black)
+	assert.NotEqual(t, ignored[0], ignored[1])
+	for _, alpha := range []string{"white", "black", "#336699"} {
+		composited := hashes("-alpha", alpha, straight, black)
+		assert.Equal(t, composited[0], composited[1], alpha)
+	}
+
+	code, _, _ := runScanner("hash", "-alpha", "grey", straight)
+	assert.Equal(t, EXIT_ERROR, code)
+}
+
+func TestOrientationFlag(t *testing.T) {
+	oriented := "../test-images/orientation/bridge-orientation-6.jpg"
+	upright := "../test-images/color/bridge-rgb.png"
+	hash := func(args ...string) []string {
+		code, stdout, _ := runScanner(append([]string{"hash", "-output", "csv"}, args...)...)
+		assert.Equal(t, EXIT_OK, code)
+		records, err := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+		assert.ErrorIs(t, err, nil)
+		assert.Len(t, records, 2)
+		return records[1]
+	}
+
+	ignored := hash(oriented)
+	assert.Equal(t, "6", ignored[9])
+	assert.Equal(t, "", ignored[10])
+	cache := filepath.Join(t.TempDir(), "cache.ndjson")
+	assert.Equal(t, ignored, hash("-cache", cache, oriented))
+	assert.Equal(t, ignored, hash("-cache", cache, oriented))
+	both := hash("-orientation", "both", oriented)
+	assert.Equal(t, ignored[0], both[0])
+	assert.NotEqual(t, both[0], both[10])
+	applied := hash("-orientation", "apply", oriented)
+	assert.Equal(t, both[10], applied[0])
+	assert.Equal(t, []string{"320", "201"}, applied[4:6])
+
+	code, stdout, _ := runScanner("match", "-reference", upright, "-orientation", "both", "-output", "csv", oriented)
+	assert.Equal(t, EXIT_OK, code)
+	records, err := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, records, 2)
+	assert.Equal(t, TRANSFORM_DISPLAYED, records[1][7])
+
+	code, _, _ = runScanner("hash", "-orientation", "upright", oriented)
+	assert.Equal(t, EXIT_ERROR, code)
+}
+
+func TestPrecisionFlag(t *testing.T) {
+	code, stdout, _ := runScanner("hash", "-precision", "float32", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_OK, code)
+	assert.True(t, strings.HasPrefix(stdout, BRIDGE_ORIGINAL_HASH+","))
+
+	for _, args := range [][]string{
+		{"hash", BRIDGE_ORIGINAL},
+		{"match", "-reference", BRIDGE_ORIGINAL, BRIDGE_ORIGINAL},
+		{"watch", "."},
+		{"index", "build", BRIDGE_ORIGINAL},
+	} {
+		code, _, stderr := runScanner(append(args[:len(args)-1:len(args)-1], "-precision", "float16", args[len(args)-1])...)
+		assert.Equal(t, EXIT_ERROR, code, args)
+		assert.Contains(t, stderr, "unknown precision", args)
+	}
+
+	code, stdout, _ = runScanner("index", "build", "-precision", "float32", BRIDGE_ORIGINAL)
+	assert.Equal(t, EXIT_OK, code)
+	assert.Contains(t, stdout, BRIDGE_ORIGINAL_HASH)
 }
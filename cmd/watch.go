@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/MTRNord/pdqhash-go/archive"
+	"github.com/MTRNord/pdqhash-go/hashlist"
+	"github.com/MTRNord/pdqhash-go/index"
+	"github.com/fsnotify/fsnotify"
+)
+
+// How long a file must go without being written to before it is hashed.
+const DEFAULT_SETTLE = 2 * time.Second
+
+func (c *cli) runWatch(args []string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return c.watch(ctx, args)
+}
+
+// Runs the watch command until ctx is cancelled.
+func (c *cli) watch(ctx context.Context, args []string) (code int) {
+	fs := c.flagSet("watch", "[flags] DIR...")
+	detailed := fs.Bool("detailed", false, "Detailed output")
+	output := fs.String("output", OUTPUT_PLAIN, fmt.Sprintf("Output format, one of %v", OUTPUT_FORMATS))
+	outPath := fs.String("o", "", "Append results to this file instead of writing them to stdout")
+	settle := fs.Duration("settle", DEFAULT_SETTLE, "How long a file must stay unchanged before it is hashed")
+	existing := fs.Bool("existing", false, "Also hash the files already in the watched folders")
+	workers := fs.Int("workers", 1, "Number of files hashed in parallel")
+	archives := fs.Bool("archives", true, "Hash the images inside zip, tar and tar.gz files")
+	minQuality := fs.Int("min-quality", pdq.DEFAULT_MIN_QUALITY, "Quality below which -quality-policy applies")
+	qualityPolicy := fs.String("quality-policy", pdq.QUALITY_POLICY_KEEP, fmt.Sprintf("What to do with low quality hashes, one of %v", pdq.QUALITY_POLICIES))
+	borderTrim := borderFlags(fs)
+	alpha := alphaFlag(fs)
+	orientation := orientationFlag(fs)
+	precision := precisionFlag(fs)
+	list := fs.String("hashlist", "", "Hash list (CSV or TSV) to match new files against")
+	threshold := fs.Int("threshold", index.DEFAULT_MATCH_THRESHOLD, "Largest Hamming distance counted as a match")
+	matchPath := fs.String("matches", "", "Append matches to this file instead of writing them to stderr")
+	if code, ok := c.parse(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return EXIT_ERROR
+	}
+	if !slices.Contains(pdq.QUALITY_POLICIES, *qualityPolicy) {
+		return c.fail(fmt.Errorf("unknown quality policy %q, expected one of %v", *qualityPolicy, pdq.QUALITY_POLICIES))
+	}
+
+	stdout, closeOut, err := appendSink(*outPath, c.stdout)
+	if err != nil {
+		return c.fail(err)
+	}
+	defer closeOut()
+	out, err := newResultWriter(*output, CSV_HEADER, *detailed, stdout, c.stderr)
+	if err != nil {
+		return c.fail(err)
+	}
+	sink := writeTo(out)
+
+	if *list != "" {
+		entries, err := hashlist.ReadFile(*list)
+		if err != nil {
+			return c.fail(err)
+		}
+		idx := index.FromEntries(entries)
+		fmt.Fprintf(c.stderr, "loaded %d hashes from %s\n", idx.Len(), *list)
+
+		matchOut, closeMatches, err := appendSink(*matchPath, c.stderr)
+		if err != nil {
+			return c.fail(err)
+		}
+		defer closeMatches()
+		matches, err := newResultWriter(*output, MATCH_CSV_HEADER, false, matchOut, c.stderr)
+		if err != nil {
+			return c.fail(err)
+		}
+		defer func() {
+			if err := matches.Close(); err != nil {
+				code = c.fail(err)
+			}
+		}()
+		sink = func(result *fileResult) error {
+			if err := out.Write(result); err != nil {
+				return err
+			}
+			if result.hash == nil {
+				return nil
+			}
+			for _, match := range matchResults(idx, result, *threshold) {
+				if err := matches.Write(match); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return c.fail(err)
+	}
+	defer watcher.Close()
+	for _, dir := range fs.Args() {
+		if err := addTree(watcher, dir); err != nil {
+			return c.fail(err)
+		}
+	}
+
+	opts := scanOptions{
+		Workers:       *workers,
+		QualityPolicy: *qualityPolicy,
+		MinQuality:    *minQuality,
+		BorderTrim:    borderTrim(),
+		AlphaPolicy:   alpha.policy,
+		AlphaColor:    alpha.color,
+		Orientation:   string(*orientation),
+		Precision:     string(*precision),
+		Archives:      *archives,
+		ArchiveLimits: archive.DefaultLimits(),
+	}
+	code = EXIT_OK
+	summary, err := scanFiles(sink, opts, func(q *scanQueue) error {
+		if *existing {
+			for _, dir := range fs.Args() {
+				err := walkFiles(dir, func(path string) error {
+					if !q.submitFile(path, false) {
+						return errScanStopped
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return c.watchEvents(ctx, watcher, *settle, q)
+	})
+	if err != nil {
+		code = c.fail(err)
+	}
+	fmt.Fprintf(c.stderr, "%s\n", summary)
+	if err := out.Close(); err != nil {
+		return c.fail(err)
+	}
+	return code
+}
+
+// Opens path for appending, or returns w when path is empty.
+func appendSink(path string, w io.Writer) (io.Writer, func() error, error) {
+	if path == "" {
+		return w, func() error { return nil }, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
+
+// Watches dir and every folder below it; fsnotify is not recursive.
+func addTree(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// Calls fn for every regular file below dir.
+func walkFiles(dir string, fn func(path string) error) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return fn(path)
+	})
+}
+
+/**
+ * Queues files as they settle, until ctx is cancelled. A file settles once
+ * it has gone the settle duration without being created or written to, so
+ * that files still being copied in are not hashed half written.
+ */
+func (c *cli) watchEvents(ctx context.Context, watcher *fsnotify.Watcher, settle time.Duration, q *scanQueue) error {
+	d := newDebouncer(settle)
+	defer d.stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			switch {
+			case event.Has(fsnotify.Create):
+				info, err := os.Stat(event.Name)
+				if err != nil {
+					// Already gone again.
+					continue
+				}
+				if !info.IsDir() {
+					d.touch(event.Name)
+					continue
+				}
+				// Files moved in along with a new folder raise no events
+				// of their own.
+				if err := addTree(watcher, event.Name); err != nil {
+					fmt.Fprintf(c.stderr, "%s: %v\n", event.Name, err)
+				}
+				walkFiles(event.Name, func(path string) error {
+					d.touch(path)
+					return nil
+				})
+			case event.Has(fsnotify.Write):
+				d.touch(event.Name)
+			case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+				d.cancel(event.Name)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				fmt.Fprintf(c.stderr, "watch: %v, some files may not be hashed\n", err)
+				continue
+			}
+			return err
+		case path := <-d.ready:
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if !q.submitFile(path, false) {
+				return errScanStopped
+			}
+		}
+	}
+}
+
+// Delays each path until it has not been touched for the settle duration,
+// then sends it on ready.
+type debouncer struct {
+	settle time.Duration
+	ready  chan string
+	done   chan struct{}
+
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func newDebouncer(settle time.Duration) *debouncer {
+	return &debouncer{
+		settle: settle,
+		ready:  make(chan string),
+		done:   make(chan struct{}),
+		timers: map[string]*time.Timer{},
+	}
+}
+
+func (d *debouncer) touch(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t, ok := d.timers[path]; ok && t.Stop() {
+		t.Reset(d.settle)
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(d.settle, func() {
+		d.mu.Lock()
+		if d.timers[path] == t {
+			delete(d.timers, path)
+		}
+		d.mu.Unlock()
+		select {
+		case d.ready <- path:
+		case <-d.done:
+		}
+	})
+	d.timers[path] = t
+}
+
+func (d *debouncer) cancel(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t, ok := d.timers[path]; ok {
+		t.Stop()
+		delete(d.timers, path)
+	}
+}
+
+func (d *debouncer) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for path, t := range d.timers {
+		t.Stop()
+		delete(d.timers, path)
+	}
+	close(d.done)
+}
@@ -0,0 +1,91 @@
+package pdq
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+/**
+ * Brings a decoded image to bands that the luma can be read from, by its
+ * interpretation:
+ *
+ *   - B_W and GREY16 have a single luma band, which is read as it is.
+ *   - RGB16 is read as 16-bit sRGB and scaled to 0..255.
+ *   - CMYK is converted to sRGB through its embedded ICC profile, or
+ *     through the default CMYK profile of vips if it has none.
+ *   - sRGB is read as it is.
+ *   - Anything else with at least three colour bands, such as the linear
+ *     scRGB of float TIFFs and Radiance HDR, is converted to sRGB by vips.
+ *     With fewer, the first band is taken as luma.
+ *
+ * Alpha, if any, stays in the last band.
+ */
+func toLumaColorSpace(image *vips.ImageRef) error {
+	var err error
+	switch image.Interpretation() {
+	case vips.InterpretationBW, vips.InterpretationGrey16, vips.InterpretationRGB16, vips.InterpretationSRGB:
+		return nil
+	case vips.InterpretationCMYK:
+		if image.HasICCProfile() {
+			err = image.TransformICCProfile(vips.SRGBIEC6196621ICCProfilePath)
+		} else {
+			err = image.ToColorSpace(vips.InterpretationSRGB)
+		}
+	default:
+		if colorBands(image) < 3 {
+			return nil
+		}
+		err = image.ToColorSpace(vips.InterpretationSRGB)
+	}
+	if err != nil {
+		return fmt.Errorf("error converting to RGB: %w", err)
+	}
+	return nil
+}
+
+func colorBands(image *vips.ImageRef) int {
+	if image.HasAlpha() {
+		return image.Bands() - 1
+	}
+	return image.Bands()
+}
+
+/**
+ * Reads the pixels of the image in memory and returns a function giving
+ * its k-th sample, band by band within each pixel, scaled to 0..255: 8-bit
+ * samples as they are, 16-bit ones by 255/65535, and floats, which vips
+ * keeps in 0..255 outside of linear spaces, clamped. Other band formats
+ * are cast to float first.
+ */
+func readSamples(image *vips.ImageRef) (func(k int) float64, error) {
+	switch image.BandFormat() {
+	case vips.BandFormatUchar, vips.BandFormatUshort, vips.BandFormatFloat:
+	default:
+		if err := image.Cast(vips.BandFormatFloat); err != nil {
+			return nil, fmt.Errorf("error casting to float: %w", err)
+		}
+	}
+	buf, err := image.ToBytes()
+	if err != nil {
+		return nil, fmt.Errorf("error reading pixels: %w", err)
+	}
+
+	switch image.BandFormat() {
+	case vips.BandFormatUchar:
+		return func(k int) float64 {
+			return float64(buf[k])
+		}, nil
+	case vips.BandFormatUshort:
+		return func(k int) float64 {
+			return float64(binary.NativeEndian.Uint16(buf[2*k:])) * 255 / 65535
+		}, nil
+	default:
+		return func(k int) float64 {
+			v := float64(math.Float32frombits(binary.NativeEndian.Uint32(buf[4*k:])))
+			return min(max(v, 0), 255)
+		}, nil
+	}
+}
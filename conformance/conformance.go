@@ -0,0 +1,242 @@
+// Package conformance compares this port's hashes with those of the
+// reference PDQ implementation.
+//
+// A manifest lists images with their reference hashes, one per line in
+// the format of the upstream regression-test outputs:
+//
+//	hash=d8f8...9b22,norm=128,delta=0,quality=100,filename=reg-test-input/dih/bridge-1-original.jpg
+//
+// The manifest should be the output of upstream's pdq-photo-hasher over the
+// images, which records a quality for each. Only hash and filename are
+// required to read it, but the default limits fail images without a
+// quality. filename is relative to the folder of the manifest and runs to
+// the end of the line. Blank lines and lines starting with # are ignored.
+package conformance
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/MTRNord/pdqhash-go/types"
+)
+
+// QUALITY_UNKNOWN is stored in Reference.Quality when the manifest has no
+// quality for an image.
+const QUALITY_UNKNOWN = -1
+
+// One image of the manifest and its reference outputs.
+type Reference struct {
+	Path    string
+	Hash    *types.Hash256
+	Quality int
+}
+
+// How far the port may stray from the reference before Check fails.
+type Limits struct {
+	// Largest mean Hamming distance over all images.
+	MeanDistance float64
+	// Largest Hamming distance for any one image.
+	MaxDistance int
+	// Largest quality difference for any one image with a reference
+	// quality, or -1 to not compare qualities.
+	MaxQualityDelta int
+}
+
+/**
+ * Allows for differences between the JPEG decoders and resizers of libvips
+ * and the reference implementation, and nothing more: a few bits per hash,
+ * a dozen at most, and a small change in quality.
+ */
+func DefaultLimits() Limits {
+	return Limits{
+		MeanDistance:    4,
+		MaxDistance:     12,
+		MaxQualityDelta: 10,
+	}
+}
+
+// The outcome for one image. Err is set when it could not be hashed, in
+// which case Distance and Quality are meaningless.
+type Result struct {
+	Reference
+	Got          *types.Hash256
+	GotQuality   int
+	Distance     int
+	QualityDelta int
+	Err          error
+}
+
+type Report struct {
+	Results      []Result
+	MeanDistance float64
+	MaxDistance  int
+	// Largest quality difference over the images with a reference
+	// quality.
+	MaxQualityDelta int
+	// Images that could not be hashed.
+	Failed int
+	// Images without a reference quality.
+	MissingQuality int
+}
+
+func ReadManifestFile(path string) ([]Reference, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	refs, err := ReadManifest(f, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return refs, nil
+}
+
+// Reads a manifest, resolving filenames relative to dir.
+func ReadManifest(r io.Reader, dir string) ([]Reference, error) {
+	var refs []Reference
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		ref, err := parseLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		ref.Path = filepath.Join(dir, filepath.FromSlash(ref.Path))
+		refs = append(refs, ref)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+func parseLine(text string) (Reference, error) {
+	ref := Reference{Quality: QUALITY_UNKNOWN}
+	for text != "" {
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return ref, fmt.Errorf("expected key=value, got %q", text)
+		}
+		if key == "filename" {
+			// May contain commas.
+			ref.Path = value
+			break
+		}
+		value, text, _ = strings.Cut(value, ",")
+
+		var err error
+		switch key {
+		case "hash":
+			ref.Hash, err = types.Hash256FromHexString(value)
+		case "quality":
+			ref.Quality, err = strconv.Atoi(value)
+		}
+		// Other keys such as norm and delta follow from the hash.
+		if err != nil {
+			return ref, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	if ref.Hash == nil {
+		return ref, errors.New("missing hash")
+	}
+	if ref.Path == "" {
+		return ref, errors.New("missing filename")
+	}
+	return ref, nil
+}
+
+// Hashes every image of the manifest and compares it to its reference.
+func Run(hasher *pdq.PDQHasher, refs []Reference) *Report {
+	report := &Report{Results: make([]Result, 0, len(refs))}
+	total := 0
+	for _, ref := range refs {
+		result := Result{Reference: ref}
+		buf, err := os.ReadFile(ref.Path)
+		if err == nil {
+			var hash pdq.HashAndQuality
+			hash, err = hasher.FromBuffer(buf)
+			if err == nil {
+				result.Got = hash.Hash
+				result.GotQuality = hash.Quality
+			}
+		}
+		if err != nil {
+			result.Err = err
+			report.Failed++
+			report.Results = append(report.Results, result)
+			continue
+		}
+
+		result.Distance = result.Got.HammingDistance(ref.Hash)
+		total += result.Distance
+		report.MaxDistance = max(report.MaxDistance, result.Distance)
+		if ref.Quality != QUALITY_UNKNOWN {
+			result.QualityDelta = result.GotQuality - ref.Quality
+			if result.QualityDelta < 0 {
+				result.QualityDelta = -result.QualityDelta
+			}
+			report.MaxQualityDelta = max(report.MaxQualityDelta, result.QualityDelta)
+		} else {
+			report.MissingQuality++
+		}
+		report.Results = append(report.Results, result)
+	}
+	if hashed := len(refs) - report.Failed; hashed > 0 {
+		report.MeanDistance = float64(total) / float64(hashed)
+	}
+	return report
+}
+
+// Returns an error listing every limit the report exceeds, or nil.
+func (r *Report) Check(limits Limits) error {
+	var errs []error
+	if r.Failed > 0 {
+		errs = append(errs, fmt.Errorf("%d images could not be hashed", r.Failed))
+	}
+	if r.MeanDistance > limits.MeanDistance {
+		errs = append(errs, fmt.Errorf("mean distance %.2f exceeds %.2f", r.MeanDistance, limits.MeanDistance))
+	}
+	if r.MaxDistance > limits.MaxDistance {
+		errs = append(errs, fmt.Errorf("max distance %d exceeds %d", r.MaxDistance, limits.MaxDistance))
+	}
+	if limits.MaxQualityDelta >= 0 && r.MaxQualityDelta > limits.MaxQualityDelta {
+		errs = append(errs, fmt.Errorf("max quality difference %d exceeds %d", r.MaxQualityDelta, limits.MaxQualityDelta))
+	}
+	if limits.MaxQualityDelta >= 0 && r.MissingQuality > 0 {
+		errs = append(errs, fmt.Errorf("%d images have no reference quality", r.MissingQuality))
+	}
+	return errors.Join(errs...)
+}
+
+// Writes one line per image followed by the totals.
+func (r *Report) Write(w io.Writer) error {
+	for _, result := range r.Results {
+		var err error
+		switch {
+		case result.Err != nil:
+			_, err = fmt.Fprintf(w, "FAIL   %s: %v\n", result.Path, result.Err)
+		case result.Quality != QUALITY_UNKNOWN:
+			_, err = fmt.Fprintf(w, "%3d    %s quality=%d want=%d\n", result.Distance, result.Path, result.GotQuality, result.Quality)
+		default:
+			_, err = fmt.Fprintf(w, "%3d    %s\n", result.Distance, result.Path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d images, %d failed, mean distance %.2f, max distance %d, max quality difference %d\n", len(r.Results), r.Failed, r.MeanDistance, r.MaxDistance, r.MaxQualityDelta)
+	return err
+}
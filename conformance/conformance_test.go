@@ -0,0 +1,114 @@
+package conformance
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/davidbyttow/govips/v2/vips"
+	"github.com/stretchr/testify/assert"
+)
+
+const BRIDGE_HASH = "d8f8f0cce0f4a84f0e370a22028f67f0b36e2ed596623e1d33e6b39c4e9c9b22"
+
+// Where the output of upstream's pdq-photo-hasher over
+// test-images/reg-test-input belongs, with filenames such as
+// reg-test-input/dih/bridge-1-original.jpg.
+const UPSTREAM_MANIFEST = "../test-images/upstream-manifest.txt"
+
+var manifest = flag.String("manifest", UPSTREAM_MANIFEST, "Output of upstream's pdq-photo-hasher to check against")
+var meanDistance = flag.Float64("max-mean-distance", DefaultLimits().MeanDistance, "Largest allowed mean Hamming distance")
+var maxDistance = flag.Int("max-distance", DefaultLimits().MaxDistance, "Largest allowed Hamming distance for one image")
+var maxQualityDelta = flag.Int("max-quality-delta", DefaultLimits().MaxQualityDelta, "Largest allowed quality difference, -1 to not compare")
+
+func TestMain(m *testing.M) {
+	vips.LoggingSettings(nil, vips.LogLevelMessage)
+	vips.Startup(&vips.Config{
+		ConcurrencyLevel: 0,
+		MaxCacheFiles:    5,
+		MaxCacheMem:      50 * 1024 * 1024,
+		MaxCacheSize:     100,
+		ReportLeaks:      false,
+		CacheTrace:       false,
+		CollectStats:     false,
+	})
+	defer vips.Shutdown()
+
+	os.Exit(m.Run())
+}
+
+/**
+ * Checks the hashes against UPSTREAM_MANIFEST, or another -manifest with
+ * filenames relative to it. Only upstream's own output is a reference:
+ * the hashes of this port would only check it against itself, so the test
+ * is skipped until that file is added.
+ */
+func TestConformance(t *testing.T) {
+	if _, err := os.Stat(*manifest); *manifest == UPSTREAM_MANIFEST && errors.Is(err, os.ErrNotExist) {
+		t.Skipf("no upstream reference outputs at %s", UPSTREAM_MANIFEST)
+	}
+	refs, err := ReadManifestFile(*manifest)
+	assert.ErrorIs(t, err, nil)
+	assert.NotEmpty(t, refs)
+
+	report := Run(pdq.NewPDQHasher(), refs)
+	var out bytes.Buffer
+	assert.ErrorIs(t, report.Write(&out), nil)
+	t.Logf("\n%s", out.String())
+
+	limits := Limits{MeanDistance: *meanDistance, MaxDistance: *maxDistance, MaxQualityDelta: *maxQualityDelta}
+	assert.ErrorIs(t, report.Check(limits), nil)
+}
+
+func TestReadManifest(t *testing.T) {
+	input := "# comment\n\n" +
+		"hash=" + BRIDGE_HASH + ",norm=128,delta=0,quality=100,filename=reg-test-input/dih/bridge-1-original.jpg\n" +
+		"  hash=" + BRIDGE_HASH + ",filename=a,b.jpg  \n"
+	refs, err := ReadManifest(strings.NewReader(input), "images")
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, refs, 2)
+	assert.Equal(t, filepath.Join("images", "reg-test-input", "dih", "bridge-1-original.jpg"), refs[0].Path)
+	assert.Equal(t, BRIDGE_HASH, refs[0].Hash.String())
+	assert.Equal(t, 100, refs[0].Quality)
+	assert.Equal(t, filepath.Join("images", "a,b.jpg"), refs[1].Path)
+	assert.Equal(t, QUALITY_UNKNOWN, refs[1].Quality)
+
+	for _, line := range []string{
+		"filename=a.jpg",
+		"hash=" + BRIDGE_HASH,
+		"hash=nothex,filename=a.jpg",
+		"hash=" + BRIDGE_HASH + ",quality=high,filename=a.jpg",
+		"just a path",
+	} {
+		_, err := ReadManifest(strings.NewReader("# header\n"+line+"\n"), ".")
+		assert.ErrorContainsf(t, err, "line 2", "%s", line)
+	}
+
+	_, err = ReadManifestFile("missing.txt")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCheck(t *testing.T) {
+	report := &Report{MeanDistance: 2.5, MaxDistance: 10, MaxQualityDelta: 20}
+	assert.ErrorContains(t, report.Check(DefaultLimits()), "max quality difference 20 exceeds 10")
+
+	err := report.Check(Limits{MeanDistance: 2, MaxDistance: 9, MaxQualityDelta: 19})
+	assert.ErrorContains(t, err, "mean distance 2.50 exceeds 2.00")
+	assert.ErrorContains(t, err, "max distance 10 exceeds 9")
+	assert.ErrorContains(t, err, "max quality difference 20 exceeds 19")
+
+	report = &Report{MeanDistance: 2.5, MaxDistance: 10, MaxQualityDelta: 5}
+	assert.ErrorIs(t, report.Check(DefaultLimits()), nil)
+
+	report = &Report{Failed: 1}
+	assert.ErrorContains(t, report.Check(DefaultLimits()), "1 images could not be hashed")
+
+	report = &Report{MissingQuality: 2}
+	assert.ErrorContains(t, report.Check(DefaultLimits()), "2 images have no reference quality")
+	assert.ErrorIs(t, report.Check(Limits{MeanDistance: 4, MaxDistance: 12, MaxQualityDelta: -1}), nil)
+}
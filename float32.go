@@ -0,0 +1,201 @@
+package pdq
+
+import (
+	"github.com/chewxy/math32"
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// Precision of the hashing pipeline, from the luma through the Jarosz
+// filter to the DCT. Float32 halves the memory of the full-size luma
+// buffers and the bandwidth the filter needs. Its hashes can differ from
+// the float64 ones in bits whose DCT coefficient lies within rounding of
+// the median. Over the test images, hashed with FromGoImage, no bit
+// differs (TestFloat32Drift); through vips TestPrecision allows 4.
+const PRECISION_FLOAT64 = "float64"
+const PRECISION_FLOAT32 = "float32"
+
+var PRECISIONS = []string{PRECISION_FLOAT64, PRECISION_FLOAT32}
+
+// The types the luma can be held in.
+type lumaFloat interface {
+	~float32 | ~float64
+}
+
+func computeDCTMatrix32() [][]float32 {
+	scale := math32.Sqrt(2.0 / 64.0)
+	d := make([][]float32, 16)
+	for i := 0; i < len(d); i++ {
+		di := make([]float32, 64)
+		for j := 0; j < len(di); j++ {
+			di[j] = scale * math32.Cos((math32.Pi/2.0/64.0)*(float32(i)+1.0)*(2.0*float32(j)+1.0))
+		}
+		d[i] = di
+	}
+
+	return d
+}
+
+func (p *PDQHasher) fillLuma32(t *hashTrace, image *vips.ImageRef, luma []float32) error {
+	s := t.startStage(STAGE_LUMA)
+
+	err := fillLumaFromImage(p, image, luma)
+	if err != nil {
+		return t.fail(s, FAILURE_PROCESSING, err)
+	}
+	t.endStage(s)
+	return nil
+}
+
+// The float32 counterpart of the part of fromLoadedImage after the
+// thumbnail.
+func (p *PDQHasher) fromThumbnail32(t *hashTrace, image *vips.ImageRef, width, height, orientation int) (HashAndQuality, error) {
+	numCols := image.Width()
+	numRows := image.Height()
+
+	buffer1 := make([]float32, numCols*numRows)
+	buffer2 := make([]float32, numCols*numRows)
+
+	err := p.fillLuma32(t, image, buffer1)
+	if err != nil {
+		return HashAndQuality{}, err
+	}
+
+	var displayed []float32
+	var displayedRows, displayedCols int
+	if orients(orientation) {
+		switch p.Orientation {
+		case ORIENTATION_APPLY:
+			numRows, numCols = orientLuma(buffer1, buffer2, numRows, numCols, orientation)
+			buffer1, buffer2 = buffer2, buffer1
+			width, height = orientedSize(width, height, orientation)
+		case ORIENTATION_BOTH:
+			displayed = make([]float32, numCols*numRows)
+			displayedRows, displayedCols = orientLuma(buffer1, displayed, numRows, numCols, orientation)
+		}
+	}
+
+	hashAndQuality, err := p.pdqHash256FromFloat32Luma(t, buffer1, buffer2, numRows, numCols, width, height)
+	if err != nil {
+		return HashAndQuality{}, err
+	}
+	hashAndQuality.Width = width
+	hashAndQuality.Height = height
+	hashAndQuality.Crop = hashAndQuality.Crop.scale(numCols, numRows, width, height)
+	hashAndQuality.Orientation = orientation
+
+	if p.Orientation == ORIENTATION_BOTH {
+		hashAndQuality.DisplayedHash = hashAndQuality.Hash
+		if displayed != nil {
+			displayedWidth, displayedHeight := orientedSize(width, height, orientation)
+			displayedHash, err := p.pdqHash256FromFloat32Luma(t, displayed, buffer2, displayedRows, displayedCols, displayedWidth, displayedHeight)
+			if err != nil {
+				return HashAndQuality{}, err
+			}
+			hashAndQuality.DisplayedHash = displayedHash.Hash
+		}
+	}
+	return hashAndQuality, nil
+}
+
+// The float32 counterpart of dihedralFromLoadedImage.
+func (p *PDQHasher) dihedralFromLoadedImage32(t *hashTrace, image *vips.ImageRef, width, height, orientation, dihedralFlags int) (HashesAndQuality, error) {
+	numRows := image.Height()
+	numCols := image.Width()
+
+	buffer1 := make([]float32, numCols*numRows)
+	buffer2 := make([]float32, numCols*numRows)
+
+	err := p.fillLuma32(t, image, buffer1)
+	if err != nil {
+		return HashesAndQuality{}, err
+	}
+	if p.Orientation == ORIENTATION_APPLY && orients(orientation) {
+		numRows, numCols = orientLuma(buffer1, buffer2, numRows, numCols, orientation)
+		buffer1, buffer2 = buffer2, buffer1
+		width, height = orientedSize(width, height, orientation)
+	}
+
+	buffer64x64 := make([]float32, 64*64)
+	quality, lowQuality, crop, err := p.filterAndDecimate32(t, buffer1, buffer2, numRows, numCols, width, height, buffer64x64)
+	if err != nil {
+		return HashesAndQuality{}, err
+	}
+
+	s := t.startStage(STAGE_DCT)
+	buffer16x16 := make([]float64, 16*16)
+	p.dct64To16Float32(buffer64x64, make([]float32, 16*64), make([]float32, 16*16), buffer16x16)
+	hashesAndQuality := p.dihedralHashes(buffer16x16, make([]float64, 16*16), dihedralFlags)
+	t.endStage(s)
+
+	hashesAndQuality.Quality = quality
+	hashesAndQuality.LowQuality = lowQuality
+	hashesAndQuality.Crop = crop
+	hashesAndQuality.Orientation = orientation
+	return hashesAndQuality, nil
+}
+
+func (p *PDQHasher) pdqHash256FromFloat32Luma(t *hashTrace, fullBuffer1, fullBuffer2 []float32, numRows, numCols, width, height int) (HashAndQuality, error) {
+	buffer64x64 := make([]float32, 64*64)
+	quality, lowQuality, crop, err := p.filterAndDecimate32(t, fullBuffer1, fullBuffer2, numRows, numCols, width, height, buffer64x64)
+	if err != nil {
+		return HashAndQuality{}, err
+	}
+
+	s := t.startStage(STAGE_DCT)
+	buffer16x16 := make([]float64, 16*16)
+	p.dct64To16Float32(buffer64x64, make([]float32, 16*64), make([]float32, 16*16), buffer16x16)
+	hash := p.pdqBuffer16x16ToBits(buffer16x16)
+	t.endStage(s)
+
+	return HashAndQuality{Hash: hash, Quality: quality, LowQuality: lowQuality, Crop: crop}, nil
+}
+
+/**
+ * The float32 counterpart of filterAndDecimate. The quality metric and the
+ * low quality reasons are computed by the float64 code from the 64x64 grid
+ * widened to float64; the widening loses nothing, but the grid itself
+ * comes from the float32 filter, so the quality can differ too.
+ */
+func (p *PDQHasher) filterAndDecimate32(t *hashTrace, fullBuffer1, fullBuffer2 []float32, numRows, numCols, width, height int, buffer64x64 []float32) (int, string, *CropBox, error) {
+	s := t.startStage(STAGE_FILTER)
+	lumaRows, lumaCols := numRows, numCols
+	numRows, numCols, crop, err := trimLuma(p.BorderTrim, fullBuffer1, numRows, numCols)
+	if err != nil {
+		return 0, "", nil, t.fail(s, FAILURE_PROCESSING, err)
+	}
+	windowSizeAlongRows := p.computeJaroszWindowSize(numCols)
+	windowSizeAlongCols := p.computeJaroszWindowSize(numRows)
+	jaroszFilterFloat(fullBuffer1, fullBuffer2, numRows, numCols, windowSizeAlongRows, windowSizeAlongCols, PDQ_NUM_JAROSZ_XY_PASSES)
+
+	decimateFloat(fullBuffer1, numRows, numCols, buffer64x64)
+	widened := make([]float64, 64*64)
+	for i, v := range buffer64x64 {
+		widened[i] = float64(v)
+	}
+	quality := p.computePDQImageDomainQualityMetric(widened)
+
+	lowQuality := p.lowQuality(quality, numRows*height/lumaRows, numCols*width/lumaCols, widened)
+	if lowQuality != "" && p.QualityPolicy == QUALITY_POLICY_REJECT {
+		return quality, lowQuality, crop, t.fail(s, FAILURE_LOW_QUALITY, &LowQualityError{quality, p.MinQuality, lowQuality})
+	}
+	t.endStage(s)
+	return quality, lowQuality, crop, nil
+}
+
+// As dct64To16, in float32, into a float64 B.
+func (p *PDQHasher) dct64To16Float32(A, T, B32 []float32, B []float64) {
+	multiplyMatrices(p.dct32, A, T, 16, 64, 64)
+	multiplyMatrices(T, p.dct32Transposed, B32, 16, 64, 16)
+	for i, v := range B32 {
+		B[i] = float64(v)
+	}
+}
+
+// Narrows a float64 luma buffer, for the paths that compute it in float64.
+func narrowLuma(luma []float64) []float32 {
+	out := make([]float32, len(luma))
+	for i, v := range luma {
+		out[i] = float32(v)
+	}
+	return out
+}
@@ -0,0 +1,128 @@
+package pdq
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+)
+
+/**
+ * Hashes an image decoded by the standard library, without vips. The
+ * image is shrunk to THUMBNAIL_SIZE by averaging rather than by the
+ * Lanczos filter of vips, so hashes are close to, but not always equal
+ * to, those of the other functions.
+ */
+func (p *PDQHasher) FromGoImage(img image.Image) (HashAndQuality, error) {
+	return p.FromGoImageContext(context.Background(), img)
+}
+
+func (p *PDQHasher) FromGoImageContext(ctx context.Context, img image.Image) (HashAndQuality, error) {
+	t := p.startHash(ctx)
+	width := img.Bounds().Dx()
+	height := img.Bounds().Dy()
+
+	s := t.startStage(STAGE_LUMA)
+	if width == 0 || height == 0 {
+		return HashAndQuality{}, t.fail(s, FAILURE_EMPTY, errors.New("empty image"))
+	}
+	luma := p.lumaFromGoImage(img)
+	t.endStage(s)
+
+	s = t.startStage(STAGE_THUMBNAIL)
+	buffer1, numRows, numCols := shrinkLuma(luma, height, width, THUMBNAIL_SIZE)
+	t.endStage(s)
+
+	var hashAndQuality HashAndQuality
+	var err error
+	if p.Precision == PRECISION_FLOAT32 {
+		hashAndQuality, err = p.pdqHash256FromFloat32Luma(t, narrowLuma(buffer1), make([]float32, numCols*numRows), numRows, numCols, width, height)
+	} else {
+		buffer2 := make([]float64, numCols*numRows)
+		buffer64x64 := make([]float64, 64*64)
+		buffer16x64 := make([]float64, 16*64)
+		buffer16x16 := make([]float64, 16*16)
+		hashAndQuality, err = p.pdqHash256FromFloatLuma(t, buffer1, buffer2, numRows, numCols, width, height, buffer64x64, buffer16x64, buffer16x16)
+	}
+	if err != nil {
+		return HashAndQuality{}, err
+	}
+	hashAndQuality.Width = width
+	hashAndQuality.Height = height
+	hashAndQuality.Crop = hashAndQuality.Crop.scale(numCols, numRows, width, height)
+	t.end(hashAndQuality.Quality)
+	return hashAndQuality, nil
+}
+
+/**
+ * Go colours report premultiplied alpha whatever the image type, so both
+ * straight (NRGBA) and premultiplied (RGBA) images are unpremultiplied
+ * here before compositing, as vips does.
+ */
+func (p *PDQHasher) lumaFromGoImage(img image.Image) []float64 {
+	bounds := img.Bounds()
+	numCols := bounds.Dx()
+	luma := make([]float64, numCols*bounds.Dy())
+	background, composite := p.alphaBackground()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := (y-bounds.Min.Y)*numCols + x - bounds.Min.X
+			c := img.At(x, y)
+			if !composite {
+				luma[i] = straightLuma(c)
+				continue
+			}
+			r, g, b, a := c.RGBA()
+			if a == 0 {
+				luma[i] = compositeLuma(0, 0, 0, 0, background)
+				continue
+			}
+			unpremultiply := 255 / float64(a)
+			luma[i] = compositeLuma(float64(r)*unpremultiply, float64(g)*unpremultiply, float64(b)*unpremultiply, float64(a)/257, background)
+		}
+	}
+	return luma
+}
+
+// Ignores alpha. Straight colours are read as they are, since converting
+// them would premultiply and lose what is behind transparent pixels.
+func straightLuma(c color.Color) float64 {
+	var n color.NRGBA64
+	switch c := c.(type) {
+	case color.NRGBA:
+		n = color.NRGBA64{uint16(c.R) * 257, uint16(c.G) * 257, uint16(c.B) * 257, uint16(c.A) * 257}
+	case color.NRGBA64:
+		n = c
+	default:
+		n = color.NRGBA64Model.Convert(c).(color.NRGBA64)
+	}
+	return (LUMA_FROM_R_COEFF*float64(n.R) + LUMA_FROM_G_COEFF*float64(n.G) + LUMA_FROM_B_COEFF*float64(n.B)) / 257
+}
+
+// Shrinks a numRows x numCols luma buffer so that its longer side is at
+// most size, averaging the pixels that fall into each output pixel.
+func shrinkLuma(luma []float64, numRows, numCols, size int) ([]float64, int, int) {
+	if numRows <= size && numCols <= size {
+		return luma, numRows, numCols
+	}
+	scale := float64(size) / float64(max(numRows, numCols))
+	outRows := max(int(math.Round(float64(numRows)*scale)), 1)
+	outCols := max(int(math.Round(float64(numCols)*scale)), 1)
+
+	out := make([]float64, outRows*outCols)
+	counts := make([]int, outRows*outCols)
+	for i := 0; i < numRows; i++ {
+		row := i * outRows / numRows * outCols
+		for j := 0; j < numCols; j++ {
+			k := row + j*outCols/numCols
+			out[k] += luma[i*numCols+j]
+			counts[k]++
+		}
+	}
+	for k := range out {
+		out[k] /= float64(counts[k])
+	}
+	return out, outRows, outCols
+}
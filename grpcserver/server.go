@@ -0,0 +1,240 @@
+// Package grpcserver implements the pdq.v1.PDQService gRPC API on top of a
+// PDQHasher and an index.
+package grpcserver
+
+//go:generate sh -c "cd .. && buf generate proto"
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/MTRNord/pdqhash-go/index"
+	pdqv1 "github.com/MTRNord/pdqhash-go/proto/pdq/v1"
+	"github.com/MTRNord/pdqhash-go/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const DEFAULT_MAX_MATCHES = 100
+
+type Config struct {
+	// Distance used by Match when the request does not give one.
+	MatchThreshold int
+	// Upper bound on the number of matches returned per query; 0 or
+	// less means no bound.
+	MaxMatches int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MatchThreshold: index.DEFAULT_MATCH_THRESHOLD,
+		MaxMatches:     DEFAULT_MAX_MATCHES,
+	}
+}
+
+type Server struct {
+	pdqv1.UnimplementedPDQServiceServer
+
+	hasher *pdq.PDQHasher
+	index  *index.Index
+	config Config
+}
+
+var transformFlags = []struct {
+	transform pdqv1.DihedralTransform
+	flag      int
+}{
+	{pdqv1.DihedralTransform_DIHEDRAL_TRANSFORM_ORIGINAL, pdq.PDQ_DO_DIH_ORIGINAL},
+	{pdqv1.DihedralTransform_DIHEDRAL_TRANSFORM_ROTATE_90, pdq.PDQ_DO_DIH_ROTATE_90},
+	{pdqv1.DihedralTransform_DIHEDRAL_TRANSFORM_ROTATE_180, pdq.PDQ_DO_DIH_ROTATE_180},
+	{pdqv1.DihedralTransform_DIHEDRAL_TRANSFORM_ROTATE_270, pdq.PDQ_DO_DIH_ROTATE_270},
+	{pdqv1.DihedralTransform_DIHEDRAL_TRANSFORM_FLIP_X, pdq.PDQ_DO_DIH_FLIPX},
+	{pdqv1.DihedralTransform_DIHEDRAL_TRANSFORM_FLIP_Y, pdq.PDQ_DO_DIH_FLIPY},
+	{pdqv1.DihedralTransform_DIHEDRAL_TRANSFORM_FLIP_PLUS_1, pdq.PDQ_DO_DIH_FLIP_PLUS1},
+	{pdqv1.DihedralTransform_DIHEDRAL_TRANSFORM_FLIP_MINUS_1, pdq.PDQ_DO_DIH_FLIP_MINUS1},
+}
+
+// New builds a server. idx may be nil, in which case Match fails with
+// FailedPrecondition.
+func New(hasher *pdq.PDQHasher, idx *index.Index, config Config) *Server {
+	return &Server{hasher: hasher, index: idx, config: config}
+}
+
+func (s *Server) Register(registrar grpc.ServiceRegistrar) {
+	pdqv1.RegisterPDQServiceServer(registrar, s)
+}
+
+func (s *Server) Hash(ctx context.Context, request *pdqv1.HashRequest) (*pdqv1.HashResponse, error) {
+	hashAndQuality, err := s.hash(ctx, request.GetImage())
+	if err != nil {
+		return nil, err
+	}
+	return &pdqv1.HashResponse{Hash: hashAndQuality.Hash.String(), Quality: int32(hashAndQuality.Quality)}, nil
+}
+
+func (s *Server) HashDihedral(ctx context.Context, request *pdqv1.HashDihedralRequest) (*pdqv1.HashDihedralResponse, error) {
+	if len(request.GetImage()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "empty image")
+	}
+
+	flags := 0
+	for _, transform := range request.GetTransforms() {
+		flag := flagForTransform(transform)
+		if flag == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown transform: %v", transform)
+		}
+		flags |= flag
+	}
+	if flags == 0 {
+		flags = pdq.PDQ_DO_DIH_ALL
+	}
+
+	hashes, err := s.hasher.DihedralFromBufferContext(ctx, request.GetImage(), flags)
+	if err != nil {
+		return nil, hashStatus(err)
+	}
+
+	response := &pdqv1.HashDihedralResponse{Quality: int32(hashes.Quality)}
+	for _, dihedral := range hashes.Hashes() {
+		response.Hashes = append(response.Hashes, &pdqv1.TransformHash{Transform: transformForFlag(dihedral.Flag), Hash: dihedral.Hash.String()})
+	}
+	return response, nil
+}
+
+func (s *Server) HashStream(stream pdqv1.PDQService_HashStreamServer) error {
+	for {
+		request, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		response := &pdqv1.HashStreamResponse{Id: request.GetId()}
+		hashAndQuality, err := s.hash(stream.Context(), request.GetImage())
+		if err != nil {
+			response.Error = status.Convert(err).Message()
+		} else {
+			response.Hash = hashAndQuality.Hash.String()
+			response.Quality = int32(hashAndQuality.Quality)
+		}
+
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) Match(ctx context.Context, request *pdqv1.MatchRequest) (*pdqv1.MatchResponse, error) {
+	if s.index == nil {
+		return nil, status.Error(codes.FailedPrecondition, "no hash list loaded")
+	}
+
+	response := &pdqv1.MatchResponse{}
+	var hash *types.Hash256
+
+	switch query := request.GetQuery().(type) {
+	case *pdqv1.MatchRequest_Hash:
+		var err error
+		hash, err = types.Hash256FromHexString(strings.ToLower(query.Hash))
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	case *pdqv1.MatchRequest_Image:
+		hashAndQuality, err := s.hash(ctx, query.Image)
+		if err != nil {
+			return nil, err
+		}
+		hash = hashAndQuality.Hash
+		quality := int32(hashAndQuality.Quality)
+		response.Quality = &quality
+	default:
+		return nil, status.Error(codes.InvalidArgument, "either hash or image is required")
+	}
+	response.Hash = hash.String()
+
+	var matches []index.Match
+	if request.GetTopK() > 0 {
+		matches = s.index.TopK(hash, s.maxMatches(int(request.GetTopK())))
+	} else {
+		threshold := s.config.MatchThreshold
+		if request.Threshold != nil {
+			threshold = int(request.GetThreshold())
+		}
+		if threshold < 0 || threshold > 256 {
+			return nil, status.Error(codes.InvalidArgument, "threshold must be between 0 and 256")
+		}
+		matches = s.index.Query(hash, threshold)
+		if n := s.maxMatches(len(matches)); n < len(matches) {
+			matches = matches[:n]
+		}
+	}
+
+	for _, match := range matches {
+		m := &pdqv1.Match{
+			Hash:     match.Entry.Hash.String(),
+			Distance: int32(match.Distance),
+			Id:       match.Entry.ID,
+			Tags:     match.Entry.Tags,
+		}
+		if match.Entry.Quality >= 0 {
+			quality := int32(match.Entry.Quality)
+			m.Quality = &quality
+		}
+		response.Matches = append(response.Matches, m)
+	}
+	return response, nil
+}
+
+func (s *Server) hash(ctx context.Context, image []byte) (pdq.HashAndQuality, error) {
+	if len(image) == 0 {
+		return pdq.HashAndQuality{}, status.Error(codes.InvalidArgument, "empty image")
+	}
+	hashAndQuality, err := s.hasher.FromBufferContext(ctx, image)
+	if err != nil {
+		return pdq.HashAndQuality{}, hashStatus(err)
+	}
+	return hashAndQuality, nil
+}
+
+// Images that cannot be decoded or are rejected are the caller's fault;
+// failures to read or process an accepted image are the server's.
+func hashStatus(err error) error {
+	code := codes.InvalidArgument
+	var hashErr *pdq.HashError
+	if errors.As(err, &hashErr) && (hashErr.Reason == pdq.FAILURE_IO || hashErr.Reason == pdq.FAILURE_PROCESSING) {
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error())
+}
+
+func flagForTransform(transform pdqv1.DihedralTransform) int {
+	for _, tf := range transformFlags {
+		if tf.transform == transform {
+			return tf.flag
+		}
+	}
+	return 0
+}
+
+func transformForFlag(flag int) pdqv1.DihedralTransform {
+	for _, tf := range transformFlags {
+		if tf.flag == flag {
+			return tf.transform
+		}
+	}
+	return pdqv1.DihedralTransform_DIHEDRAL_TRANSFORM_UNSPECIFIED
+}
+
+// Caps n at MaxMatches, if set.
+func (s *Server) maxMatches(n int) int {
+	if s.config.MaxMatches > 0 {
+		return min(n, s.config.MaxMatches)
+	}
+	return n
+}
@@ -0,0 +1,214 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/MTRNord/pdqhash-go/hashlist"
+	"github.com/MTRNord/pdqhash-go/index"
+	pdqv1 "github.com/MTRNord/pdqhash-go/proto/pdq/v1"
+	"github.com/MTRNord/pdqhash-go/types"
+	"github.com/davidbyttow/govips/v2/vips"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const BRIDGE_IMAGE = "../test-images/reg-test-input/dih/bridge-1-original.jpg"
+const BRIDGE_HASH = "d8f8f0cce0f4a84f0e370a22028f67f0b36e2ed596623e1d33e6b39c4e9c9b22"
+const Q0004_IMAGE = "../test-images/reg-test-input/labelme-subset/q0004.jpg"
+const Q0004_HASH = "992d44af36d69e6ca6b812585928bac11def254ef5398c6d07466c9abcc65b92"
+const Q0004_FLIPX_HASH = "d92dbb5036d62093a6b82da75928453e1defdab1f539439247469325bcc6a465"
+
+const HAMMING_TOLERANCE = 16
+
+func TestMain(m *testing.M) {
+	vips.LoggingSettings(nil, vips.LogLevelMessage)
+	vips.Startup(&vips.Config{
+		ConcurrencyLevel: 0,
+		MaxCacheFiles:    5,
+		MaxCacheMem:      50 * 1024 * 1024,
+		MaxCacheSize:     100,
+		ReportLeaks:      false,
+		CacheTrace:       false,
+		CollectStats:     false,
+	})
+	defer vips.Shutdown()
+
+	os.Exit(m.Run())
+}
+
+func newTestClient(t *testing.T, idx *index.Index) pdqv1.PDQServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	New(pdq.NewPDQHasher(), idx, DefaultConfig()).Register(srv)
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.ErrorIs(t, err, nil)
+	t.Cleanup(func() { conn.Close() })
+
+	return pdqv1.NewPDQServiceClient(conn)
+}
+
+func newTestIndex(t *testing.T) *index.Index {
+	hash, err := types.Hash256FromHexString(BRIDGE_HASH)
+	assert.ErrorIs(t, err, nil)
+	return index.FromEntries([]*hashlist.Entry{
+		{Hash: hash, Quality: 100, ID: "bridge", Tags: []string{"landscape"}},
+	})
+}
+
+func readFile(t *testing.T, path string) []byte {
+	buf, err := os.ReadFile(path)
+	assert.ErrorIs(t, err, nil)
+	return buf
+}
+
+func assertHashNear(t *testing.T, expected, actual string) {
+	expectedHash, err := types.Hash256FromHexString(expected)
+	assert.ErrorIs(t, err, nil)
+	actualHash, err := types.Hash256FromHexString(actual)
+	assert.ErrorIs(t, err, nil)
+	assert.LessOrEqual(t, expectedHash.HammingDistance(actualHash), HAMMING_TOLERANCE)
+}
+
+func TestHash(t *testing.T) {
+	client := newTestClient(t, nil)
+
+	response, err := client.Hash(context.Background(), &pdqv1.HashRequest{Image: readFile(t, BRIDGE_IMAGE)})
+	assert.ErrorIs(t, err, nil)
+	assertHashNear(t, BRIDGE_HASH, response.GetHash())
+	assert.Greater(t, response.GetQuality(), int32(0))
+
+	_, err = client.Hash(context.Background(), &pdqv1.HashRequest{Image: []byte("not an image")})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Hash(context.Background(), &pdqv1.HashRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestHashStatus(t *testing.T) {
+	for reason, code := range map[string]codes.Code{
+		pdq.FAILURE_CORRUPT:            codes.InvalidArgument,
+		pdq.FAILURE_UNSUPPORTED_FORMAT: codes.InvalidArgument,
+		pdq.FAILURE_LOW_QUALITY:        codes.InvalidArgument,
+		pdq.FAILURE_IO:                 codes.Internal,
+		pdq.FAILURE_PROCESSING:         codes.Internal,
+	} {
+		err := hashStatus(&pdq.HashError{Reason: reason, Err: errors.New(reason)})
+		assert.Equal(t, code, status.Code(err), reason)
+	}
+}
+
+func TestHashDihedral(t *testing.T) {
+	client := newTestClient(t, nil)
+
+	response, err := client.HashDihedral(context.Background(), &pdqv1.HashDihedralRequest{Image: readFile(t, Q0004_IMAGE)})
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, response.GetHashes(), 8)
+	assert.Equal(t, pdqv1.DihedralTransform_DIHEDRAL_TRANSFORM_ORIGINAL, response.GetHashes()[0].GetTransform())
+	assertHashNear(t, Q0004_HASH, response.GetHashes()[0].GetHash())
+
+	response, err = client.HashDihedral(context.Background(), &pdqv1.HashDihedralRequest{
+		Image:      readFile(t, Q0004_IMAGE),
+		Transforms: []pdqv1.DihedralTransform{pdqv1.DihedralTransform_DIHEDRAL_TRANSFORM_FLIP_X},
+	})
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, response.GetHashes(), 1)
+	assert.Equal(t, pdqv1.DihedralTransform_DIHEDRAL_TRANSFORM_FLIP_X, response.GetHashes()[0].GetTransform())
+	assertHashNear(t, Q0004_FLIPX_HASH, response.GetHashes()[0].GetHash())
+}
+
+func TestHashStream(t *testing.T) {
+	client := newTestClient(t, nil)
+
+	stream, err := client.HashStream(context.Background())
+	assert.ErrorIs(t, err, nil)
+
+	requests := []*pdqv1.HashStreamRequest{
+		{Id: "bridge", Image: readFile(t, BRIDGE_IMAGE)},
+		{Id: "broken", Image: []byte("not an image")},
+		{Id: "q0004", Image: readFile(t, Q0004_IMAGE)},
+	}
+	for _, request := range requests {
+		assert.ErrorIs(t, stream.Send(request), nil)
+	}
+	assert.ErrorIs(t, stream.CloseSend(), nil)
+
+	var responses []*pdqv1.HashStreamResponse
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.ErrorIs(t, err, nil)
+		responses = append(responses, response)
+	}
+
+	assert.Len(t, responses, 3)
+	assert.Equal(t, "bridge", responses[0].GetId())
+	assertHashNear(t, BRIDGE_HASH, responses[0].GetHash())
+	assert.Equal(t, "broken", responses[1].GetId())
+	assert.NotEmpty(t, responses[1].GetError())
+	assert.Empty(t, responses[1].GetHash())
+	assert.Equal(t, "q0004", responses[2].GetId())
+	assertHashNear(t, Q0004_HASH, responses[2].GetHash())
+}
+
+func TestMatch(t *testing.T) {
+	client := newTestClient(t, newTestIndex(t))
+
+	hash, err := types.Hash256FromHexString(BRIDGE_HASH)
+	assert.ErrorIs(t, err, nil)
+	hash.FlipBit(3)
+	hash.FlipBit(99)
+
+	response, err := client.Match(context.Background(), &pdqv1.MatchRequest{Query: &pdqv1.MatchRequest_Hash{Hash: hash.String()}})
+	assert.ErrorIs(t, err, nil)
+	assert.Nil(t, response.Quality)
+	assert.Len(t, response.GetMatches(), 1)
+	assert.Equal(t, "bridge", response.GetMatches()[0].GetId())
+	assert.Equal(t, int32(2), response.GetMatches()[0].GetDistance())
+	assert.Equal(t, int32(100), response.GetMatches()[0].GetQuality())
+
+	threshold := int32(1)
+	response, err = client.Match(context.Background(), &pdqv1.MatchRequest{Query: &pdqv1.MatchRequest_Hash{Hash: hash.String()}, Threshold: &threshold})
+	assert.ErrorIs(t, err, nil)
+	assert.Empty(t, response.GetMatches())
+
+	response, err = client.Match(context.Background(), &pdqv1.MatchRequest{Query: &pdqv1.MatchRequest_Image{Image: readFile(t, BRIDGE_IMAGE)}, TopK: 5})
+	assert.ErrorIs(t, err, nil)
+	assert.NotNil(t, response.Quality)
+	assert.Len(t, response.GetMatches(), 1)
+	assert.LessOrEqual(t, response.GetMatches()[0].GetDistance(), int32(HAMMING_TOLERANCE))
+
+	_, err = client.Match(context.Background(), &pdqv1.MatchRequest{Query: &pdqv1.MatchRequest_Hash{Hash: "abc"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Match(context.Background(), &pdqv1.MatchRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestMatchWithoutIndex(t *testing.T) {
+	client := newTestClient(t, nil)
+
+	_, err := client.Match(context.Background(), &pdqv1.MatchRequest{Query: &pdqv1.MatchRequest_Hash{Hash: BRIDGE_HASH}})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
@@ -0,0 +1,400 @@
+// Package hashlist reads and writes PDQ hash lists in the CSV/TSV layouts
+// used by ThreatExchange and Hasher-Matcher-Actioner exports.
+package hashlist
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/MTRNord/pdqhash-go/types"
+)
+
+// Column names understood by Reader and emitted by Writer.
+const (
+	ColumnHash      = "hash"
+	ColumnQuality   = "quality"
+	ColumnID        = "id"
+	ColumnTags      = "tags"
+	ColumnAddedOn   = "added_on"
+	ColumnUpdatedOn = "updated_on"
+)
+
+// QUALITY_UNKNOWN is stored in Entry.Quality when a row has no quality.
+const QUALITY_UNKNOWN = -1
+
+// Alternative header spellings seen in partner exports.
+var columnAliases = map[string]string{
+	"hash":             ColumnHash,
+	"pdq":              ColumnHash,
+	"pdq_hash":         ColumnHash,
+	"signal":           ColumnHash,
+	"signal_value":     ColumnHash,
+	"indicator":        ColumnHash,
+	"quality":          ColumnQuality,
+	"pdq_quality":      ColumnQuality,
+	"id":               ColumnID,
+	"indicator_id":     ColumnID,
+	"signal_id":        ColumnID,
+	"descriptor_id":    ColumnID,
+	"tags":             ColumnTags,
+	"labels":           ColumnTags,
+	"added_on":         ColumnAddedOn,
+	"created":          ColumnAddedOn,
+	"created_at":       ColumnAddedOn,
+	"creation_time":    ColumnAddedOn,
+	"updated_on":       ColumnUpdatedOn,
+	"updated":          ColumnUpdatedOn,
+	"updated_at":       ColumnUpdatedOn,
+	"last_updated":     ColumnUpdatedOn,
+	"last_updated_at":  ColumnUpdatedOn,
+	"last_update_time": ColumnUpdatedOn,
+}
+
+// Format describes the on-disk layout of a hash list.
+type Format struct {
+	// Field delimiter.
+	Comma rune
+	// Column order assumed for files without a header row, and written by
+	// Writer.
+	Columns []string
+	// Separator between tags inside the tags column.
+	TagSeparator string
+	// Whether Writer emits a header row.
+	Header bool
+}
+
+// ThreatExchange is the comma-separated layout of ThreatExchange PDQ exports.
+var ThreatExchange = Format{
+	Comma:        ',',
+	Columns:      []string{ColumnHash, ColumnQuality, ColumnID, ColumnTags, ColumnAddedOn, ColumnUpdatedOn},
+	TagSeparator: " ",
+	Header:       true,
+}
+
+// HMA is the tab-separated layout of Hasher-Matcher-Actioner signal exports.
+var HMA = Format{
+	Comma:        '\t',
+	Columns:      []string{ColumnHash, ColumnID, ColumnQuality, ColumnTags, ColumnAddedOn, ColumnUpdatedOn},
+	TagSeparator: ",",
+	Header:       true,
+}
+
+// FormatForPath picks HMA for .tsv files and ThreatExchange otherwise.
+func FormatForPath(path string) Format {
+	if strings.EqualFold(filepath.Ext(path), ".tsv") {
+		return HMA
+	}
+	return ThreatExchange
+}
+
+// Entry is one row of a hash list.
+type Entry struct {
+	Hash      *types.Hash256
+	Quality   int
+	ID        string
+	Tags      []string
+	AddedOn   time.Time
+	UpdatedOn time.Time
+}
+
+// ParseError reports a malformed row together with its line number.
+type ParseError struct {
+	Line   int
+	Column string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d: column %s: %v", e.Line, e.Column, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Reader streams entries from a hash list. A header row is detected
+// automatically by its hash column, and columns it does not know are
+// skipped; without one the columns of the Format are assumed.
+type Reader struct {
+	csv     *csv.Reader
+	format  Format
+	columns []string
+	started bool
+}
+
+func NewReader(r io.Reader, format Format) *Reader {
+	c := csv.NewReader(r)
+	c.Comma = format.Comma
+	c.Comment = '#'
+	c.FieldsPerRecord = -1
+	// Tabs count as leading space, so trimming would drop empty TSV
+	// fields; parseRecord trims each value instead.
+	c.TrimLeadingSpace = !unicode.IsSpace(format.Comma)
+	c.LazyQuotes = format.Comma == '\t'
+	c.ReuseRecord = true
+
+	return &Reader{csv: c, format: format, columns: format.Columns}
+}
+
+// Read returns the next entry, or io.EOF once the list is exhausted.
+func (r *Reader) Read() (*Entry, error) {
+	for {
+		record, err := r.csv.Read()
+		if err != nil {
+			var csvErr *csv.ParseError
+			if errors.As(err, &csvErr) {
+				return nil, &ParseError{Line: csvErr.Line, Err: csvErr.Err}
+			}
+			return nil, err
+		}
+		line, _ := r.csv.FieldPos(0)
+
+		if isBlank(record) {
+			continue
+		}
+
+		if !r.started {
+			r.started = true
+			columns, ok := headerColumns(record)
+			if ok {
+				r.columns = columns
+				continue
+			}
+		}
+
+		return r.parseRecord(record, line)
+	}
+}
+
+// ReadAll reads every remaining entry.
+func (r *Reader) ReadAll() ([]*Entry, error) {
+	var entries []*Entry
+	for {
+		entry, err := r.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// ReadFile loads a whole hash list, choosing the format by extension.
+func ReadFile(path string) ([]*Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := NewReader(f, FormatForPath(path)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return entries, nil
+}
+
+func (r *Reader) parseRecord(record []string, line int) (*Entry, error) {
+	if len(record) > len(r.columns) {
+		return nil, &ParseError{Line: line, Err: fmt.Errorf("expected at most %d fields, got %d", len(r.columns), len(record))}
+	}
+
+	entry := &Entry{Quality: QUALITY_UNKNOWN}
+	for i, value := range record {
+		column := r.columns[i]
+		value = strings.TrimSpace(value)
+		if err := r.setField(entry, column, value); err != nil {
+			return nil, &ParseError{Line: line, Column: column, Err: err}
+		}
+	}
+
+	if entry.Hash == nil {
+		return nil, &ParseError{Line: line, Column: ColumnHash, Err: errors.New("missing hash")}
+	}
+	return entry, nil
+}
+
+func (r *Reader) setField(entry *Entry, column, value string) error {
+	switch column {
+	case ColumnHash:
+		hash, err := types.Hash256FromHexString(strings.ToLower(value))
+		if err != nil {
+			return err
+		}
+		entry.Hash = hash
+	case ColumnQuality:
+		if value == "" {
+			return nil
+		}
+		quality, err := strconv.Atoi(value)
+		if err != nil || quality < 0 || quality > 100 {
+			return fmt.Errorf("incorrect quality: %s", value)
+		}
+		entry.Quality = quality
+	case ColumnID:
+		entry.ID = value
+	case ColumnTags:
+		entry.Tags = splitTags(value, r.format.TagSeparator)
+	case ColumnAddedOn:
+		t, err := parseTime(value)
+		if err != nil {
+			return err
+		}
+		entry.AddedOn = t
+	case ColumnUpdatedOn:
+		t, err := parseTime(value)
+		if err != nil {
+			return err
+		}
+		entry.UpdatedOn = t
+	}
+	return nil
+}
+
+// Writer writes entries using the column layout of a Format.
+type Writer struct {
+	csv         *csv.Writer
+	format      Format
+	wroteHeader bool
+	record      []string
+}
+
+func NewWriter(w io.Writer, format Format) *Writer {
+	c := csv.NewWriter(w)
+	c.Comma = format.Comma
+
+	return &Writer{csv: c, format: format, record: make([]string, len(format.Columns))}
+}
+
+func (w *Writer) Write(entry *Entry) error {
+	if entry.Hash == nil {
+		return errors.New("entry has no hash")
+	}
+
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	for i, column := range w.format.Columns {
+		w.record[i] = w.formatField(entry, column)
+	}
+	return w.csv.Write(w.record)
+}
+
+func (w *Writer) WriteAll(entries []*Entry) error {
+	for _, entry := range entries {
+		if err := w.Write(entry); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// Flush writes any buffered data and reports the first write error. The
+// header is written even if there were no entries, so that an empty list
+// keeps its layout.
+func (w *Writer) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+func (w *Writer) writeHeader() error {
+	if !w.format.Header || w.wroteHeader {
+		return nil
+	}
+	w.wroteHeader = true
+	return w.csv.Write(w.format.Columns)
+}
+
+func (w *Writer) formatField(entry *Entry, column string) string {
+	switch column {
+	case ColumnHash:
+		return entry.Hash.String()
+	case ColumnQuality:
+		if entry.Quality < 0 {
+			return ""
+		}
+		return strconv.Itoa(entry.Quality)
+	case ColumnID:
+		return entry.ID
+	case ColumnTags:
+		return strings.Join(entry.Tags, w.format.TagSeparator)
+	case ColumnAddedOn:
+		return formatTime(entry.AddedOn)
+	case ColumnUpdatedOn:
+		return formatTime(entry.UpdatedOn)
+	}
+	return ""
+}
+
+// Unknown columns are left empty, which setField ignores.
+func headerColumns(record []string) ([]string, bool) {
+	columns := make([]string, len(record))
+	hasHash := false
+	for i, field := range record {
+		columns[i] = columnAliases[strings.ToLower(strings.TrimSpace(field))]
+		hasHash = hasHash || columns[i] == ColumnHash
+	}
+	return columns, hasHash
+}
+
+func isBlank(record []string) bool {
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func splitTags(value, separator string) []string {
+	if value == "" {
+		return nil
+	}
+	var tags []string
+	for _, tag := range strings.Split(value, separator) {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Timestamps are accepted as RFC 3339 or as Unix seconds.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("incorrect timestamp: %s", value)
+	}
+	return t, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
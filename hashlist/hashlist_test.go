@@ -0,0 +1,149 @@
+package hashlist
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MTRNord/pdqhash-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const SAMPLE_HASH = "9c151c3af838278e3ef57c180c7d031c07aefd12f2ccc1e18f2a1e1c7d0ff163"
+const OTHER_HASH = "d8f8f0cce0f4a84f0e370a22028f67f0b36e2ed596623e1d33e6b39c4e9c9b22"
+
+func TestReadWithHeader(t *testing.T) {
+	input := "pdq_hash,indicator_id,quality,tags,created\n" +
+		SAMPLE_HASH + ",1234,87,\"csam media_type_photo\",2023-04-01T10:00:00Z\n" +
+		"\n" +
+		"# comment\n" +
+		strings.ToUpper(OTHER_HASH) + ",5678,,,1700000000\n"
+
+	entries, err := NewReader(strings.NewReader(input), ThreatExchange).ReadAll()
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, entries, 2)
+
+	assert.Equal(t, SAMPLE_HASH, entries[0].Hash.String())
+	assert.Equal(t, "1234", entries[0].ID)
+	assert.Equal(t, 87, entries[0].Quality)
+	assert.Equal(t, []string{"csam", "media_type_photo"}, entries[0].Tags)
+	assert.Equal(t, time.Date(2023, 4, 1, 10, 0, 0, 0, time.UTC), entries[0].AddedOn)
+
+	assert.Equal(t, OTHER_HASH, entries[1].Hash.String())
+	assert.Equal(t, QUALITY_UNKNOWN, entries[1].Quality)
+	assert.Nil(t, entries[1].Tags)
+	assert.Equal(t, int64(1700000000), entries[1].AddedOn.Unix())
+}
+
+func TestReadSkipsUnknownColumns(t *testing.T) {
+	input := "source,pdq,indicator_id,notes\n" +
+		"partner," + SAMPLE_HASH + ",1234,\"seen, twice\"\n"
+
+	entries, err := NewReader(strings.NewReader(input), ThreatExchange).ReadAll()
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, SAMPLE_HASH, entries[0].Hash.String())
+	assert.Equal(t, "1234", entries[0].ID)
+
+	// Without a hash column the first row is data.
+	_, err = NewReader(strings.NewReader("source,indicator_id\n"), ThreatExchange).ReadAll()
+	assert.ErrorContains(t, err, "line 1: column hash")
+}
+
+func TestReadWithoutHeader(t *testing.T) {
+	input := SAMPLE_HASH + "\tabc\t100\tone,two\n"
+
+	reader := NewReader(strings.NewReader(input), HMA)
+	entry, err := reader.Read()
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, SAMPLE_HASH, entry.Hash.String())
+	assert.Equal(t, "abc", entry.ID)
+	assert.Equal(t, 100, entry.Quality)
+	assert.Equal(t, []string{"one", "two"}, entry.Tags)
+
+	_, err = reader.Read()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReadEmptyTSVFields(t *testing.T) {
+	input := SAMPLE_HASH + "\t\t55\tx\n" +
+		OTHER_HASH + "\tid1\t\ttag\t\t\n"
+
+	entries, err := NewReader(strings.NewReader(input), HMA).ReadAll()
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "", entries[0].ID)
+	assert.Equal(t, 55, entries[0].Quality)
+	assert.Equal(t, []string{"x"}, entries[0].Tags)
+	assert.Equal(t, "id1", entries[1].ID)
+	assert.Equal(t, QUALITY_UNKNOWN, entries[1].Quality)
+	assert.Equal(t, []string{"tag"}, entries[1].Tags)
+}
+
+func TestReadReportsLineNumbers(t *testing.T) {
+	input := "hash,quality\n" +
+		SAMPLE_HASH + ",50\n" +
+		SAMPLE_HASH[:60] + ",50\n"
+
+	reader := NewReader(strings.NewReader(input), ThreatExchange)
+	_, err := reader.Read()
+	assert.ErrorIs(t, err, nil)
+
+	_, err = reader.Read()
+	var parseErr *ParseError
+	assert.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, 3, parseErr.Line)
+	assert.Equal(t, ColumnHash, parseErr.Column)
+	assert.ErrorContains(t, err, "line 3")
+	assert.ErrorContains(t, err, "incorrect hash length")
+}
+
+func TestReadRejectsBadQuality(t *testing.T) {
+	_, err := NewReader(strings.NewReader(SAMPLE_HASH+",101\n"), ThreatExchange).ReadAll()
+	assert.ErrorContains(t, err, "line 1: column quality: incorrect quality")
+}
+
+func TestReadRejectsTooManyFields(t *testing.T) {
+	_, err := NewReader(strings.NewReader("hash\n"+SAMPLE_HASH+",1\n"), ThreatExchange).ReadAll()
+	assert.ErrorContains(t, err, "line 2: expected at most 1 fields")
+}
+
+func TestRoundTrip(t *testing.T) {
+	hash, err := types.Hash256FromHexString(SAMPLE_HASH)
+	assert.ErrorIs(t, err, nil)
+
+	for _, format := range []Format{ThreatExchange, HMA} {
+		entries := []*Entry{
+			{
+				Hash:      hash,
+				Quality:   42,
+				ID:        "id-1",
+				Tags:      []string{"a", "b"},
+				AddedOn:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+				UpdatedOn: time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC),
+			},
+			{Hash: hash, Quality: QUALITY_UNKNOWN},
+		}
+
+		var buf bytes.Buffer
+		assert.ErrorIs(t, NewWriter(&buf, format).WriteAll(entries), nil)
+
+		read, err := NewReader(&buf, format).ReadAll()
+		assert.ErrorIs(t, err, nil)
+		assert.Equal(t, entries, read)
+
+		buf.Reset()
+		assert.ErrorIs(t, NewWriter(&buf, format).WriteAll(nil), nil)
+		assert.Equal(t, strings.Join(format.Columns, string(format.Comma))+"\n", buf.String())
+		read, err = NewReader(&buf, format).ReadAll()
+		assert.ErrorIs(t, err, nil)
+		assert.Empty(t, read)
+	}
+}
+
+func TestFormatForPath(t *testing.T) {
+	assert.Equal(t, '\t', FormatForPath("list.TSV").Comma)
+	assert.Equal(t, ',', FormatForPath("list.csv").Comma)
+}
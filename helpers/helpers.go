@@ -10,26 +10,32 @@ func Abs[T constraints.Integer](x T) T {
 }
 
 func Torben(m [][]float64, numRows, numCols int) float64 {
-	n := numRows * numCols
+	flat := make([]float64, 0, numRows*numCols)
+	for i := 0; i < numRows; i++ {
+		flat = append(flat, m[i][:numCols]...)
+	}
+	return TorbenFlat(flat)
+}
+
+// The median of v, as Torben of a matrix stored flat.
+func TorbenFlat(m []float64) float64 {
+	n := len(m)
 	midn := (n + 1) / 2
 	less := 0
 	greater := 0
 	equal := 0
-	min := m[0][0]
-	max := m[0][0]
+	min := m[0]
+	max := m[0]
 	guess := float64(0.0)
 	maxltguess := float64(0.0)
 	mingtguess := float64(0.0)
-	for i := 0; i < numRows; i++ {
-		for j := 0; j < numCols; j++ {
-			v := m[i][j]
-			if v < min {
-				min = v
-			}
+	for _, v := range m {
+		if v < min {
+			min = v
+		}
 
-			if v > max {
-				max = v
-			}
+		if v > max {
+			max = v
 		}
 	}
 
@@ -41,22 +47,19 @@ func Torben(m [][]float64, numRows, numCols int) float64 {
 		maxltguess = min
 		mingtguess = max
 
-		for i := 0; i < numRows; i++ {
-			for j := 0; j < numCols; j++ {
-				v := m[i][j]
-				if v < guess {
-					less++
-					if v > maxltguess {
-						maxltguess = v
-					}
-				} else if v > guess {
-					greater++
-					if v < mingtguess {
-						mingtguess = v
-					}
-				} else {
-					equal++
+		for _, v := range m {
+			if v < guess {
+				less++
+				if v > maxltguess {
+					maxltguess = v
+				}
+			} else if v > guess {
+				greater++
+				if v < mingtguess {
+					mingtguess = v
 				}
+			} else {
+				equal++
 			}
 		}
 
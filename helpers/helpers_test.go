@@ -18,6 +18,12 @@ func TestTorben(t *testing.T) {
 	}
 
 	assert.Equal(t, float64(1.07), Torben(m, numRows, numCols), "The Torben function should produce 1.07 for 3 rows and 8 cols")
+
+	var flat []float64
+	for _, row := range m {
+		flat = append(flat, row...)
+	}
+	assert.Equal(t, float64(1.07), TorbenFlat(flat), "TorbenFlat should agree with Torben")
 }
 
 func TestAbs(t *testing.T) {
@@ -0,0 +1,175 @@
+// Package index answers near-neighbour queries over a set of PDQ hashes.
+package index
+
+import (
+	"sort"
+	"time"
+
+	"github.com/MTRNord/pdqhash-go/hashlist"
+	"github.com/MTRNord/pdqhash-go/types"
+)
+
+// Upstream recommends 31 as the distance below which two PDQ hashes are
+// considered a match.
+const DEFAULT_MATCH_THRESHOLD = 31
+
+// Beyond this per-slot radius the number of probes outgrows a linear scan.
+const MAX_PROBE_RADIUS = 2
+
+// Instrumentation receives query measurements from an Index. It must be
+// safe for concurrent use.
+type Instrumentation interface {
+	QueryDone(elapsed time.Duration, matches int)
+}
+
+type Match struct {
+	Entry    *hashlist.Entry
+	Distance int
+}
+
+/**
+ * Index is a mutually-indexed hashing (MIH) structure: every hash is
+ * filed under each of its 16 16-bit slots. If two hashes are within
+ * distance d, by the pigeonhole principle at least one of their slots
+ * is within d/16, so only the neighbours of the query slots need to be
+ * examined. See hashing/pdq/README-MIH.md in the upstream repo.
+ *
+ * An Index may be queried concurrently, but Add must not run at the same
+ * time as a query.
+ */
+type Index struct {
+	entries []*hashlist.Entry
+	slots   [types.HASH256_NUM_SLOTS]map[uint16][]int
+
+	// Optional; observes every Query and TopK call.
+	Instrumentation Instrumentation
+}
+
+func New() *Index {
+	idx := &Index{}
+	for i := range idx.slots {
+		idx.slots[i] = make(map[uint16][]int)
+	}
+	return idx
+}
+
+func FromEntries(entries []*hashlist.Entry) *Index {
+	idx := New()
+	for _, entry := range entries {
+		idx.Add(entry)
+	}
+	return idx
+}
+
+func (idx *Index) Add(entry *hashlist.Entry) {
+	id := len(idx.entries)
+	idx.entries = append(idx.entries, entry)
+	for i := 0; i < types.HASH256_NUM_SLOTS; i++ {
+		word := uint16(entry.Hash.W[i])
+		idx.slots[i][word] = append(idx.slots[i][word], id)
+	}
+}
+
+func (idx *Index) Len() int {
+	return len(idx.entries)
+}
+
+func (idx *Index) Entries() []*hashlist.Entry {
+	return idx.entries
+}
+
+// Query returns every entry within threshold of hash, nearest first.
+func (idx *Index) Query(hash *types.Hash256, threshold int) (matches []Match) {
+	if threshold < 0 {
+		return nil
+	}
+	if idx.Instrumentation != nil {
+		start := time.Now()
+		defer func() {
+			idx.Instrumentation.QueryDone(time.Since(start), len(matches))
+		}()
+	}
+
+	radius := threshold / types.HASH256_NUM_SLOTS
+	if radius > MAX_PROBE_RADIUS {
+		for _, entry := range idx.entries {
+			if hash.HammingDistanceLE(entry.Hash, threshold) {
+				matches = append(matches, Match{entry, hash.HammingDistance(entry.Hash)})
+			}
+		}
+	} else {
+		seen := make(map[int]struct{})
+		for i := 0; i < types.HASH256_NUM_SLOTS; i++ {
+			forEachNeighbour(uint16(hash.W[i]), radius, func(word uint16) {
+				for _, id := range idx.slots[i][word] {
+					if _, ok := seen[id]; ok {
+						continue
+					}
+					seen[id] = struct{}{}
+					entry := idx.entries[id]
+					if hash.HammingDistanceLE(entry.Hash, threshold) {
+						matches = append(matches, Match{entry, hash.HammingDistance(entry.Hash)})
+					}
+				}
+			})
+		}
+	}
+
+	sortMatches(matches)
+	return matches
+}
+
+// TopK returns the k entries nearest to hash regardless of distance.
+func (idx *Index) TopK(hash *types.Hash256, k int) (matches []Match) {
+	if k <= 0 {
+		return nil
+	}
+	if idx.Instrumentation != nil {
+		start := time.Now()
+		defer func() {
+			idx.Instrumentation.QueryDone(time.Since(start), len(matches))
+		}()
+	}
+
+	matches = make([]Match, 0, k+1)
+	for _, entry := range idx.entries {
+		distance := hash.HammingDistance(entry.Hash)
+		if len(matches) == k && distance >= matches[k-1].Distance {
+			continue
+		}
+		// Insertion into the sorted window; k is expected to be small.
+		pos := sort.Search(len(matches), func(i int) bool {
+			return matches[i].Distance > distance
+		})
+		matches = append(matches, Match{})
+		copy(matches[pos+1:], matches[pos:])
+		matches[pos] = Match{entry, distance}
+		if len(matches) > k {
+			matches = matches[:k]
+		}
+	}
+	return matches
+}
+
+func sortMatches(matches []Match) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Distance < matches[j].Distance
+	})
+}
+
+// Calls fn for every 16-bit word within radius bits of word.
+func forEachNeighbour(word uint16, radius int, fn func(uint16)) {
+	fn(word)
+	if radius >= 1 {
+		for i := 0; i < 16; i++ {
+			fn(word ^ (1 << i))
+		}
+	}
+	if radius >= 2 {
+		for i := 0; i < 16; i++ {
+			for j := i + 1; j < 16; j++ {
+				fn(word ^ (1 << i) ^ (1 << j))
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package index
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/MTRNord/pdqhash-go/hashlist"
+	"github.com/MTRNord/pdqhash-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func randomHash(r *rand.Rand) *types.Hash256 {
+	hash := &types.Hash256{}
+	for i := 0; i < types.HASH256_NUM_SLOTS; i++ {
+		hash.W[i] = r.Intn(0x10000)
+	}
+	return hash
+}
+
+func flipBits(r *rand.Rand, hash *types.Hash256, n int) *types.Hash256 {
+	rv := hash.Clone()
+	for _, k := range r.Perm(256)[:n] {
+		rv.FlipBit(k)
+	}
+	return &rv
+}
+
+func bruteForce(entries []*hashlist.Entry, hash *types.Hash256, threshold int) []Match {
+	var matches []Match
+	for _, entry := range entries {
+		if d := hash.HammingDistance(entry.Hash); d <= threshold {
+			matches = append(matches, Match{entry, d})
+		}
+	}
+	sortMatches(matches)
+	return matches
+}
+
+func TestQueryMatchesBruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	var entries []*hashlist.Entry
+	var queries []*types.Hash256
+	for i := 0; i < 200; i++ {
+		hash := randomHash(r)
+		entries = append(entries, &hashlist.Entry{Hash: hash})
+		for _, n := range []int{0, 5, 20, 31, 40} {
+			entries = append(entries, &hashlist.Entry{Hash: flipBits(r, hash, n)})
+		}
+		queries = append(queries, flipBits(r, hash, r.Intn(10)))
+	}
+	idx := FromEntries(entries)
+	assert.Equal(t, len(entries), idx.Len())
+
+	for _, threshold := range []int{0, 15, 31, 47, 64} {
+		for _, query := range queries {
+			expected := bruteForce(entries, query, threshold)
+			actual := idx.Query(query, threshold)
+			assert.ElementsMatch(t, expected, actual, "threshold %d", threshold)
+			for i := 1; i < len(actual); i++ {
+				assert.LessOrEqual(t, actual[i-1].Distance, actual[i].Distance)
+			}
+		}
+	}
+}
+
+func TestTopK(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	query := randomHash(r)
+
+	var entries []*hashlist.Entry
+	for _, n := range []int{50, 3, 90, 10, 0, 120} {
+		entries = append(entries, &hashlist.Entry{Hash: flipBits(r, query, n)})
+	}
+	idx := FromEntries(entries)
+
+	matches := idx.TopK(query, 3)
+	assert.Len(t, matches, 3)
+	assert.Equal(t, 0, matches[0].Distance)
+	assert.Equal(t, 3, matches[1].Distance)
+	assert.Equal(t, 10, matches[2].Distance)
+
+	assert.Len(t, idx.TopK(query, 10), len(entries))
+	assert.Nil(t, idx.TopK(query, 0))
+}
+
+func TestQueryEmptyIndex(t *testing.T) {
+	idx := New()
+	assert.Empty(t, idx.Query(&types.Hash256{}, DEFAULT_MATCH_THRESHOLD))
+	assert.Empty(t, idx.TopK(&types.Hash256{}, 1))
+}
@@ -0,0 +1,65 @@
+package pdq
+
+import (
+	"time"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// Stages of the hashing pipeline, in the order they run.
+type Stage string
+
+const STAGE_DECODE Stage = "decode"
+const STAGE_THUMBNAIL Stage = "thumbnail"
+const STAGE_LUMA Stage = "luma"
+const STAGE_FILTER Stage = "filter"
+const STAGE_DCT Stage = "dct"
+
+var STAGES = []Stage{STAGE_DECODE, STAGE_THUMBNAIL, STAGE_LUMA, STAGE_FILTER, STAGE_DCT}
+
+// Reasons an image could not be hashed.
+const FAILURE_IO = "io"
+const FAILURE_EMPTY = "empty"
+const FAILURE_UNSUPPORTED_FORMAT = "unsupported_format"
+const FAILURE_CORRUPT = "corrupt"
+const FAILURE_PROCESSING = "processing"
+
+/**
+ * Instrumentation receives measurements from a PDQHasher. It is called
+ * from whichever goroutine is hashing, so implementations must be safe
+ * for concurrent use. Leaving PDQHasher.Instrumentation nil disables all
+ * measurements.
+ */
+type Instrumentation interface {
+	// A pipeline stage finished successfully.
+	StageDone(stage Stage, elapsed time.Duration)
+	// An image was hashed; elapsed covers every stage from decoding on.
+	ImageHashed(quality int, elapsed time.Duration)
+	// An image could not be hashed.
+	HashFailed(stage Stage, reason string)
+}
+
+// HashError reports the stage at which hashing an image failed and why.
+type HashError struct {
+	Stage  Stage
+	Reason string
+	Err    error
+}
+
+func (e *HashError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HashError) Unwrap() error {
+	return e.Err
+}
+
+func decodeFailureReason(buf []byte) string {
+	if len(buf) == 0 {
+		return FAILURE_EMPTY
+	}
+	if vips.DetermineImageType(buf) == vips.ImageTypeUnknown {
+		return FAILURE_UNSUPPORTED_FORMAT
+	}
+	return FAILURE_CORRUPT
+}
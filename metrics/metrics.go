@@ -0,0 +1,149 @@
+// Package metrics exports PDQHasher and Index measurements to Prometheus.
+//
+//	collector := metrics.NewCollector("pdq")
+//	prometheus.MustRegister(collector)
+//	hasher.Instrumentation = collector
+//	idx.Instrumentation = collector
+package metrics
+
+import (
+	"time"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/MTRNord/pdqhash-go/index"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Quality is reported in steps of ten; upstream treats anything below 50
+// as unreliable.
+var QUALITY_BUCKETS = prometheus.LinearBuckets(10, 10, 10)
+
+// Stage timings range from microseconds (DCT) to seconds (decoding large
+// images).
+var STAGE_BUCKETS = prometheus.ExponentialBuckets(0.00005, 4, 10)
+
+var QUERY_BUCKETS = prometheus.ExponentialBuckets(0.00001, 4, 10)
+
+var MATCH_BUCKETS = []float64{0, 1, 2, 5, 10, 25, 100}
+
+// Collector implements both pdq.Instrumentation and index.Instrumentation
+// and can be registered with any prometheus.Registerer.
+type Collector struct {
+	imagesHashed   prometheus.Counter
+	hashFailures   *prometheus.CounterVec
+	hashDuration   prometheus.Histogram
+	stageDuration  *prometheus.HistogramVec
+	quality        prometheus.Histogram
+	queryDuration  prometheus.Histogram
+	queryMatches   prometheus.Histogram
+	queriesWithHit prometheus.Counter
+}
+
+var _ pdq.Instrumentation = (*Collector)(nil)
+var _ index.Instrumentation = (*Collector)(nil)
+var _ prometheus.Collector = (*Collector)(nil)
+
+func NewCollector(namespace string) *Collector {
+	c := &Collector{
+		imagesHashed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "images_hashed_total",
+			Help:      "Number of images hashed successfully.",
+		}),
+		hashFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "hash_failures_total",
+			Help:      "Number of images that could not be hashed, by failing stage and reason.",
+		}, []string{"stage", "reason"}),
+		hashDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "hash_duration_seconds",
+			Help:      "Time to hash an image, from decoding to the final bits.",
+			Buckets:   STAGE_BUCKETS,
+		}),
+		stageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "hash_stage_duration_seconds",
+			Help:      "Time spent in each stage of the hashing pipeline.",
+			Buckets:   STAGE_BUCKETS,
+		}, []string{"stage"}),
+		quality: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "hash_quality",
+			Help:      "Quality of computed hashes.",
+			Buckets:   QUALITY_BUCKETS,
+		}),
+		queryDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "index_query_duration_seconds",
+			Help:      "Time to answer an index query.",
+			Buckets:   QUERY_BUCKETS,
+		}),
+		queryMatches: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "index_query_matches",
+			Help:      "Number of matches returned per index query.",
+			Buckets:   MATCH_BUCKETS,
+		}),
+		queriesWithHit: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "index_query_hits_total",
+			Help:      "Number of index queries that returned at least one match.",
+		}),
+	}
+
+	// Export every stage label up front so dashboards see zeros rather
+	// than missing series.
+	for _, stage := range pdq.STAGES {
+		c.stageDuration.WithLabelValues(string(stage))
+	}
+
+	return c
+}
+
+func (c *Collector) StageDone(stage pdq.Stage, elapsed time.Duration) {
+	c.stageDuration.WithLabelValues(string(stage)).Observe(elapsed.Seconds())
+}
+
+func (c *Collector) ImageHashed(quality int, elapsed time.Duration) {
+	c.imagesHashed.Inc()
+	c.hashDuration.Observe(elapsed.Seconds())
+	c.quality.Observe(float64(quality))
+}
+
+func (c *Collector) HashFailed(stage pdq.Stage, reason string) {
+	c.hashFailures.WithLabelValues(string(stage), reason).Inc()
+}
+
+func (c *Collector) QueryDone(elapsed time.Duration, matches int) {
+	c.queryDuration.Observe(elapsed.Seconds())
+	c.queryMatches.Observe(float64(matches))
+	if matches > 0 {
+		c.queriesWithHit.Inc()
+	}
+}
+
+func (c *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.imagesHashed,
+		c.hashFailures,
+		c.hashDuration,
+		c.stageDuration,
+		c.quality,
+		c.queryDuration,
+		c.queryMatches,
+		c.queriesWithHit,
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors() {
+		collector.Describe(ch)
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range c.collectors() {
+		collector.Collect(ch)
+	}
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	collector := NewCollector("pdq")
+	registry := prometheus.NewPedanticRegistry()
+	assert.ErrorIs(t, registry.Register(collector), nil)
+
+	collector.StageDone(pdq.STAGE_DECODE, 20*time.Millisecond)
+	collector.StageDone(pdq.STAGE_DCT, 50*time.Microsecond)
+	collector.ImageHashed(87, 30*time.Millisecond)
+	collector.ImageHashed(12, 10*time.Millisecond)
+	collector.HashFailed(pdq.STAGE_DECODE, pdq.FAILURE_UNSUPPORTED_FORMAT)
+	collector.QueryDone(time.Millisecond, 0)
+	collector.QueryDone(time.Millisecond, 3)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(collector.imagesHashed))
+	assert.Equal(t, float64(1), testutil.ToFloat64(collector.hashFailures.WithLabelValues("decode", "unsupported_format")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(collector.queriesWithHit))
+
+	expected := `
+# HELP pdq_hash_quality Quality of computed hashes.
+# TYPE pdq_hash_quality histogram
+pdq_hash_quality_bucket{le="10"} 0
+pdq_hash_quality_bucket{le="20"} 1
+pdq_hash_quality_bucket{le="30"} 1
+pdq_hash_quality_bucket{le="40"} 1
+pdq_hash_quality_bucket{le="50"} 1
+pdq_hash_quality_bucket{le="60"} 1
+pdq_hash_quality_bucket{le="70"} 1
+pdq_hash_quality_bucket{le="80"} 1
+pdq_hash_quality_bucket{le="90"} 2
+pdq_hash_quality_bucket{le="100"} 2
+pdq_hash_quality_bucket{le="+Inf"} 2
+pdq_hash_quality_sum 99
+pdq_hash_quality_count 2
+`
+	assert.ErrorIs(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "pdq_hash_quality"), nil)
+
+	// One series per pipeline stage, even for stages not observed yet.
+	assert.Equal(t, len(pdq.STAGES), testutil.CollectAndCount(collector.stageDuration))
+	problems, err := testutil.CollectAndLint(collector)
+	assert.ErrorIs(t, err, nil)
+	assert.Empty(t, problems)
+}
@@ -0,0 +1,127 @@
+package pdq
+
+import (
+	"context"
+	"time"
+)
+
+/**
+ * Observer receives a span for every hash computed and, nested under it,
+ * one span per pipeline stage. Like Instrumentation it is called from the
+ * hashing goroutine and must be safe for concurrent use. See the otelpdq
+ * package for an OpenTelemetry implementation.
+ */
+type Observer interface {
+	// Called before decoding starts. The returned context is passed to
+	// StartStage for every stage of this hash.
+	StartHash(ctx context.Context) (context.Context, Span)
+	StartStage(ctx context.Context, stage Stage) Span
+}
+
+type Span interface {
+	// Called exactly once; err is nil if the hash or stage succeeded.
+	End(elapsed time.Duration, err error)
+}
+
+/**
+ * hashTrace follows one hash through the pipeline and fans out to the
+ * Instrumentation and Observer of the hasher. When neither is set it is
+ * nil, and all its methods return immediately without reading the clock
+ * or allocating.
+ */
+type hashTrace struct {
+	instrumentation Instrumentation
+	observer        Observer
+	ctx             context.Context
+	start           time.Time
+	span            Span
+}
+
+type stageTrace struct {
+	stage Stage
+	start time.Time
+	span  Span
+}
+
+func (p *PDQHasher) startHash(ctx context.Context) *hashTrace {
+	if p.Instrumentation == nil && p.Observer == nil {
+		return nil
+	}
+
+	t := &hashTrace{
+		instrumentation: p.Instrumentation,
+		observer:        p.Observer,
+		ctx:             ctx,
+		start:           time.Now(),
+	}
+	if t.observer != nil {
+		t.ctx, t.span = t.observer.StartHash(ctx)
+	}
+	return t
+}
+
+func (t *hashTrace) startStage(stage Stage) stageTrace {
+	if t == nil {
+		return stageTrace{}
+	}
+
+	s := stageTrace{stage: stage, start: time.Now()}
+	if t.observer != nil {
+		s.span = t.observer.StartStage(t.ctx, stage)
+	}
+	return s
+}
+
+func (t *hashTrace) endStage(s stageTrace) {
+	if t == nil {
+		return
+	}
+
+	elapsed := time.Since(s.start)
+	if t.instrumentation != nil {
+		t.instrumentation.StageDone(s.stage, elapsed)
+	}
+	if s.span != nil {
+		s.span.End(elapsed, nil)
+	}
+}
+
+// Ends both the stage and the hash with an error, and returns the error
+// as a *HashError.
+func (t *hashTrace) fail(s stageTrace, reason string, err error) error {
+	hashErr := &HashError{s.stage, reason, err}
+	if t == nil {
+		return hashErr
+	}
+
+	if t.instrumentation != nil {
+		t.instrumentation.HashFailed(s.stage, reason)
+	}
+	if s.span != nil {
+		s.span.End(time.Since(s.start), hashErr)
+	}
+	if t.span != nil {
+		t.span.End(time.Since(t.start), hashErr)
+	}
+	return hashErr
+}
+
+// Like fail, for work done outside the timed stages, such as copying an
+// image; the failure is counted under stage, which is not timed.
+func (t *hashTrace) failAt(stage Stage, reason string, err error) error {
+	return t.fail(stageTrace{stage: stage}, reason, err)
+}
+
+func (t *hashTrace) end(quality int) {
+	if t == nil {
+		return
+	}
+
+	elapsed := time.Since(t.start)
+	if t.instrumentation != nil {
+		t.instrumentation.ImageHashed(quality, elapsed)
+	}
+	if t.span != nil {
+		t.span.End(elapsed, nil)
+	}
+}
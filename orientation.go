@@ -0,0 +1,67 @@
+package pdq
+
+// How a PDQHasher treats the EXIF orientation of an image. Upstream hashes
+// the pixels as stored, so a portrait phone photo hashes as the sideways
+// image from the sensor; that hash is needed to match reference hashes,
+// while the hash of the image as displayed matches reposts, which have
+// the rotation applied.
+const ORIENTATION_IGNORE = "ignore"
+const ORIENTATION_APPLY = "apply"
+
+// Hashes the stored pixels, as upstream, and also the image as displayed.
+const ORIENTATION_BOTH = "both"
+
+var ORIENTATION_MODES = []string{ORIENTATION_IGNORE, ORIENTATION_APPLY, ORIENTATION_BOTH}
+
+// Whether an EXIF orientation rotates or flips the image; 1 is upright and
+// 0 means there is no tag.
+func orients(orientation int) bool {
+	return orientation >= 2 && orientation <= 8
+}
+
+// The width and height of an image as displayed.
+func orientedSize(width, height, orientation int) (int, int) {
+	if orientation >= 5 && orientation <= 8 {
+		return height, width
+	}
+	return width, height
+}
+
+/**
+ * Writes the numRows x numCols luma of the stored image to out as it is
+ * displayed under an EXIF orientation from 2 to 8, and returns the new
+ * dimensions. Orientations 5 to 8 swap rows and columns.
+ */
+func orientLuma[F lumaFloat](luma, out []F, numRows, numCols, orientation int) (int, int) {
+	w, h := numCols, numRows
+	// Maps a displayed pixel to the stored one.
+	var stored func(x, y int) (int, int)
+	switch orientation {
+	case 2: // mirrored
+		stored = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3: // rotated 180
+		stored = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4: // flipped upside down
+		stored = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5: // transposed
+		stored = func(x, y int) (int, int) { return y, x }
+	case 6: // rotated 90 clockwise
+		stored = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7: // transversed
+		stored = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8: // rotated 90 counterclockwise
+		stored = func(x, y int) (int, int) { return w - 1 - y, x }
+	default:
+		copy(out, luma[:numRows*numCols])
+		return numRows, numCols
+	}
+
+	cols, rows := orientedSize(numCols, numRows, orientation)
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			sx, sy := stored(x, y)
+			out[y*cols+x] = luma[sy*numCols+sx]
+		}
+	}
+	return rows, cols
+}
@@ -0,0 +1,61 @@
+// Package otelpdq reports PDQHasher spans to OpenTelemetry.
+//
+//	hasher.Observer = otelpdq.NewObserver(otel.GetTracerProvider())
+package otelpdq
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const TRACER_NAME = "github.com/MTRNord/pdqhash-go"
+
+const HASH_SPAN_NAME = "pdq.hash"
+
+// Attribute carrying the pipeline stage on stage spans.
+const STAGE_KEY = attribute.Key("pdq.stage")
+
+// Attribute carrying the failure reason of a *pdq.HashError.
+const FAILURE_REASON_KEY = attribute.Key("pdq.failure_reason")
+
+type Observer struct {
+	tracer trace.Tracer
+}
+
+var _ pdq.Observer = (*Observer)(nil)
+
+func NewObserver(provider trace.TracerProvider) *Observer {
+	return &Observer{tracer: provider.Tracer(TRACER_NAME)}
+}
+
+func (o *Observer) StartHash(ctx context.Context) (context.Context, pdq.Span) {
+	ctx, span := o.tracer.Start(ctx, HASH_SPAN_NAME)
+	return ctx, otelSpan{span}
+}
+
+func (o *Observer) StartStage(ctx context.Context, stage pdq.Stage) pdq.Span {
+	_, span := o.tracer.Start(ctx, HASH_SPAN_NAME+"."+string(stage), trace.WithAttributes(STAGE_KEY.String(string(stage))))
+	return otelSpan{span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) End(elapsed time.Duration, err error) {
+	if err != nil {
+		var hashErr *pdq.HashError
+		if errors.As(err, &hashErr) {
+			s.span.SetAttributes(FAILURE_REASON_KEY.String(hashErr.Reason))
+		}
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
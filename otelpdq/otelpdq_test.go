@@ -0,0 +1,42 @@
+package otelpdq
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestObserverNestsStageSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	observer := NewObserver(provider)
+
+	ctx, hashSpan := observer.StartHash(context.Background())
+	observer.StartStage(ctx, pdq.STAGE_DECODE).End(0, nil)
+	hashErr := &pdq.HashError{Stage: pdq.STAGE_THUMBNAIL, Reason: pdq.FAILURE_PROCESSING, Err: errors.New("error resizing image")}
+	observer.StartStage(ctx, pdq.STAGE_THUMBNAIL).End(0, hashErr)
+	hashSpan.End(0, hashErr)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+
+	decode, thumbnail, hash := spans[0], spans[1], spans[2]
+	assert.Equal(t, "pdq.hash.decode", decode.Name())
+	assert.Equal(t, "pdq.hash.thumbnail", thumbnail.Name())
+	assert.Equal(t, HASH_SPAN_NAME, hash.Name())
+
+	assert.Equal(t, hash.SpanContext().SpanID(), decode.Parent().SpanID())
+	assert.Equal(t, hash.SpanContext().SpanID(), thumbnail.Parent().SpanID())
+
+	assert.Equal(t, codes.Unset, decode.Status().Code)
+	assert.Equal(t, codes.Error, thumbnail.Status().Code)
+	assert.Contains(t, thumbnail.Attributes(), STAGE_KEY.String("thumbnail"))
+	assert.Contains(t, thumbnail.Attributes(), FAILURE_REASON_KEY.String(pdq.FAILURE_PROCESSING))
+	assert.Equal(t, codes.Error, hash.Status().Code)
+}
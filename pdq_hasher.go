@@ -3,7 +3,12 @@ package pdq
 //lint:file-ignore U1000 Ignore all unused code, it's pending tests
 
 import (
+	"context"
+	"fmt"
+	"image/color"
+	"io"
 	"log"
+	"os"
 
 	"math"
 
@@ -38,6 +43,10 @@ accumulate data from all 16x16.
 */
 const PDQ_JAROSZ_WINDOW_SIZE_DIVISOR = 128
 
+// Longest side images are shrunk to before hashing, for speed; PDQ hashes
+// are meant to be computed from images of about this size.
+const THUMBNAIL_SIZE = 512
+
 // Flags for which dihedral-transforms are desired to be produced.
 const PDQ_DO_DIH_ORIGINAL = 0x01
 const PDQ_DO_DIH_ROTATE_90 = 0x02
@@ -50,13 +59,51 @@ const PDQ_DO_DIH_FLIP_MINUS1 = 0x80
 const PDQ_DO_DIH_ALL = 0xFF
 
 /**
- * The only class state is the DCT matrix, so this class may either be
+ * The only class state is the DCT matrices (and the optional, concurrency-safe
+ * Instrumentation and Observer), so this class may either be
  * instantiated once per image, or instantiated once and used for all images;
- * the latter will be slightly faster as the DCT matrix will not need to be
+ * the latter will be slightly faster as the DCT matrices will not need to be
  * recomputed once per image.
  */
 type PDQHasher struct {
-	DCT_matrix [][]float64
+	// The 16x64 DCT matrix as rows. Hashing uses the flat copies below,
+	// row-major, and their 64x16 transposes.
+	DCT_matrix    [][]float64
+	dct           []float64
+	dctTransposed []float64
+	// The same in float32, for PRECISION_FLOAT32.
+	dct32           []float32
+	dct32Transposed []float32
+
+	// Optional; receives stage timings, qualities and failures.
+	Instrumentation Instrumentation
+	// Optional; receives a span per hash and per pipeline stage.
+	Observer Observer
+
+	// One of the QUALITY_POLICY_* policies, applied to hashes of quality
+	// below MinQuality. The default keeps every hash, as upstream does.
+	// Rejected images fail with a *HashError; FromFile, FromImage and
+	// DihedralFromFile exit on errors, so use the other functions with
+	// QUALITY_POLICY_REJECT.
+	QualityPolicy string
+	MinQuality    int
+
+	// Optional; trims uniform borders from the luma before filtering.
+	BorderTrim *BorderTrim
+
+	// One of the ALPHA_POLICY_* policies. The default ignores alpha, as
+	// upstream does; AlphaColor is the background of ALPHA_POLICY_COLOR.
+	AlphaPolicy string
+	AlphaColor  color.RGBA
+
+	// One of the ORIENTATION_* modes for the EXIF orientation. The
+	// default ignores it, as upstream does.
+	Orientation string
+
+	// One of the PRECISION_* precisions; the default is float64, as
+	// upstream. Applies to FromFile, FromBuffer, FromReader, FromGoImage
+	// and the Dihedral functions.
+	Precision string
 }
 
 /**
@@ -66,24 +113,79 @@ type PDQHasher struct {
 type HashAndQuality struct {
 	Hash    *types.Hash256
 	Quality int
+	// Dimensions of the decoded image, before thumbnailing.
+	Width  int
+	Height int
+	// With QUALITY_POLICY_FLAG, the LOW_QUALITY_* reason if Quality is
+	// below MinQuality.
+	LowQuality string
+	// With BorderTrim, the part of the image that was hashed, or nil if
+	// no border was found.
+	Crop *CropBox
+	// The EXIF orientation (1-8) of the image, or 0 if it has none. With
+	// ORIENTATION_APPLY, Hash, Width, Height and Crop are of the image as
+	// displayed.
+	Orientation int
+	// With ORIENTATION_BOTH, the hash of the image as displayed, while
+	// Hash stays that of the stored pixels. The two are the same when the
+	// orientation neither rotates nor flips the image.
+	DisplayedHash *types.Hash256
 }
 
+/**
+ * Hashes of the original image and of its seven dihedral transforms. Only
+ * the transforms requested via the PDQ_DO_DIH_* flags are set, the rest are
+ * nil.
+ */
 type HashesAndQuality struct {
-	hash           *types.Hash256
-	hashRotate90   *types.Hash256
-	hashRotate180  *types.Hash256
-	hashRotate270  *types.Hash256
-	hashFlipX      *types.Hash256
-	hashFlipY      *types.Hash256
-	hashFlipPlus1  *types.Hash256
-	hashFlipMinus1 *types.Hash256
-	quality        int
+	Hash           *types.Hash256
+	HashRotate90   *types.Hash256
+	HashRotate180  *types.Hash256
+	HashRotate270  *types.Hash256
+	HashFlipX      *types.Hash256
+	HashFlipY      *types.Hash256
+	HashFlipPlus1  *types.Hash256
+	HashFlipMinus1 *types.Hash256
+	Quality        int
+	// As in HashAndQuality. Only ORIENTATION_APPLY changes the hashes,
+	// since with ORIENTATION_BOTH one of them is of the image as
+	// displayed already.
+	LowQuality  string
+	Crop        *CropBox
+	Orientation int
+}
+
+// One hash of a HashesAndQuality, labelled with its transform.
+type DihedralHash struct {
+	Name string
+	Flag int
+	Hash *types.Hash256
+}
+
+// Transform names used in output formats and APIs, in PDQ_DO_DIH_* order.
+var DIHEDRAL_NAMES = []string{"original", "rotate90", "rotate180", "rotate270", "flipx", "flipy", "flipplus1", "flipminus1"}
+
+// Returns the computed hashes in PDQ_DO_DIH_* order, skipping the
+// transforms that were not requested.
+func (h *HashesAndQuality) Hashes() []DihedralHash {
+	all := []*types.Hash256{h.Hash, h.HashRotate90, h.HashRotate180, h.HashRotate270, h.HashFlipX, h.HashFlipY, h.HashFlipPlus1, h.HashFlipMinus1}
+
+	var hashes []DihedralHash
+	for i, hash := range all {
+		if hash != nil {
+			hashes = append(hashes, DihedralHash{DIHEDRAL_NAMES[i], 1 << i, hash})
+		}
+	}
+	return hashes
 }
 
 func NewPDQHasher() *PDQHasher {
-	return &PDQHasher{
+	p := &PDQHasher{
 		DCT_matrix: ComputeDCTMatrix(),
 	}
+	p.dct, p.dctTransposed = flattenDCTMatrix(p.DCT_matrix)
+	p.dct32, p.dct32Transposed = flattenDCTMatrix(computeDCTMatrix32())
+	return p
 }
 
 func ComputeDCTMatrix() [][]float64 {
@@ -99,6 +201,19 @@ func ComputeDCTMatrix() [][]float64 {
 	return d
 }
 
+// The 16x64 matrix d as a flat row-major array, and its 64x16 transpose.
+func flattenDCTMatrix[F lumaFloat](d [][]F) ([]F, []F) {
+	flat := make([]F, 16*64)
+	transposed := make([]F, 64*16)
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 64; j++ {
+			flat[i*64+j] = d[i][j]
+			transposed[j*16+i] = d[i][j]
+		}
+	}
+	return flat, transposed
+}
+
 func allocateMatrix(numRows, numCols int) [][]float64 {
 	// Create a slice of slices to represent the matrix
 	matrix := make([][]float64, numRows)
@@ -112,114 +227,428 @@ func allocateMatrix(numRows, numCols int) [][]float64 {
 }
 
 func (p *PDQHasher) FromFile(filename string) HashAndQuality {
+	t := p.startHash(context.Background())
+
+	image, err := p.loadImageFromFile(t, filename)
+	if err != nil {
+		log.Fatalf("Error opening file: %v", err)
+	}
+	defer image.Close()
+
+	hashAndQuality, err := p.fromLoadedImage(t, image)
+	if err != nil {
+		log.Fatal(err)
+	}
+	t.end(hashAndQuality.Quality)
+	return hashAndQuality
+}
+
+// FromBuffer hashes an encoded image held in memory. Unlike FromFile it
+// reports failures as a *HashError instead of exiting.
+func (p *PDQHasher) FromBuffer(buf []byte) (HashAndQuality, error) {
+	return p.FromBufferContext(context.Background(), buf)
+}
+
+// FromBufferContext is FromBuffer with a context that is handed to the
+// Observer, so that stage spans nest under the caller's span.
+func (p *PDQHasher) FromBufferContext(ctx context.Context, buf []byte) (HashAndQuality, error) {
+	t := p.startHash(ctx)
+
+	image, err := p.decodeImage(t, buf)
+	if err != nil {
+		return HashAndQuality{}, err
+	}
+	defer image.Close()
+
+	hashAndQuality, err := p.fromLoadedImage(t, image)
+	if err != nil {
+		return HashAndQuality{}, err
+	}
+	t.end(hashAndQuality.Quality)
+	return hashAndQuality, nil
+}
+
+// FromReader hashes an encoded image read to the end from r, such as an
+// archive entry or a request body.
+func (p *PDQHasher) FromReader(r io.Reader) (HashAndQuality, error) {
+	return p.FromReaderContext(context.Background(), r)
+}
+
+func (p *PDQHasher) FromReaderContext(ctx context.Context, r io.Reader) (HashAndQuality, error) {
+	t := p.startHash(ctx)
+
+	s := t.startStage(STAGE_DECODE)
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return HashAndQuality{}, t.fail(s, FAILURE_IO, err)
+	}
+	image, err := p.decodeStarted(t, s, buf)
+	if err != nil {
+		return HashAndQuality{}, err
+	}
+	defer image.Close()
+
+	hashAndQuality, err := p.fromLoadedImage(t, image)
+	if err != nil {
+		return HashAndQuality{}, err
+	}
+	t.end(hashAndQuality.Quality)
+	return hashAndQuality, nil
+}
+
+func (p *PDQHasher) loadImageFromFile(t *hashTrace, filename string) (*vips.ImageRef, error) {
+	s := t.startStage(STAGE_DECODE)
+
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, t.fail(s, FAILURE_IO, err)
+	}
+	return p.decodeStarted(t, s, buf)
+}
+
+func (p *PDQHasher) decodeImage(t *hashTrace, buf []byte) (*vips.ImageRef, error) {
+	return p.decodeStarted(t, t.startStage(STAGE_DECODE), buf)
+}
+
+func (p *PDQHasher) decodeStarted(t *hashTrace, s stageTrace, buf []byte) (*vips.ImageRef, error) {
 	params := vips.NewImportParams()
 	params.AutoRotate.Set(false)
 
-	image, err := vips.LoadImageFromFile(filename, params)
+	image, err := vips.LoadImageFromBuffer(buf, params)
 	if err != nil {
-		log.Fatalf("Error opening file: %v", err)
+		return nil, t.fail(s, decodeFailureReason(buf), fmt.Errorf("error decoding image: %w", err))
 	}
+	t.endStage(s)
+	return image, nil
+}
 
+func (p *PDQHasher) thumbnail(t *hashTrace, image *vips.ImageRef) (int, error) {
 	// resizing the image proportionally to max 512px width and max 512px height
-	err = image.ThumbnailWithSize(512, 512, vips.InterestingNone, vips.SizeDown)
+	return p.thumbnailTo(t, image, THUMBNAIL_SIZE)
+}
+
+// Returns the EXIF orientation of the image, whose tag is removed first:
+// vips would otherwise rotate the thumbnail by it, whatever the
+// PDQHasher.Orientation mode.
+func (p *PDQHasher) thumbnailTo(t *hashTrace, image *vips.ImageRef, size int) (int, error) {
+	s := t.startStage(STAGE_THUMBNAIL)
+
+	orientation := image.Orientation()
+	if orientation != 0 {
+		err := image.RemoveOrientation()
+		if err != nil {
+			return 0, t.fail(s, FAILURE_PROCESSING, fmt.Errorf("error removing orientation: %w", err))
+		}
+	}
+	err := image.ThumbnailWithSize(size, size, vips.InterestingNone, vips.SizeDown)
 	if err != nil {
-		log.Fatalf("Error resizing image: %v", err)
+		return 0, t.fail(s, FAILURE_PROCESSING, fmt.Errorf("error resizing image: %w", err))
+	}
+	t.endStage(s)
+	return orientation, nil
+}
+
+func (p *PDQHasher) fromLoadedImage(t *hashTrace, image *vips.ImageRef) (HashAndQuality, error) {
+	width := image.Width()
+	height := image.Height()
+
+	orientation, err := p.thumbnail(t, image)
+	if err != nil {
+		return HashAndQuality{}, err
+	}
+	if p.Precision == PRECISION_FLOAT32 {
+		return p.fromThumbnail32(t, image, width, height, orientation)
 	}
 	numCols := image.Width()
 	numRows := image.Height()
 
 	buffer1 := make([]float64, numCols*numRows)
 	buffer2 := make([]float64, numCols*numRows)
-	buffer64x64 := allocateMatrix(64, 64)
-	buffer16x64 := allocateMatrix(16, 64)
-	buffer16x16 := allocateMatrix(16, 16)
+	buffer64x64 := make([]float64, 64*64)
+	buffer16x64 := make([]float64, 16*64)
+	buffer16x16 := make([]float64, 16*16)
+
+	err = p.fillLuma(t, image, &buffer1)
+	if err != nil {
+		return HashAndQuality{}, err
+	}
+
+	// The filter overwrites the luma, so the displayed image is taken
+	// from it first.
+	var displayed []float64
+	var displayedRows, displayedCols int
+	if orients(orientation) {
+		switch p.Orientation {
+		case ORIENTATION_APPLY:
+			numRows, numCols = orientLuma(buffer1, buffer2, numRows, numCols, orientation)
+			buffer1, buffer2 = buffer2, buffer1
+			width, height = orientedSize(width, height, orientation)
+		case ORIENTATION_BOTH:
+			displayed = make([]float64, numCols*numRows)
+			displayedRows, displayedCols = orientLuma(buffer1, displayed, numRows, numCols, orientation)
+		}
+	}
 
-	return p.FromImage(image, buffer1, buffer2, buffer64x64, buffer16x64, buffer16x16)
+	hashAndQuality, err := p.pdqHash256FromFloatLuma(t, buffer1, buffer2, numRows, numCols, width, height, buffer64x64, buffer16x64, buffer16x16)
+	if err != nil {
+		return HashAndQuality{}, err
+	}
+	hashAndQuality.Width = width
+	hashAndQuality.Height = height
+	hashAndQuality.Crop = hashAndQuality.Crop.scale(numCols, numRows, width, height)
+	hashAndQuality.Orientation = orientation
+
+	if p.Orientation == ORIENTATION_BOTH {
+		hashAndQuality.DisplayedHash = hashAndQuality.Hash
+		if displayed != nil {
+			displayedWidth, displayedHeight := orientedSize(width, height, orientation)
+			displayedHash, err := p.pdqHash256FromFloatLuma(t, displayed, buffer2, displayedRows, displayedCols, displayedWidth, displayedHeight, buffer64x64, buffer16x64, buffer16x16)
+			if err != nil {
+				return HashAndQuality{}, err
+			}
+			hashAndQuality.DisplayedHash = displayedHash.Hash
+		}
+	}
+	return hashAndQuality, nil
 }
 
+// Hashes an image without thumbnailing it, into buffers of its size.
+// buffer64x64, buffer16x64 and buffer16x16 are no longer used, as the
+// pipeline keeps those matrices flat; they remain for compatibility.
 func (p *PDQHasher) FromImage(image *vips.ImageRef, buffer1, buffer2 []float64, buffer64x64, buffer16x64, buffer16x16 [][]float64) HashAndQuality {
+	t := p.startHash(context.Background())
 	numCols := image.Width()
 	numRows := image.Height()
 
-	p.fillFloatLumaFromBufferImage(image, &buffer1)
+	err := p.fillLuma(t, image, &buffer1)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	return p.pdqHash256FromFloatLuma(buffer1, buffer2, numRows, numCols, buffer64x64, buffer16x64, buffer16x16)
+	hashAndQuality, err := p.pdqHash256FromFloatLuma(t, buffer1, buffer2, numRows, numCols, numCols, numRows, make([]float64, 64*64), make([]float64, 16*64), make([]float64, 16*16))
+	if err != nil {
+		log.Fatal(err)
+	}
+	hashAndQuality.Width = numCols
+	hashAndQuality.Height = numRows
+	t.end(hashAndQuality.Quality)
+	return hashAndQuality
 }
 
-func (p *PDQHasher) fillFloatLumaFromBufferImage(image *vips.ImageRef, luma *[]float64) {
+func (p *PDQHasher) fillLuma(t *hashTrace, image *vips.ImageRef, luma *[]float64) error {
+	s := t.startStage(STAGE_LUMA)
+
+	err := p.fillFloatLumaFromBufferImage(image, luma)
+	if err != nil {
+		return t.fail(s, FAILURE_PROCESSING, err)
+	}
+	t.endStage(s)
+	return nil
+}
+
+func (p *PDQHasher) fillFloatLumaFromBufferImage(image *vips.ImageRef, luma *[]float64) error {
+	return fillLumaFromImage(p, image, *luma)
+}
+
+// fillFloatLumaFromBufferImage for luma of either precision.
+func fillLumaFromImage[F lumaFloat](p *PDQHasher, image *vips.ImageRef, luma []F) error {
 	numCols := image.Width()
 	numRows := image.Height()
 
-	err := image.ToColorSpace(vips.InterpretationSRGB)
+	err := toLumaColorSpace(image)
 	if err != nil {
-		log.Fatalf("Error converting to RGB: %v", err)
+		return err
 	}
-
-	for i := 0; i < numRows; i++ {
-		for j := 0; j < numCols; j++ {
-			colorArray, err := image.GetPoint(j, i)
-			if err != nil {
-				log.Fatalf("Error getting pixel: %v", err)
-			}
-			r := colorArray[0]
-			g := colorArray[1]
-			b := colorArray[2]
-			(*luma)[i*numCols+j] = LUMA_FROM_R_COEFF*float64(r) + LUMA_FROM_G_COEFF*float64(g) + LUMA_FROM_B_COEFF*float64(b)
+	sample, err := readSamples(image)
+	if err != nil {
+		return err
+	}
+	bands := image.Bands()
+	grey := colorBands(image) < 3
+	// vips keeps alpha straight outside of resampling, which
+	// unpremultiplies when done.
+	background, composite := p.alphaBackground()
+	composite = composite && image.HasAlpha()
+
+	for i := 0; i < numRows*numCols; i++ {
+		k := i * bands
+		r := sample(k)
+		g, b := r, r
+		if !grey {
+			g = sample(k + 1)
+			b = sample(k + 2)
+		}
+		// Grey passes through the coefficients, which sum to one, so
+		// that it hashes exactly as when it was converted to sRGB.
+		if composite {
+			luma[i] = F(compositeLuma(r, g, b, sample(k+bands-1), background))
+			continue
 		}
+		luma[i] = F(LUMA_FROM_R_COEFF*r + LUMA_FROM_G_COEFF*g + LUMA_FROM_B_COEFF*b)
+	}
+	return nil
+}
+
+// width and height are those of the image the numRows x numCols luma was
+// shrunk from.
+func (p *PDQHasher) pdqHash256FromFloatLuma(t *hashTrace, fullBuffer1, fullBuffer2 []float64, numRows, numCols, width, height int, buffer64x64, buffer16x64, buffer16x16 []float64) (HashAndQuality, error) {
+	quality, lowQuality, crop, err := p.filterAndDecimate(t, fullBuffer1, fullBuffer2, numRows, numCols, width, height, buffer64x64)
+	if err != nil {
+		return HashAndQuality{}, err
 	}
+
+	s := t.startStage(STAGE_DCT)
+	p.dct64To16(buffer64x64, buffer16x64, buffer16x16)
+	hash := p.pdqBuffer16x16ToBits(buffer16x16)
+	t.endStage(s)
+
+	return HashAndQuality{Hash: hash, Quality: quality, LowQuality: lowQuality, Crop: crop}, nil
 }
 
-func (p *PDQHasher) pdqHash256FromFloatLuma(fullBuffer1, fullBuffer2 []float64, numRows, numCols int, buffer64x64, buffer16x64, buffer16x16 [][]float64) HashAndQuality {
+// Runs the filter stage, which trims borders, ends with the quality
+// metric, and applies the quality policy. The crop box is in luma pixels.
+func (p *PDQHasher) filterAndDecimate(t *hashTrace, fullBuffer1, fullBuffer2 []float64, numRows, numCols, width, height int, buffer64x64 []float64) (int, string, *CropBox, error) {
+	s := t.startStage(STAGE_FILTER)
+	lumaRows, lumaCols := numRows, numCols
+	numRows, numCols, crop, err := p.trimBorders(fullBuffer1, numRows, numCols)
+	if err != nil {
+		return 0, "", nil, t.fail(s, FAILURE_PROCESSING, err)
+	}
 	windowSizeAlongRows := p.computeJaroszWindowSize(numCols)
 	windowSizeAlongCols := p.computeJaroszWindowSize(numRows)
-	p.jaroszFilterFloat(&fullBuffer1, &fullBuffer2, numRows, numCols, windowSizeAlongRows, windowSizeAlongCols, PDQ_NUM_JAROSZ_XY_PASSES)
+	jaroszFilterFloat(fullBuffer1, fullBuffer2, numRows, numCols, windowSizeAlongRows, windowSizeAlongCols, PDQ_NUM_JAROSZ_XY_PASSES)
 
-	p.decimateFloat(&fullBuffer1, numRows, numCols, &buffer64x64)
+	decimateFloat(fullBuffer1, numRows, numCols, buffer64x64)
 	quality := p.computePDQImageDomainQualityMetric(buffer64x64)
-	p.dct64To16(&buffer64x64, &buffer16x64, &buffer16x16)
-	hash := p.pdqBuffer16x16ToBits(buffer16x16)
 
-	return HashAndQuality{hash, quality}
+	lowQuality := p.lowQuality(quality, numRows*height/lumaRows, numCols*width/lumaCols, buffer64x64)
+	if lowQuality != "" && p.QualityPolicy == QUALITY_POLICY_REJECT {
+		return quality, lowQuality, crop, t.fail(s, FAILURE_LOW_QUALITY, &LowQualityError{quality, p.MinQuality, lowQuality})
+	}
+	t.endStage(s)
+	return quality, lowQuality, crop, nil
 }
 
+// DihedralFromFile hashes an image file and its rotations and flips at
+// full size, without the thumbnail FromFile uses.
 func (p *PDQHasher) DihedralFromFile(filename string, dihedralFlags int) HashesAndQuality {
-	image, err := vips.NewImageFromFile(filename)
+	t := p.startHash(context.Background())
+
+	image, err := p.loadImageFromFile(t, filename)
 	if err != nil {
 		log.Fatalf("Error opening file: %v", err)
 	}
+	defer image.Close()
+
+	hashesAndQuality, err := p.dihedralFromLoadedImage(t, image, image.Width(), image.Height(), image.Orientation(), dihedralFlags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	t.end(hashesAndQuality.Quality)
+	return hashesAndQuality
+}
+
+// DihedralFromBuffer hashes an encoded image held in memory and its
+// rotations and flips, reporting failures instead of exiting. Unlike
+// DihedralFromFile, which hashes at full size, it downsizes the image the
+// same way as FromBuffer, so its original hash matches FromBuffer's and
+// may differ from DihedralFromFile's by a few bits.
+func (p *PDQHasher) DihedralFromBuffer(buf []byte, dihedralFlags int) (HashesAndQuality, error) {
+	return p.DihedralFromBufferContext(context.Background(), buf, dihedralFlags)
+}
+
+func (p *PDQHasher) DihedralFromBufferContext(ctx context.Context, buf []byte, dihedralFlags int) (HashesAndQuality, error) {
+	t := p.startHash(ctx)
+
+	image, err := p.decodeImage(t, buf)
+	if err != nil {
+		return HashesAndQuality{}, err
+	}
+	defer image.Close()
+
+	width := image.Width()
+	height := image.Height()
+	orientation, err := p.thumbnail(t, image)
+	if err != nil {
+		return HashesAndQuality{}, err
+	}
+
+	hashesAndQuality, err := p.dihedralFromLoadedImage(t, image, width, height, orientation, dihedralFlags)
+	if err != nil {
+		return HashesAndQuality{}, err
+	}
+	applied := 0
+	if p.Orientation == ORIENTATION_APPLY {
+		applied = hashesAndQuality.Orientation
+	}
+	numCols, numRows := orientedSize(image.Width(), image.Height(), applied)
+	width, height = orientedSize(width, height, applied)
+	hashesAndQuality.Crop = hashesAndQuality.Crop.scale(numCols, numRows, width, height)
+	t.end(hashesAndQuality.Quality)
+	return hashesAndQuality, nil
+}
 
+// width and height are those of the image before it was thumbnailed.
+func (p *PDQHasher) dihedralFromLoadedImage(t *hashTrace, image *vips.ImageRef, width, height, orientation, dihedralFlags int) (HashesAndQuality, error) {
+	if p.Precision == PRECISION_FLOAT32 {
+		return p.dihedralFromLoadedImage32(t, image, width, height, orientation, dihedralFlags)
+	}
 	numRows := image.Height()
 	numCols := image.Width()
 
 	buffer1 := make([]float64, numCols*numRows)
 	buffer2 := make([]float64, numCols*numRows)
 
-	buffer64x64 := allocateMatrix(64, 64)
-	buffer16x64 := allocateMatrix(16, 64)
-	buffer16x16 := allocateMatrix(16, 16)
-	buffer16x16Aux := allocateMatrix(16, 16)
+	buffer64x64 := make([]float64, 64*64)
+	buffer16x64 := make([]float64, 16*64)
+	buffer16x16 := make([]float64, 16*16)
+	buffer16x16Aux := make([]float64, 16*16)
 
-	return p.dihedralFromBufferedImage(image, buffer1, buffer2, buffer64x64, buffer16x64, buffer16x16, buffer16x16Aux, dihedralFlags)
+	return p.dihedralFromBufferedImage(t, image, buffer1, buffer2, buffer64x64, buffer16x64, buffer16x16, buffer16x16Aux, width, height, orientation, dihedralFlags)
 }
 
-func (p *PDQHasher) dihedralFromBufferedImage(image *vips.ImageRef, buffer1, buffer2 []float64, buffer64x64, buffer16x64, buffer16x16, buffer16x16Aux [][]float64, dihedralFlags int) HashesAndQuality {
+func (p *PDQHasher) dihedralFromBufferedImage(t *hashTrace, image *vips.ImageRef, buffer1, buffer2 []float64, buffer64x64, buffer16x64, buffer16x16, buffer16x16Aux []float64, width, height, orientation, dihedralFlags int) (HashesAndQuality, error) {
 	numRows := image.Height()
 	numCols := image.Width()
 
-	p.fillFloatLumaFromBufferImage(image, &buffer1)
+	err := p.fillLuma(t, image, &buffer1)
+	if err != nil {
+		return HashesAndQuality{}, err
+	}
+	if p.Orientation == ORIENTATION_APPLY && orients(orientation) {
+		numRows, numCols = orientLuma(buffer1, buffer2, numRows, numCols, orientation)
+		buffer1, buffer2 = buffer2, buffer1
+		width, height = orientedSize(width, height, orientation)
+	}
 
-	return p.pdqHash256esFromFloatLuma(buffer1, buffer2, numRows, numCols, buffer64x64, buffer16x64, buffer16x16, buffer16x16Aux, dihedralFlags)
+	hashesAndQuality, err := p.pdqHash256esFromFloatLuma(t, buffer1, buffer2, numRows, numCols, width, height, buffer64x64, buffer16x64, buffer16x16, buffer16x16Aux, dihedralFlags)
+	if err != nil {
+		return HashesAndQuality{}, err
+	}
+	hashesAndQuality.Orientation = orientation
+	return hashesAndQuality, nil
 }
 
-func (p *PDQHasher) pdqHash256esFromFloatLuma(fullBuffer1, fullBuffer2 []float64, numRows, numCols int, buffer64x64, buffer16x64, buffer16x16, buffer16x16Aux [][]float64, dihedralFlags int) HashesAndQuality {
-	windowSizeAlongRows := p.computeJaroszWindowSize(numCols)
-	windowSizeAlongCols := p.computeJaroszWindowSize(numRows)
-	p.jaroszFilterFloat(&fullBuffer1, &fullBuffer2, numRows, numCols, windowSizeAlongRows, windowSizeAlongCols, PDQ_NUM_JAROSZ_XY_PASSES)
+func (p *PDQHasher) pdqHash256esFromFloatLuma(t *hashTrace, fullBuffer1, fullBuffer2 []float64, numRows, numCols, width, height int, buffer64x64, buffer16x64, buffer16x16, buffer16x16Aux []float64, dihedralFlags int) (HashesAndQuality, error) {
+	quality, lowQuality, crop, err := p.filterAndDecimate(t, fullBuffer1, fullBuffer2, numRows, numCols, width, height, buffer64x64)
+	if err != nil {
+		return HashesAndQuality{}, err
+	}
 
-	p.decimateFloat(&fullBuffer1, numRows, numCols, &buffer64x64)
-	quality := p.computePDQImageDomainQualityMetric(buffer64x64)
-	p.dct64To16(&buffer64x64, &buffer16x64, &buffer16x16)
+	s := t.startStage(STAGE_DCT)
+	p.dct64To16(buffer64x64, buffer16x64, buffer16x16)
+	hashesAndQuality := p.dihedralHashes(buffer16x16, buffer16x16Aux, dihedralFlags)
+	t.endStage(s)
 
+	hashesAndQuality.Quality = quality
+	hashesAndQuality.LowQuality = lowQuality
+	hashesAndQuality.Crop = crop
+	return hashesAndQuality, nil
+}
+
+// The hashes of the transforms in dihedralFlags, from the DCT of the
+// original.
+func (p *PDQHasher) dihedralHashes(buffer16x16, buffer16x16Aux []float64, dihedralFlags int) HashesAndQuality {
 	var hash *types.Hash256
 	var hashRotate90 *types.Hash256
 	var hashRotate180 *types.Hash256
@@ -234,50 +663,61 @@ func (p *PDQHasher) pdqHash256esFromFloatLuma(fullBuffer1, fullBuffer2 []float64
 	}
 
 	if dihedralFlags&PDQ_DO_DIH_ROTATE_90 != 0 {
-		p.dct16OriginalToRotate90(&buffer16x16, &buffer16x16Aux)
+		p.dct16OriginalToRotate90(buffer16x16, buffer16x16Aux)
 		hashRotate90 = p.pdqBuffer16x16ToBits(buffer16x16Aux)
 	}
 
 	if dihedralFlags&PDQ_DO_DIH_ROTATE_180 != 0 {
-		p.dct16OriginalToRotate180(&buffer16x16, &buffer16x16Aux)
+		p.dct16OriginalToRotate180(buffer16x16, buffer16x16Aux)
 		hashRotate180 = p.pdqBuffer16x16ToBits(buffer16x16Aux)
 	}
 
 	if dihedralFlags&PDQ_DO_DIH_ROTATE_270 != 0 {
-		p.dct16OriginalToRotate270(&buffer16x16, &buffer16x16Aux)
+		p.dct16OriginalToRotate270(buffer16x16, buffer16x16Aux)
 		hashRotate270 = p.pdqBuffer16x16ToBits(buffer16x16Aux)
 	}
 
 	if dihedralFlags&PDQ_DO_DIH_FLIPX != 0 {
-		p.dct16OriginalToFlipX(&buffer16x16, &buffer16x16Aux)
+		p.dct16OriginalToFlipX(buffer16x16, buffer16x16Aux)
 		hashFlipX = p.pdqBuffer16x16ToBits(buffer16x16Aux)
 	}
 
 	if dihedralFlags&PDQ_DO_DIH_FLIPY != 0 {
-		p.dct16OriginalToFlipY(&buffer16x16, &buffer16x16Aux)
+		p.dct16OriginalToFlipY(buffer16x16, buffer16x16Aux)
 		hashFlipY = p.pdqBuffer16x16ToBits(buffer16x16Aux)
 	}
 
 	if dihedralFlags&PDQ_DO_DIH_FLIP_PLUS1 != 0 {
-		p.dct16OriginalToFlipPlus1(&buffer16x16, &buffer16x16Aux)
+		p.dct16OriginalToFlipPlus1(buffer16x16, buffer16x16Aux)
 		hashFlipPlus1 = p.pdqBuffer16x16ToBits(buffer16x16Aux)
 	}
 
 	if dihedralFlags&PDQ_DO_DIH_FLIP_MINUS1 != 0 {
-		p.dct16OriginalToFlipMinus1(&buffer16x16, &buffer16x16Aux)
+		p.dct16OriginalToFlipMinus1(buffer16x16, buffer16x16Aux)
 		hashFlipMinus1 = p.pdqBuffer16x16ToBits(buffer16x16Aux)
 	}
 
-	return HashesAndQuality{hash, hashRotate90, hashRotate180, hashRotate270, hashFlipX, hashFlipY, hashFlipPlus1, hashFlipMinus1, quality}
+	return HashesAndQuality{
+		Hash:           hash,
+		HashRotate90:   hashRotate90,
+		HashRotate180:  hashRotate180,
+		HashRotate270:  hashRotate270,
+		HashFlipX:      hashFlipX,
+		HashFlipY:      hashFlipY,
+		HashFlipPlus1:  hashFlipPlus1,
+		HashFlipMinus1: hashFlipMinus1,
+	}
 }
 
-// numRows x numCols in row-major order
-func (p *PDQHasher) decimateFloat(in *[]float64, inNumRows, inNumCols int, out *[][]float64) {
+// numRows x numCols in row-major order, into a flat 64x64 out.
+func decimateFloat[F lumaFloat](in []F, inNumRows, inNumCols int, out []F) {
 	for i := 0; i < 64; i++ {
 		ini := int(((float64(i) + 0.5) * float64(inNumRows)) / 64.0)
-		for j := 0; j < 64; j++ {
+		row := in[ini*inNumCols : (ini+1)*inNumCols]
+		outRow := out[i*64 : (i+1)*64]
+		for j := range outRow {
 			inj := int(((float64(j) + 0.5) * float64(inNumCols)) / 64.0)
-			(*out)[i][j] = (*in)[ini*inNumCols+inj]
+			outRow[j] = row[inj]
 		}
 	}
 }
@@ -288,20 +728,20 @@ func (p *PDQHasher) decimateFloat(in *[]float64, inNumRows, inNumCols int, out *
  * some of many small ones. The constants are all manually selected, and
  * tuned as described in the document.
  */
-func (p *PDQHasher) computePDQImageDomainQualityMetric(buffer64x64 [][]float64) int {
+func (p *PDQHasher) computePDQImageDomainQualityMetric(buffer64x64 []float64) int {
 	gradientSum := 0
 	for i := 0; i < 63; i++ {
 		for j := 0; j < 64; j++ {
-			u := buffer64x64[i][j]
-			v := buffer64x64[i+1][j]
+			u := buffer64x64[i*64+j]
+			v := buffer64x64[(i+1)*64+j]
 			d := int(((u - v) * 100.0) / 255.0)
 			gradientSum += int(helpers.Abs(d))
 		}
 	}
 	for i := 0; i < 64; i++ {
 		for j := 0; j < 63; j++ {
-			u := buffer64x64[i][j]
-			v := buffer64x64[i][j+1]
+			u := buffer64x64[i*64+j]
+			v := buffer64x64[i*64+j+1]
 			d := int(((u - v) * 100.0) / 255.0)
 			gradientSum += int(helpers.Abs(d))
 		}
@@ -321,31 +761,40 @@ func (p *PDQHasher) computePDQImageDomainQualityMetric(buffer64x64 [][]float64)
  *    extracting slots 1-16 of the output, was actually slower than the
  *    current implementation which is completely non-clever/non-Lee but
  *    computes only what is needed.
+ *
+ * B = D A D' on flat matrices: A is 64x64, T 16x64 and B 16x16. Using the
+ * transpose of D lets both products run along rows.
  */
-func (p *PDQHasher) dct64To16(A, T, B *([][]float64)) {
-	D := p.DCT_matrix
-
-	*T = make([][]float64, 16)
-	for i := 0; i < 16; i++ {
-		ti := make([]float64, 64)
+func (p *PDQHasher) dct64To16(A, T, B []float64) {
+	multiplyMatrices(p.dct, A, T, 16, 64, 64)
+	multiplyMatrices(T, p.dctTransposed, B, 16, 64, 16)
+}
 
-		for j := 0; j < 64; j++ {
-			tij := float64(0.0)
-			for k := 0; k < 64; k++ {
-				tij += D[i][k] * (*A)[k][j]
+/**
+ * out = a b, with a n x m, b m x k and out n x k, all flat row-major.
+ * Looping over m before k reads b and writes out along rows; each output
+ * still adds its products in order of m from zero, so results are the
+ * same to the bit as those of the textbook loop. The inner loop is
+ * unrolled by four, which leaves that order alone too.
+ */
+func multiplyMatrices[F lumaFloat](a, b, out []F, n, m, k int) {
+	for i := 0; i < n; i++ {
+		row := out[i*k : (i+1)*k]
+		clear(row)
+		for l := 0; l < m; l++ {
+			ail := a[i*m+l]
+			bl := b[l*k : (l+1)*k]
+			bl = bl[:len(row)]
+			j := 0
+			for ; j+4 <= len(row); j += 4 {
+				row[j] += ail * bl[j]
+				row[j+1] += ail * bl[j+1]
+				row[j+2] += ail * bl[j+2]
+				row[j+3] += ail * bl[j+3]
 			}
-			ti[j] = tij
-		}
-		(*T)[i] = ti
-	}
-
-	for i := 0; i < 16; i++ {
-		for j := 0; j < 16; j++ {
-			sumk := float64(0.0)
-			for k := 0; k < 64; k++ {
-				sumk += (*T)[i][k] * D[j][k]
+			for ; j < len(row); j++ {
+				row[j] += ail * bl[j]
 			}
-			(*B)[i][j] = sumk
 		}
 	}
 }
@@ -368,81 +817,81 @@ func (p *PDQHasher) dct64To16(A, T, B *([][]float64)) {
    -------------------------------------
 */
 
-func (p *PDQHasher) dct16OriginalToRotate90(A, B *[][]float64) {
+func (p *PDQHasher) dct16OriginalToRotate90(A, B []float64) {
 	for i := 0; i < 16; i++ {
 		for j := 0; j < 16; j++ {
 			if (j & 1) != 0 {
-				(*B)[j][i] = (*A)[i][j]
+				B[j*16+i] = A[i*16+j]
 			} else {
-				(*B)[j][i] = -(*A)[i][j]
+				B[j*16+i] = -A[i*16+j]
 			}
 		}
 	}
 }
 
-func (p *PDQHasher) dct16OriginalToRotate180(A, B *[][]float64) {
+func (p *PDQHasher) dct16OriginalToRotate180(A, B []float64) {
 	for i := 0; i < 16; i++ {
 		for j := 0; j < 16; j++ {
 			if ((i + j) & 1) != 0 {
-				(*B)[i][j] = -(*A)[i][j]
+				B[i*16+j] = -A[i*16+j]
 			} else {
-				(*B)[i][j] = (*A)[i][j]
+				B[i*16+j] = A[i*16+j]
 			}
 		}
 	}
 }
 
-func (p *PDQHasher) dct16OriginalToRotate270(A, B *[][]float64) {
+func (p *PDQHasher) dct16OriginalToRotate270(A, B []float64) {
 	for i := 0; i < 16; i++ {
 		for j := 0; j < 16; j++ {
 			if (i & 1) != 0 {
-				(*B)[j][i] = (*A)[i][j]
+				B[j*16+i] = A[i*16+j]
 			} else {
-				(*B)[j][i] = -(*A)[i][j]
+				B[j*16+i] = -A[i*16+j]
 			}
 		}
 	}
 }
 
-func (p *PDQHasher) dct16OriginalToFlipX(A, B *[][]float64) {
+func (p *PDQHasher) dct16OriginalToFlipX(A, B []float64) {
 	for i := 0; i < 16; i++ {
 		for j := 0; j < 16; j++ {
 			if (i & 1) != 0 {
-				(*B)[i][j] = (*A)[i][j]
+				B[i*16+j] = A[i*16+j]
 			} else {
-				(*B)[i][j] = -(*A)[i][j]
+				B[i*16+j] = -A[i*16+j]
 			}
 		}
 	}
 }
 
-func (p *PDQHasher) dct16OriginalToFlipY(A, B *[][]float64) {
+func (p *PDQHasher) dct16OriginalToFlipY(A, B []float64) {
 	for i := 0; i < 16; i++ {
 		for j := 0; j < 16; j++ {
 			if (j & 1) != 0 {
-				(*B)[i][j] = (*A)[i][j]
+				B[i*16+j] = A[i*16+j]
 			} else {
-				(*B)[i][j] = -(*A)[i][j]
+				B[i*16+j] = -A[i*16+j]
 			}
 		}
 	}
 }
 
-func (p *PDQHasher) dct16OriginalToFlipPlus1(A, B *[][]float64) {
+func (p *PDQHasher) dct16OriginalToFlipPlus1(A, B []float64) {
 	for i := 0; i < 16; i++ {
 		for j := 0; j < 16; j++ {
-			(*B)[j][i] = (*A)[i][j]
+			B[j*16+i] = A[i*16+j]
 		}
 	}
 }
 
-func (p *PDQHasher) dct16OriginalToFlipMinus1(A, B *[][]float64) {
+func (p *PDQHasher) dct16OriginalToFlipMinus1(A, B []float64) {
 	for i := 0; i < 16; i++ {
 		for j := 0; j < 16; j++ {
 			if ((i + j) & 1) != 0 {
-				(*B)[j][i] = -(*A)[i][j]
+				B[j*16+i] = -A[i*16+j]
 			} else {
-				(*B)[j][i] = (*A)[i][j]
+				B[j*16+i] = A[i*16+j]
 			}
 		}
 	}
@@ -452,14 +901,12 @@ func (p *PDQHasher) dct16OriginalToFlipMinus1(A, B *[][]float64) {
  * Each bit of the 16x16 output hash is for whether the given frequency
  * component is greater than the median frequency component or not.
  */
-func (p *PDQHasher) pdqBuffer16x16ToBits(dctOutput16x16 [][]float64) *types.Hash256 {
+func (p *PDQHasher) pdqBuffer16x16ToBits(dctOutput16x16 []float64) *types.Hash256 {
 	hash := types.Hash256{}
-	dctMedian := helpers.Torben(dctOutput16x16, 16, 16)
-	for i := 0; i < 16; i++ {
-		for j := 0; j < 16; j++ {
-			if dctOutput16x16[i][j] > dctMedian {
-				hash.SetBit(i*16 + j)
-			}
+	dctMedian := helpers.TorbenFlat(dctOutput16x16)
+	for i, v := range dctOutput16x16 {
+		if v > dctMedian {
+			hash.SetBit(i)
 		}
 	}
 	return &hash
@@ -471,58 +918,61 @@ func (p *PDQHasher) computeJaroszWindowSize(dimension int) int {
 	return int(result)
 }
 
-func (p *PDQHasher) jaroszFilterFloat(buffer1, buffer2 *[]float64, numRows, numCols, windowSizeAlongRows, windowSizeAlongCols, nreps int) {
+func jaroszFilterFloat[F lumaFloat](buffer1, buffer2 []F, numRows, numCols, windowSizeAlongRows, windowSizeAlongCols, nreps int) {
 	for i := 0; i < nreps; i++ {
-		p.boxAlongRowsFloat(buffer1, buffer2, numRows, numCols, windowSizeAlongRows)
-		p.boxAlongColsFloat(buffer2, buffer1, numRows, numCols, windowSizeAlongCols)
+		boxAlongRowsFloat(buffer1, buffer2, numRows, numCols, windowSizeAlongRows)
+		boxAlongColsFloat(buffer2, buffer1, numRows, numCols, windowSizeAlongCols)
 	}
 }
 
-func (p *PDQHasher) box1DFloat(invec *[]float64, inStartOffset int, outvec *[]float64, outStartOffset, vectorLength, stride, fullWindowSize int) {
-	halfWindowSize := int(((float64(fullWindowSize) + 2.0) / 2.0))
-	phase_1_nreps := int(halfWindowSize - 1)
-	phase_2_nreps := int(fullWindowSize - halfWindowSize + 1)
-	phase_3_nreps := int(vectorLength - fullWindowSize)
-	phase_4_nreps := int(halfWindowSize - 1)
+// The phases of a 1-D box filter of fullWindowSize over vectorLength
+// values: the window grows from half its size, slides, then shrinks.
+func boxPhases(vectorLength, fullWindowSize int) (int, int, int, int) {
+	halfWindowSize := (fullWindowSize + 2) / 2
+	return halfWindowSize - 1, fullWindowSize - halfWindowSize + 1, vectorLength - fullWindowSize, halfWindowSize - 1
+}
+
+func box1DFloat[F lumaFloat](invec, outvec []F, fullWindowSize int) {
+	phase_1_nreps, phase_2_nreps, phase_3_nreps, phase_4_nreps := boxPhases(len(invec), fullWindowSize)
 	li := 0 // Index of left edge of read window, for subtracts
 	ri := 0 // Index of right edge of read windows, for adds
 	oi := 0 // Index of output vector
-	sum := float64(0.0)
+	sum := F(0.0)
 	currentWindowSize := 0
 
 	// PHASE 1: ACCUMULATE FIRST SUM NO WRITES
 	for i := 0; i < phase_1_nreps; i++ {
-		sum += (*invec)[inStartOffset+ri]
+		sum += invec[ri]
 		currentWindowSize += 1
-		ri += stride
+		ri += 1
 	}
 
 	// PHASE 2: INITIAL WRITES WITH SMALL WINDOW
 	for i := 0; i < phase_2_nreps; i++ {
-		sum += (*invec)[inStartOffset+ri]
+		sum += invec[ri]
 		currentWindowSize += 1
-		(*outvec)[outStartOffset+oi] = sum / float64(currentWindowSize)
-		ri += stride
-		oi += stride
+		outvec[oi] = sum / F(currentWindowSize)
+		ri += 1
+		oi += 1
 	}
 
 	// PHASE 3: WRITES WITH FULL WINDOW
 	for i := 0; i < phase_3_nreps; i++ {
-		sum += (*invec)[inStartOffset+ri]
-		sum -= (*invec)[inStartOffset+li]
-		(*outvec)[outStartOffset+oi] = sum / float64(currentWindowSize)
-		li += stride
-		ri += stride
-		oi += stride
+		sum += invec[ri]
+		sum -= invec[li]
+		outvec[oi] = sum / F(currentWindowSize)
+		li += 1
+		ri += 1
+		oi += 1
 	}
 
 	// PHASE 4: FINAL WRITES WITH SMALL WINDOW
 	for i := 0; i < phase_4_nreps; i++ {
-		sum -= (*invec)[inStartOffset+li]
+		sum -= invec[li]
 		currentWindowSize -= 1
-		(*outvec)[outStartOffset+oi] = sum / float64(currentWindowSize)
-		li += stride
-		oi += stride
+		outvec[oi] = sum / F(currentWindowSize)
+		li += 1
+		oi += 1
 	}
 }
 
@@ -530,14 +980,76 @@ func (p *PDQHasher) box1DFloat(invec *[]float64, inStartOffset int, outvec *[]fl
  * input - matrix as numRows x numCols in row-major order
  * output - matrix as numRows x numCols in row-major order
  */
-func (p *PDQHasher) boxAlongRowsFloat(input, output *[]float64, numRows, numCols, windowSize int) {
+func boxAlongRowsFloat[F lumaFloat](input, output []F, numRows, numCols, windowSize int) {
 	for i := 0; i < numRows; i++ {
-		p.box1DFloat(input, i*numCols, output, i*numCols, numCols, 1, windowSize)
+		box1DFloat(input[i*numCols:(i+1)*numCols], output[i*numCols:(i+1)*numCols], windowSize)
+	}
+}
+
+/**
+ * The phases of box1DFloat down every column at once, a row at a time,
+ * rather than down one column after another, which strides across the
+ * whole buffer for every value. Each column sees the same sums in the same
+ * order, so the output is the same to the bit.
+ */
+func boxAlongColsFloat[F lumaFloat](input, output []F, numRows, numCols, windowSize int) {
+	phase_1_nreps, phase_2_nreps, phase_3_nreps, phase_4_nreps := boxPhases(numRows, windowSize)
+	row := func(i int) []F { return input[i*numCols : (i+1)*numCols] }
+	li := 0 // Row of the top edge of the read window, for subtracts
+	ri := 0 // Row of the bottom edge of the read window, for adds
+	oi := 0 // Row of the output
+	sums := make([]F, numCols)
+	currentWindowSize := 0
+
+	for i := 0; i < phase_1_nreps; i++ {
+		addRow(sums, row(ri))
+		currentWindowSize += 1
+		ri += 1
+	}
+
+	for i := 0; i < phase_2_nreps; i++ {
+		addRow(sums, row(ri))
+		currentWindowSize += 1
+		averageRow(output[oi*numCols:(oi+1)*numCols], sums, currentWindowSize)
+		ri += 1
+		oi += 1
+	}
+
+	for i := 0; i < phase_3_nreps; i++ {
+		addRow(sums, row(ri))
+		subtractRow(sums, row(li))
+		averageRow(output[oi*numCols:(oi+1)*numCols], sums, currentWindowSize)
+		li += 1
+		ri += 1
+		oi += 1
+	}
+
+	for i := 0; i < phase_4_nreps; i++ {
+		subtractRow(sums, row(li))
+		currentWindowSize -= 1
+		averageRow(output[oi*numCols:(oi+1)*numCols], sums, currentWindowSize)
+		li += 1
+		oi += 1
+	}
+}
+
+func addRow[F lumaFloat](sums, row []F) {
+	row = row[:len(sums)]
+	for j := range sums {
+		sums[j] += row[j]
+	}
+}
+
+func subtractRow[F lumaFloat](sums, row []F) {
+	row = row[:len(sums)]
+	for j := range sums {
+		sums[j] -= row[j]
 	}
 }
 
-func (p *PDQHasher) boxAlongColsFloat(input, output *[]float64, numRows, numCols, windowSize int) {
-	for i := 0; i < numCols; i++ {
-		p.box1DFloat(input, i, output, i, numRows, numCols, windowSize)
+func averageRow[F lumaFloat](out, sums []F, windowSize int) {
+	out = out[:len(sums)]
+	for j := range sums {
+		out[j] = sums[j] / F(windowSize)
 	}
 }
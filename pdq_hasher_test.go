@@ -1,12 +1,24 @@
 package pdq
 
 import (
+	"context"
+	"errors"
+	goimage "image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/MTRNord/pdqhash-go/types"
 	"github.com/davidbyttow/govips/v2/vips"
 	"github.com/stretchr/testify/assert"
+	_ "golang.org/x/image/tiff"
 )
 
 type Pair struct {
@@ -133,12 +145,817 @@ func TestPDQHasherDehidral(t *testing.T) {
 	pdqHasher := NewPDQHasher()
 	hashes := pdqHasher.DihedralFromFile("./test-images/reg-test-input/labelme-subset/q0004.jpg", PDQ_DO_DIH_ALL)
 
-	assert.Equal(t, hashes.hash.String(), "992d44af36d69e6ca6b812585928bac11def254ef5398c6d07466c9abcc65b92")
-	assert.Equal(t, hashes.hashRotate90.String(), "9b323dd22976484c939787013f096d1669874a21dab0d3dadef50f2560cf3e4f")
-	assert.Equal(t, hashes.hashRotate180.String(), "8c78ee05e38335c6f3edf8f28e7d106b48ba8fe4a06c16c71213c670e993f138")
-	assert.Equal(t, hashes.hashRotate270.String(), "c86783787c23e2e6c6c22dab685cc7bc3cd2608b8fe579708ba0a58f359a94e5")
-	assert.Equal(t, hashes.hashFlipX.String(), "d92dbb5036d62093a6b82da75928453e1defdab1f539439247469325bcc6a465")
-	assert.Equal(t, hashes.hashFlipY.String(), "8c3811fa6383ca39f3ed470d8e7def9448ba701ba06ce9381213398fe9930ecf")
-	assert.Equal(t, hashes.hashFlipPlus1.String(), "993242252966b7a3939778fe3d0982e9698735dadab02c25def4f0da60cfc1b0")
-	assert.Equal(t, hashes.hashFlipMinus1.String(), "ee676c877c231d19c6c2d2546a5c38433cd29f748fe5868f8ba15a70359a6b1a")
+	assert.Equal(t, hashes.Hash.String(), "992d44af36d69e6ca6b812585928bac11def254ef5398c6d07466c9abcc65b92")
+	assert.Equal(t, hashes.HashRotate90.String(), "9b323dd22976484c939787013f096d1669874a21dab0d3dadef50f2560cf3e4f")
+	assert.Equal(t, hashes.HashRotate180.String(), "8c78ee05e38335c6f3edf8f28e7d106b48ba8fe4a06c16c71213c670e993f138")
+	assert.Equal(t, hashes.HashRotate270.String(), "c86783787c23e2e6c6c22dab685cc7bc3cd2608b8fe579708ba0a58f359a94e5")
+	assert.Equal(t, hashes.HashFlipX.String(), "d92dbb5036d62093a6b82da75928453e1defdab1f539439247469325bcc6a465")
+	assert.Equal(t, hashes.HashFlipY.String(), "8c3811fa6383ca39f3ed470d8e7def9448ba701ba06ce9381213398fe9930ecf")
+	assert.Equal(t, hashes.HashFlipPlus1.String(), "993242252966b7a3939778fe3d0982e9698735dadab02c25def4f0da60cfc1b0")
+	assert.Equal(t, hashes.HashFlipMinus1.String(), "ee676c877c231d19c6c2d2546a5c38433cd29f748fe5868f8ba15a70359a6b1a")
+}
+
+func TestFromReader(t *testing.T) {
+	pdqHasher := NewPDQHasher()
+	f, err := os.Open("./test-images/reg-test-input/labelme-subset/q0004.jpg")
+	assert.ErrorIs(t, err, nil)
+	defer f.Close()
+
+	hashAndQuality, err := pdqHasher.FromReader(f)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, "992d44af36d69e6ca6b812585928bac11def254ef5398c6d07466c9abcc65b92", hashAndQuality.Hash.String())
+	assert.Equal(t, 256, hashAndQuality.Width)
+	assert.Equal(t, 256, hashAndQuality.Height)
+
+	_, err = pdqHasher.FromReader(iotest.ErrReader(os.ErrClosed))
+	var hashErr *HashError
+	assert.True(t, errors.As(err, &hashErr))
+	assert.Equal(t, FAILURE_IO, hashErr.Reason)
+}
+
+func TestQualityPolicy(t *testing.T) {
+	q0004, err := os.ReadFile("./test-images/reg-test-input/labelme-subset/q0004.jpg")
+	assert.ErrorIs(t, err, nil)
+	wee, err := os.ReadFile("./test-images/misc-images/wee.jpg")
+	assert.ErrorIs(t, err, nil)
+
+	pdqHasher := NewPDQHasher()
+	kept, err := pdqHasher.FromBuffer(q0004)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, "", kept.LowQuality)
+
+	// Nothing reaches a minimum above 100.
+	pdqHasher.MinQuality = 101
+	pdqHasher.QualityPolicy = QUALITY_POLICY_FLAG
+	flagged, err := pdqHasher.FromBuffer(q0004)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, kept.Hash, flagged.Hash)
+	assert.Equal(t, LOW_QUALITY_LOW_DETAIL, flagged.LowQuality)
+
+	flagged, err = pdqHasher.FromBuffer(wee)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, LOW_QUALITY_TINY, flagged.LowQuality)
+
+	hashes, err := pdqHasher.DihedralFromBuffer(wee, PDQ_DO_DIH_ALL)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, LOW_QUALITY_TINY, hashes.LowQuality)
+
+	pdqHasher.QualityPolicy = QUALITY_POLICY_REJECT
+	_, err = pdqHasher.FromBuffer(q0004)
+	var hashErr *HashError
+	assert.True(t, errors.As(err, &hashErr))
+	assert.Equal(t, FAILURE_LOW_QUALITY, hashErr.Reason)
+	var lowQualityErr *LowQualityError
+	assert.True(t, errors.As(err, &lowQualityErr))
+	assert.Equal(t, &LowQualityError{kept.Quality, 101, LOW_QUALITY_LOW_DETAIL}, lowQualityErr)
+}
+
+func TestLowQualityReason(t *testing.T) {
+	buffer64x64 := make([]float64, 64*64)
+	for i := range buffer64x64 {
+		buffer64x64[i] = 100 + float64(i/64%4)
+	}
+	assert.Equal(t, LOW_QUALITY_TINY, lowQualityReason(512, 40, buffer64x64))
+	assert.Equal(t, LOW_QUALITY_FLAT, lowQualityReason(512, 512, buffer64x64))
+
+	buffer64x64[10*64+10] = 0
+	assert.Equal(t, LOW_QUALITY_LOW_DETAIL, lowQualityReason(512, 512, buffer64x64))
+
+	// Tiny is judged on the image, not on its thumbnail: a uniform 2000x100
+	// image thumbnails to 512x26 but is only flat.
+	p := NewPDQHasher()
+	p.QualityPolicy = QUALITY_POLICY_FLAG
+	p.MinQuality = 101
+	for _, size := range []struct {
+		width, height int
+		reason        string
+	}{{2000, 100, LOW_QUALITY_FLAT}, {2000, 40, LOW_QUALITY_TINY}} {
+		img := goimage.NewGray(goimage.Rect(0, 0, size.width, size.height))
+		hashAndQuality, err := p.FromGoImage(img)
+		assert.ErrorIs(t, err, nil)
+		assert.Equal(t, size.reason, hashAndQuality.LowQuality, size)
+	}
+}
+
+func TestHashesOrder(t *testing.T) {
+	a, _ := types.Hash256FromHexString("992d44af36d69e6ca6b812585928bac11def254ef5398c6d07466c9abcc65b92")
+	b, _ := types.Hash256FromHexString("8c78ee05e38335c6f3edf8f28e7d106b48ba8fe4a06c16c71213c670e993f138")
+	hashes := HashesAndQuality{Hash: a, HashFlipY: b}
+
+	assert.Equal(t, []DihedralHash{
+		{"original", PDQ_DO_DIH_ORIGINAL, a},
+		{"flipy", PDQ_DO_DIH_FLIPY, b},
+	}, hashes.Hashes())
+}
+
+type recordingInstrumentation struct {
+	mu       sync.Mutex
+	stages   []Stage
+	hashed   int
+	failures []string
+}
+
+func (r *recordingInstrumentation) StageDone(stage Stage, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stages = append(r.stages, stage)
+}
+
+func (r *recordingInstrumentation) ImageHashed(quality int, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hashed++
+}
+
+func (r *recordingInstrumentation) HashFailed(stage Stage, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, string(stage)+"/"+reason)
+}
+
+func TestInstrumentation(t *testing.T) {
+	recorder := &recordingInstrumentation{}
+	pdqHasher := NewPDQHasher()
+	pdqHasher.Instrumentation = recorder
+
+	buf, err := os.ReadFile("./test-images/reg-test-input/labelme-subset/q0004.jpg")
+	assert.ErrorIs(t, err, nil)
+	_, err = pdqHasher.FromBuffer(buf)
+	assert.ErrorIs(t, err, nil)
+
+	assert.Equal(t, STAGES, recorder.stages)
+	assert.Equal(t, 1, recorder.hashed)
+
+	_, err = pdqHasher.FromBuffer([]byte("not an image"))
+	var hashErr *HashError
+	assert.ErrorAs(t, err, &hashErr)
+	assert.Equal(t, STAGE_DECODE, hashErr.Stage)
+	assert.Equal(t, FAILURE_UNSUPPORTED_FORMAT, hashErr.Reason)
+	assert.Equal(t, []string{"decode/unsupported_format"}, recorder.failures)
+	assert.Equal(t, 1, recorder.hashed)
+
+	// One decode, then a thumbnail for the image and one for its regions.
+	recorder.stages = nil
+	_, _, err = pdqHasher.FromBufferWithRegions(buf, Tiles(2, 2))
+	assert.ErrorIs(t, err, nil)
+	counts := map[Stage]int{}
+	for _, stage := range recorder.stages {
+		counts[stage]++
+	}
+	assert.Equal(t, map[Stage]int{STAGE_DECODE: 1, STAGE_THUMBNAIL: 2, STAGE_LUMA: 2, STAGE_FILTER: 5, STAGE_DCT: 5}, counts)
+}
+
+type recordingObserver struct {
+	mu    sync.Mutex
+	spans []string
+}
+
+type recordingSpan struct {
+	observer *recordingObserver
+	name     string
+}
+
+type spanNameKey struct{}
+
+func (o *recordingObserver) StartHash(ctx context.Context) (context.Context, Span) {
+	return context.WithValue(ctx, spanNameKey{}, "hash"), &recordingSpan{o, "hash"}
+}
+
+func (o *recordingObserver) StartStage(ctx context.Context, stage Stage) Span {
+	return &recordingSpan{o, ctx.Value(spanNameKey{}).(string) + "/" + string(stage)}
+}
+
+func (s *recordingSpan) End(elapsed time.Duration, err error) {
+	s.observer.mu.Lock()
+	defer s.observer.mu.Unlock()
+	s.observer.spans = append(s.observer.spans, s.name)
+}
+
+func TestObserver(t *testing.T) {
+	observer := &recordingObserver{}
+	pdqHasher := NewPDQHasher()
+	pdqHasher.Observer = observer
+
+	buf, err := os.ReadFile("./test-images/reg-test-input/labelme-subset/q0004.jpg")
+	assert.ErrorIs(t, err, nil)
+	_, err = pdqHasher.FromBufferContext(context.Background(), buf)
+	assert.ErrorIs(t, err, nil)
+
+	assert.Equal(t, []string{"hash/decode", "hash/thumbnail", "hash/luma", "hash/filter", "hash/dct", "hash"}, observer.spans)
+}
+
+func TestUnobservedTraceDoesNotAllocate(t *testing.T) {
+	pdqHasher := NewPDQHasher()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		trace := pdqHasher.startHash(context.Background())
+		s := trace.startStage(STAGE_DCT)
+		trace.endStage(s)
+		trace.end(100)
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func TestFindBorders(t *testing.T) {
+	// A 10x8 image of mid grey content inside a frame of black bars at
+	// the top and bottom and white bars at the sides.
+	const numRows, numCols = 10, 8
+	luma := make([]float64, numRows*numCols)
+	for i := 0; i < numRows; i++ {
+		for j := 0; j < numCols; j++ {
+			v := 100.0 + float64(i*numCols+j)
+			if i < 2 || i >= 8 {
+				v = 3
+			} else if j < 1 || j >= 6 {
+				v = 250
+			}
+			luma[i*numCols+j] = v
+		}
+	}
+	assert.Equal(t, &CropBox{1, 2, 6, 8}, findBorders(luma, numRows, numCols, 10, 0.25))
+	// 10% allows one row and no columns.
+	assert.Equal(t, &CropBox{0, 1, numCols, 9}, findBorders(luma, numRows, numCols, 10, 0.1))
+	assert.Equal(t, &CropBox{0, 0, numCols, numRows}, findBorders(luma, numRows, numCols, 10, 0))
+
+	p := NewPDQHasher()
+	rows, cols, box, err := p.trimBorders(luma, numRows, numCols)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, []int{numRows, numCols}, []int{rows, cols})
+	assert.Nil(t, box)
+
+	for _, trim := range []BorderTrim{{-1, 0.25}, {10, -0.1}, {10, 0.5}, {10, math.NaN()}} {
+		p.BorderTrim = &trim
+		_, _, _, err = p.trimBorders(luma, numRows, numCols)
+		assert.Error(t, err, trim)
+	}
+
+	// A uniform image keeps at least its middle half.
+	uniform := make([]float64, numRows*numCols)
+	p.BorderTrim = &BorderTrim{Tolerance: 10, MaxFraction: 0.49}
+	rows, cols, _, err = p.trimBorders(uniform, numRows, numCols)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, []int{2, 2}, []int{rows, cols})
+
+	p.BorderTrim = DefaultBorderTrim()
+	rows, cols, box, err = p.trimBorders(luma, numRows, numCols)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, &CropBox{1, 2, 6, 8}, box)
+	assert.Equal(t, []int{6, 5}, []int{rows, cols})
+	assert.Equal(t, []float64{117, 118, 119, 120, 121}, luma[:5])
+	assert.Equal(t, []float64{157, 158, 159, 160, 161}, luma[25:30])
+
+	assert.Equal(t, &CropBox{10, 20, 60, 80}, box.scale(cols+3, rows+4, 80, 100))
+	assert.Equal(t, "1,2,6,8", box.String())
+}
+
+func TestBorderTrim(t *testing.T) {
+	image, err := vips.NewImageFromFile("./test-images/reg-test-input/dih/bridge-1-original.jpg")
+	assert.ErrorIs(t, err, nil)
+	defer image.Close()
+	// Letterbox the 1600x1004 bridge to 16:9 plus a 40 pixel black frame.
+	assert.ErrorIs(t, image.Embed(40, 148, 1680, 1300, vips.ExtendBlack), nil)
+	buf, _, err := image.ExportPng(vips.NewPngExportParams())
+	assert.ErrorIs(t, err, nil)
+
+	original, err := types.Hash256FromHexString("d8f8f0cce0f4a84f0e370a22028f67f0b36e2ed596623e1d33e6b39c4e9c9b22")
+	assert.ErrorIs(t, err, nil)
+
+	p := NewPDQHasher()
+	untrimmed, err := p.FromBuffer(buf)
+	assert.ErrorIs(t, err, nil)
+	assert.Nil(t, untrimmed.Crop)
+
+	p.BorderTrim = DefaultBorderTrim()
+	trimmed, err := p.FromBuffer(buf)
+	assert.ErrorIs(t, err, nil)
+	assert.LessOrEqual(t, trimmed.Hash.HammingDistance(original), 16)
+	assert.Less(t, trimmed.Hash.HammingDistance(original), untrimmed.Hash.HammingDistance(original))
+	assert.NotNil(t, trimmed.Crop)
+	// Within a thumbnail pixel or two of the original image.
+	assert.InDelta(t, 40, trimmed.Crop.Left, 8)
+	assert.InDelta(t, 148, trimmed.Crop.Top, 8)
+	assert.InDelta(t, 1640, trimmed.Crop.Right, 8)
+	assert.InDelta(t, 1152, trimmed.Crop.Bottom, 8)
+
+	hashes, err := p.DihedralFromBuffer(buf, PDQ_DO_DIH_ALL)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, trimmed.Hash, hashes.Hash)
+	assert.Equal(t, trimmed.Crop, hashes.Crop)
+}
+
+func TestRegionLayouts(t *testing.T) {
+	assert.Equal(t, []CropBox{{0, 0, 5, 3}, {5, 0, 10, 3}, {0, 3, 5, 7}, {5, 3, 10, 7}}, Tiles(2, 2)(10, 7))
+	assert.Equal(t, []CropBox{{0, 0, 3, 7}, {3, 0, 6, 7}, {6, 0, 10, 7}}, Tiles(1, 3)(10, 7))
+
+	// Windows of half the image moved by 30% of it; the last column of
+	// windows is moved flush with the right edge.
+	assert.Equal(t, []CropBox{{0, 0, 5, 2}, {3, 0, 8, 2}, {5, 0, 10, 2}}, Windows(0.5, 0.3)(10, 4)[:3])
+	assert.Len(t, Windows(0.5, 0.25)(100, 100), 9)
+	assert.Equal(t, []CropBox{{0, 0, 10, 4}}, Windows(2, 0)(10, 4))
+	assert.Equal(t, []int{0, 4, 6}, windowStarts(10, 4, 4))
+}
+
+func TestRegions(t *testing.T) {
+	image, err := vips.NewImageFromFile("./test-images/reg-test-input/dih/bridge-1-original.jpg")
+	assert.ErrorIs(t, err, nil)
+	defer image.Close()
+	// The 1600x1004 bridge in the top left quadrant of a black canvas.
+	assert.ErrorIs(t, image.Embed(0, 0, 3200, 2008, vips.ExtendBlack), nil)
+
+	original, err := types.Hash256FromHexString("d8f8f0cce0f4a84f0e370a22028f67f0b36e2ed596623e1d33e6b39c4e9c9b22")
+	assert.ErrorIs(t, err, nil)
+
+	p := NewPDQHasher()
+	region, err := p.RegionFromImage(image, CropBox{0, 0, 1600, 1004})
+	assert.ErrorIs(t, err, nil)
+	assert.LessOrEqual(t, region.Hash.HammingDistance(original), 16)
+	assert.Equal(t, []int{1600, 1004}, []int{region.Width, region.Height})
+	// The image was not changed.
+	assert.Equal(t, []int{3200, 2008}, []int{image.Width(), image.Height()})
+
+	buf, _, err := image.ExportPng(vips.NewPngExportParams())
+	assert.ErrorIs(t, err, nil)
+	tiles, err := p.RegionsFromBuffer(buf, Tiles(2, 2))
+	assert.ErrorIs(t, err, nil)
+	assert.Len(t, tiles, 4)
+	assert.Equal(t, CropBox{0, 0, 1600, 1004}, tiles[0].Box)
+	assert.LessOrEqual(t, tiles[0].Hash.HammingDistance(region.Hash), 4)
+	whole, err := p.FromBuffer(buf)
+	assert.ErrorIs(t, err, nil)
+	assert.Less(t, tiles[0].Hash.HammingDistance(original), whole.Hash.HammingDistance(original))
+
+	// The black tiles are rejected on their own.
+	p.QualityPolicy = QUALITY_POLICY_REJECT
+	p.MinQuality = DEFAULT_MIN_QUALITY
+	tiles, err = p.RegionsFromBuffer(buf, Tiles(2, 2))
+	assert.ErrorIs(t, err, nil)
+	assert.ErrorIs(t, tiles[0].Err, nil)
+	var lowQualityErr *LowQualityError
+	assert.True(t, errors.As(tiles[3].Err, &lowQualityErr))
+
+	// Boxes outside the image, or empty as the tiles of a grid finer than
+	// the image, fail on their own.
+	p.QualityPolicy = QUALITY_POLICY_KEEP
+	regions, err := p.RegionsFromBuffer(buf, Boxes(CropBox{0, 0, 4000, 10}, CropBox{0, 0, 1600, 1004}, CropBox{5, 5, 5, 10}))
+	assert.ErrorIs(t, err, nil)
+	assert.ErrorContains(t, regions[0].Err, "empty or outside")
+	assert.ErrorIs(t, regions[1].Err, nil)
+	assert.ErrorContains(t, regions[2].Err, "empty or outside")
+
+	_, err = p.RegionsFromBuffer(buf, Boxes())
+	var hashErr *HashError
+	assert.True(t, errors.As(err, &hashErr))
+
+	// One decode for both, with the same hash as FromBuffer.
+	withRegions, regions, err := p.FromBufferWithRegions(buf, Tiles(2, 2))
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, whole.Hash, withRegions.Hash)
+	assert.Equal(t, tiles[0].Hash, regions[0].Hash)
+}
+
+func decodeGoImage(t testing.TB, path string) goimage.Image {
+	f, err := os.Open(path)
+	assert.ErrorIs(t, err, nil)
+	defer f.Close()
+	img, _, err := goimage.Decode(f)
+	assert.ErrorIs(t, err, nil)
+	return img
+}
+
+func TestFromGoImageAlpha(t *testing.T) {
+	straight := decodeGoImage(t, "./test-images/alpha/sticker-straight.png")
+	black := decodeGoImage(t, "./test-images/alpha/sticker-black.png")
+	premultiplied := decodeGoImage(t, "./test-images/alpha/sticker-premultiplied.tiff")
+	assert.IsType(t, &goimage.NRGBA{}, straight)
+	assert.IsType(t, &goimage.RGBA{}, premultiplied)
+
+	// Ignoring alpha, the garbage behind the transparent corners counts.
+	p := NewPDQHasher()
+	ignored, err := p.FromGoImage(straight)
+	assert.ErrorIs(t, err, nil)
+	blackIgnored, err := p.FromGoImage(black)
+	assert.ErrorIs(t, err, nil)
+	assert.Greater(t, ignored.Hash.HammingDistance(blackIgnored.Hash), 16)
+
+	for _, policy := range []string{ALPHA_POLICY_WHITE, ALPHA_POLICY_BLACK, ALPHA_POLICY_COLOR} {
+		p.AlphaPolicy = policy
+		p.AlphaColor = color.RGBA{0x80, 0x20, 0xc0, 0xff}
+		hash, err := p.FromGoImage(straight)
+		assert.ErrorIs(t, err, nil)
+		blackHash, err := p.FromGoImage(black)
+		assert.ErrorIs(t, err, nil)
+		premultipliedHash, err := p.FromGoImage(premultiplied)
+		assert.ErrorIs(t, err, nil)
+		assert.Equal(t, hash.Hash, blackHash.Hash, policy)
+		assert.LessOrEqual(t, hash.Hash.HammingDistance(premultipliedHash.Hash), 2, policy)
+	}
+}
+
+func TestParseAlphaPolicy(t *testing.T) {
+	policy, _, err := ParseAlphaPolicy("white")
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, ALPHA_POLICY_WHITE, policy)
+	policy, c, err := ParseAlphaPolicy("#ff8000")
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, ALPHA_POLICY_COLOR, policy)
+	assert.Equal(t, color.RGBA{0xff, 0x80, 0x00, 0xff}, c)
+	for _, s := range []string{"", "color", "#ff80", "#gg8000", "grey"} {
+		_, _, err = ParseAlphaPolicy(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestShrinkLuma(t *testing.T) {
+	luma := []float64{
+		0, 2, 4, 6,
+		2, 4, 6, 8,
+	}
+	out, rows, cols := shrinkLuma(luma, 2, 4, 2)
+	assert.Equal(t, []int{1, 2}, []int{rows, cols})
+	assert.Equal(t, []float64{2, 6}, out)
+
+	out, rows, cols = shrinkLuma(luma, 2, 4, 4)
+	assert.Equal(t, []int{2, 4}, []int{rows, cols})
+	assert.Equal(t, luma, out)
+}
+
+func TestAlphaPolicy(t *testing.T) {
+	straight, err := os.ReadFile("./test-images/alpha/sticker-straight.png")
+	assert.ErrorIs(t, err, nil)
+	black, err := os.ReadFile("./test-images/alpha/sticker-black.png")
+	assert.ErrorIs(t, err, nil)
+	premultiplied, err := os.ReadFile("./test-images/alpha/sticker-premultiplied.tiff")
+	assert.ErrorIs(t, err, nil)
+
+	p := NewPDQHasher()
+	ignored, err := p.FromBuffer(straight)
+	assert.ErrorIs(t, err, nil)
+	blackIgnored, err := p.FromBuffer(black)
+	assert.ErrorIs(t, err, nil)
+	assert.Greater(t, ignored.Hash.HammingDistance(blackIgnored.Hash), 16)
+
+	p.AlphaPolicy = ALPHA_POLICY_WHITE
+	hash, err := p.FromBuffer(straight)
+	assert.ErrorIs(t, err, nil)
+	blackHash, err := p.FromBuffer(black)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, hash.Hash, blackHash.Hash)
+	premultipliedHash, err := p.FromBuffer(premultiplied)
+	assert.ErrorIs(t, err, nil)
+	assert.LessOrEqual(t, hash.Hash.HammingDistance(premultipliedHash.Hash), 8)
+
+	// The dihedral path reads luma the same way.
+	hashes, err := p.DihedralFromBuffer(black, PDQ_DO_DIH_ORIGINAL)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, hash.Hash, hashes.Hash)
+
+	// The pure Go path composites alike; only the resampling differs.
+	goHash, err := p.FromGoImage(decodeGoImage(t, "./test-images/alpha/sticker-straight.png"))
+	assert.ErrorIs(t, err, nil)
+	assert.LessOrEqual(t, hash.Hash.HammingDistance(goHash.Hash), 16)
+}
+
+func TestColorConversions(t *testing.T) {
+	p := NewPDQHasher()
+	hash := func(name string) HashAndQuality {
+		buf, err := os.ReadFile("./test-images/color/" + name)
+		assert.ErrorIs(t, err, nil)
+		hashAndQuality, err := p.FromBuffer(buf)
+		assert.ErrorIs(t, err, nil, name)
+		return hashAndQuality
+	}
+	reference := hash("bridge-rgb.png")
+
+	for name, maxDistance := range map[string]int{
+		"bridge-rgb16.png":  2,
+		"bridge-gray.png":   2,
+		"bridge-gray16.png": 2,
+		"bridge-float.tif":  8,
+		"bridge.hdr":        8,
+		// Through the default CMYK profile, which shifts the tones.
+		"bridge-cmyk.tif": 24,
+	} {
+		hashAndQuality := hash(name)
+		assert.Equal(t, []int{320, 201}, []int{hashAndQuality.Width, hashAndQuality.Height}, name)
+		assert.LessOrEqual(t, hashAndQuality.Hash.HammingDistance(reference.Hash), maxDistance, name)
+	}
+
+	// CMYK goes through the embedded profile, as when converting first.
+	image, err := vips.NewImageFromFile("./test-images/color/cmyk-icc-swop.jpg")
+	assert.ErrorIs(t, err, nil)
+	defer image.Close()
+	assert.Equal(t, vips.InterpretationCMYK, image.Interpretation())
+	assert.ErrorIs(t, image.TransformICCProfile(vips.SRGBIEC6196621ICCProfilePath), nil)
+	converted, _, err := image.ExportPng(vips.NewPngExportParams())
+	assert.ErrorIs(t, err, nil)
+	cmyk := hash("cmyk-icc-swop.jpg")
+	srgb, err := p.FromBuffer(converted)
+	assert.ErrorIs(t, err, nil)
+	assert.LessOrEqual(t, cmyk.Hash.HammingDistance(srgb.Hash), 4)
+}
+
+func TestFromImageGrey16(t *testing.T) {
+	// Without a thumbnail the single 16-bit band reaches the luma fill.
+	image, err := vips.NewImageFromFile("./test-images/color/bridge-gray16.png")
+	assert.ErrorIs(t, err, nil)
+	defer image.Close()
+	assert.Equal(t, 1, image.Bands())
+
+	numCols, numRows := image.Width(), image.Height()
+	p := NewPDQHasher()
+	hashAndQuality := p.FromImage(image, make([]float64, numCols*numRows), make([]float64, numCols*numRows), allocateMatrix(64, 64), allocateMatrix(16, 64), allocateMatrix(16, 16))
+
+	buf, err := os.ReadFile("./test-images/color/bridge-rgb.png")
+	assert.ErrorIs(t, err, nil)
+	reference, err := p.FromBuffer(buf)
+	assert.ErrorIs(t, err, nil)
+	assert.LessOrEqual(t, hashAndQuality.Hash.HammingDistance(reference.Hash), 2)
+}
+
+func TestFromGoImageFormats(t *testing.T) {
+	p := NewPDQHasher()
+	reference, err := p.FromGoImage(decodeGoImage(t, "./test-images/color/bridge-rgb.png"))
+	assert.ErrorIs(t, err, nil)
+	for _, name := range []string{"bridge-rgb16.png", "bridge-gray.png", "bridge-gray16.png"} {
+		hashAndQuality, err := p.FromGoImage(decodeGoImage(t, "./test-images/color/"+name))
+		assert.ErrorIs(t, err, nil)
+		assert.LessOrEqual(t, hashAndQuality.Hash.HammingDistance(reference.Hash), 2, name)
+	}
+}
+
+func TestOrientLuma(t *testing.T) {
+	// 0 1 2
+	// 3 4 5
+	luma := []float64{0, 1, 2, 3, 4, 5}
+	expected := map[int][]float64{
+		1: {0, 1, 2, 3, 4, 5},
+		2: {2, 1, 0, 5, 4, 3},
+		3: {5, 4, 3, 2, 1, 0},
+		4: {3, 4, 5, 0, 1, 2},
+		5: {0, 3, 1, 4, 2, 5},
+		6: {3, 0, 4, 1, 5, 2},
+		7: {5, 2, 4, 1, 3, 0},
+		8: {2, 5, 1, 4, 0, 3},
+	}
+	for orientation, displayed := range expected {
+		out := make([]float64, len(luma))
+		rows, cols := orientLuma(luma, out, 2, 3, orientation)
+		assert.Equal(t, displayed, out, orientation)
+		width, height := orientedSize(3, 2, orientation)
+		assert.Equal(t, []int{height, width}, []int{rows, cols}, orientation)
+	}
+	assert.False(t, orients(0))
+	assert.False(t, orients(1))
+	assert.True(t, orients(6))
+}
+
+func TestOrientedGoImage(t *testing.T) {
+	// The fixture is stored on its side with orientation 6; turned upright
+	// it hashes like the upright image.
+	p := NewPDQHasher()
+	img := decodeGoImage(t, "./test-images/orientation/bridge-orientation-6.jpg")
+	bounds := img.Bounds()
+	luma := p.lumaFromGoImage(img)
+	out := make([]float64, len(luma))
+	rows, cols := orientLuma(luma, out, bounds.Dy(), bounds.Dx(), 6)
+
+	upright := goimage.NewGray(goimage.Rect(0, 0, cols, rows))
+	for i, v := range out {
+		upright.Pix[i] = uint8(v + 0.5)
+	}
+	hash, err := p.FromGoImage(upright)
+	assert.ErrorIs(t, err, nil)
+	reference, err := p.FromGoImage(decodeGoImage(t, "./test-images/color/bridge-rgb.png"))
+	assert.ErrorIs(t, err, nil)
+	assert.LessOrEqual(t, hash.Hash.HammingDistance(reference.Hash), 8)
+
+	sideways, err := p.FromGoImage(img)
+	assert.ErrorIs(t, err, nil)
+	assert.Greater(t, sideways.Hash.HammingDistance(reference.Hash), 32)
+}
+
+func TestOrientation(t *testing.T) {
+	buf, err := os.ReadFile("./test-images/orientation/bridge-orientation-6.jpg")
+	assert.ErrorIs(t, err, nil)
+	upright, err := os.ReadFile("./test-images/color/bridge-rgb.png")
+	assert.ErrorIs(t, err, nil)
+
+	p := NewPDQHasher()
+	reference, err := p.FromBuffer(upright)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, 0, reference.Orientation)
+
+	ignored, err := p.FromBuffer(buf)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, 6, ignored.Orientation)
+	assert.Equal(t, []int{201, 320}, []int{ignored.Width, ignored.Height})
+	assert.Nil(t, ignored.DisplayedHash)
+	assert.Greater(t, ignored.Hash.HammingDistance(reference.Hash), 32)
+
+	p.Orientation = ORIENTATION_APPLY
+	applied, err := p.FromBuffer(buf)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, 6, applied.Orientation)
+	assert.Equal(t, []int{320, 201}, []int{applied.Width, applied.Height})
+	assert.LessOrEqual(t, applied.Hash.HammingDistance(reference.Hash), 8)
+
+	hashes, err := p.DihedralFromBuffer(buf, PDQ_DO_DIH_ORIGINAL)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, 6, hashes.Orientation)
+	assert.Equal(t, applied.Hash, hashes.Hash)
+
+	p.Orientation = ORIENTATION_BOTH
+	both, err := p.FromBuffer(buf)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, ignored.Hash, both.Hash)
+	assert.Equal(t, applied.Hash, both.DisplayedHash)
+	assert.Equal(t, []int{201, 320}, []int{both.Width, both.Height})
+
+	uprightBoth, err := p.FromBuffer(upright)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, uprightBoth.Hash, uprightBoth.DisplayedHash)
+}
+
+func TestFloat32Drift(t *testing.T) {
+	// Hashes every JPEG and PNG of the test corpus in both precisions;
+	// the pure Go path shares the filter and the DCT with the vips one.
+	var paths []string
+	err := filepath.WalkDir("./test-images", func(path string, d os.DirEntry, err error) error {
+		if ext := filepath.Ext(path); ext == ".jpg" || ext == ".png" {
+			paths = append(paths, path)
+		}
+		return err
+	})
+	assert.ErrorIs(t, err, nil)
+	assert.NotEmpty(t, paths)
+
+	p64 := NewPDQHasher()
+	p32 := NewPDQHasher()
+	p32.Precision = PRECISION_FLOAT32
+	histogram := map[int]int{}
+	total, worst := 0, 0
+	for _, path := range paths {
+		img := decodeGoImage(t, path)
+		hash64, err := p64.FromGoImage(img)
+		assert.ErrorIs(t, err, nil)
+		hash32, err := p32.FromGoImage(img)
+		assert.ErrorIs(t, err, nil)
+
+		distance := hash64.Hash.HammingDistance(hash32.Hash)
+		histogram[distance]++
+		total += distance
+		worst = max(worst, distance)
+		assert.Equal(t, hash64.Quality, hash32.Quality, path)
+	}
+	mean := float64(total) / float64(len(paths))
+	t.Logf("float32 drift over %d images: mean %.2f bits, max %d, histogram %v", len(paths), mean, worst, histogram)
+	assert.Equal(t, 0, worst)
+}
+
+func TestPrecision(t *testing.T) {
+	p64 := NewPDQHasher()
+	p32 := NewPDQHasher()
+	p32.Precision = PRECISION_FLOAT32
+	for _, pair := range DATA_ARRAY {
+		buf, err := os.ReadFile(pair.First)
+		assert.ErrorIs(t, err, nil)
+		hash64, err := p64.FromBuffer(buf)
+		assert.ErrorIs(t, err, nil)
+		hash32, err := p32.FromBuffer(buf)
+		assert.ErrorIs(t, err, nil)
+		assert.LessOrEqual(t, hash64.Hash.HammingDistance(hash32.Hash), 4, pair.First)
+
+		hashes, err := p32.DihedralFromBuffer(buf, PDQ_DO_DIH_ALL)
+		assert.ErrorIs(t, err, nil)
+		assert.Equal(t, hash32.Hash, hashes.Hash, pair.First)
+		assert.Equal(t, hash32.Quality, hashes.Quality, pair.First)
+	}
+
+	// Regions are hashed in the precision of the hasher too.
+	buf, err := os.ReadFile(DATA_ARRAY[0].First)
+	assert.ErrorIs(t, err, nil)
+	regions64, err := p64.RegionsFromBuffer(buf, Tiles(2, 2))
+	assert.ErrorIs(t, err, nil)
+	regions32, err := p32.RegionsFromBuffer(buf, Tiles(2, 2))
+	assert.ErrorIs(t, err, nil)
+	for i := range regions64 {
+		assert.LessOrEqual(t, regions64[i].Hash.HammingDistance(regions32[i].Hash), 4)
+	}
+}
+
+// The filter, decimation and DCT of a thumbnail, with the buffers
+// allocated per image as fromLoadedImage does.
+func benchmarkPipeline(b *testing.B, precision string) {
+	p := NewPDQHasher()
+	img := decodeGoImage(b, "./test-images/reg-test-input/dih/bridge-1-original.jpg")
+	bounds := img.Bounds()
+	luma, numRows, numCols := shrinkLuma(p.lumaFromGoImage(img), bounds.Dy(), bounds.Dx(), THUMBNAIL_SIZE)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		t := p.startHash(context.Background())
+		var err error
+		if precision == PRECISION_FLOAT32 {
+			buffer1 := narrowLuma(luma)
+			_, err = p.pdqHash256FromFloat32Luma(t, buffer1, make([]float32, len(buffer1)), numRows, numCols, numCols, numRows)
+		} else {
+			buffer1 := append([]float64(nil), luma...)
+			_, err = p.pdqHash256FromFloatLuma(t, buffer1, make([]float64, len(buffer1)), numRows, numCols, numCols, numRows, make([]float64, 64*64), make([]float64, 16*64), make([]float64, 16*16))
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPipelineFloat64(b *testing.B) {
+	benchmarkPipeline(b, PRECISION_FLOAT64)
+}
+
+func BenchmarkPipelineFloat32(b *testing.B) {
+	benchmarkPipeline(b, PRECISION_FLOAT32)
+}
+
+// The flat DCT and column filter against the textbook loops they replace,
+// which must agree to the bit for hashes not to change.
+func TestFlatStages(t *testing.T) {
+	p := NewPDQHasher()
+	A := make([]float64, 64*64)
+	for i := range A {
+		A[i] = float64((i*7919)%256) + 0.25
+	}
+
+	B := make([]float64, 16*16)
+	p.dct64To16(A, make([]float64, 16*64), B)
+	T := allocateMatrix(16, 64)
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 64; j++ {
+			sum := 0.0
+			for k := 0; k < 64; k++ {
+				sum += p.DCT_matrix[i][k] * A[k*64+j]
+			}
+			T[i][j] = sum
+		}
+	}
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 16; j++ {
+			sum := 0.0
+			for k := 0; k < 64; k++ {
+				sum += T[i][k] * p.DCT_matrix[j][k]
+			}
+			assert.Equal(t, sum, B[i*16+j])
+		}
+	}
+
+	numRows, numCols := 37, 23
+	in := A[:numRows*numCols]
+	out := make([]float64, numRows*numCols)
+	boxAlongColsFloat(in, out, numRows, numCols, 5)
+	column := make([]float64, numRows)
+	want := make([]float64, numRows)
+	for j := 0; j < numCols; j++ {
+		for i := range column {
+			column[i] = in[i*numCols+j]
+		}
+		box1DFloat(column, want, 5)
+		for i := range want {
+			assert.Equal(t, want[i], out[i*numCols+j])
+		}
+	}
+}
+
+func BenchmarkStages(b *testing.B) {
+	p := NewPDQHasher()
+	img := decodeGoImage(b, "./test-images/reg-test-input/dih/bridge-1-original.jpg")
+	bounds := img.Bounds()
+	luma, numRows, numCols := shrinkLuma(p.lumaFromGoImage(img), bounds.Dy(), bounds.Dx(), THUMBNAIL_SIZE)
+	buffer1 := make([]float64, len(luma))
+	buffer2 := make([]float64, len(luma))
+	buffer64x64 := make([]float64, 64*64)
+	buffer16x64 := make([]float64, 16*64)
+	buffer16x16 := make([]float64, 16*16)
+	copy(buffer1, luma)
+	jaroszFilterFloat(buffer1, buffer2, numRows, numCols, p.computeJaroszWindowSize(numCols), p.computeJaroszWindowSize(numRows), PDQ_NUM_JAROSZ_XY_PASSES)
+	decimateFloat(buffer1, numRows, numCols, buffer64x64)
+
+	b.Run("jarosz", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			copy(buffer1, luma)
+			jaroszFilterFloat(buffer1, buffer2, numRows, numCols, p.computeJaroszWindowSize(numCols), p.computeJaroszWindowSize(numRows), PDQ_NUM_JAROSZ_XY_PASSES)
+		}
+	})
+	b.Run("decimate", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			decimateFloat(buffer1, numRows, numCols, buffer64x64)
+		}
+	})
+	b.Run("dct", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p.dct64To16(buffer64x64, buffer16x64, buffer16x16)
+		}
+	})
 }
@@ -0,0 +1,1034 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.33.0
+// 	protoc        (unknown)
+// source: pdq/v1/pdq.proto
+
+package pdqv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type DihedralTransform int32
+
+const (
+	DihedralTransform_DIHEDRAL_TRANSFORM_UNSPECIFIED  DihedralTransform = 0
+	DihedralTransform_DIHEDRAL_TRANSFORM_ORIGINAL     DihedralTransform = 1
+	DihedralTransform_DIHEDRAL_TRANSFORM_ROTATE_90    DihedralTransform = 2
+	DihedralTransform_DIHEDRAL_TRANSFORM_ROTATE_180   DihedralTransform = 3
+	DihedralTransform_DIHEDRAL_TRANSFORM_ROTATE_270   DihedralTransform = 4
+	DihedralTransform_DIHEDRAL_TRANSFORM_FLIP_X       DihedralTransform = 5
+	DihedralTransform_DIHEDRAL_TRANSFORM_FLIP_Y       DihedralTransform = 6
+	DihedralTransform_DIHEDRAL_TRANSFORM_FLIP_PLUS_1  DihedralTransform = 7
+	DihedralTransform_DIHEDRAL_TRANSFORM_FLIP_MINUS_1 DihedralTransform = 8
+)
+
+// Enum value maps for DihedralTransform.
+var (
+	DihedralTransform_name = map[int32]string{
+		0: "DIHEDRAL_TRANSFORM_UNSPECIFIED",
+		1: "DIHEDRAL_TRANSFORM_ORIGINAL",
+		2: "DIHEDRAL_TRANSFORM_ROTATE_90",
+		3: "DIHEDRAL_TRANSFORM_ROTATE_180",
+		4: "DIHEDRAL_TRANSFORM_ROTATE_270",
+		5: "DIHEDRAL_TRANSFORM_FLIP_X",
+		6: "DIHEDRAL_TRANSFORM_FLIP_Y",
+		7: "DIHEDRAL_TRANSFORM_FLIP_PLUS_1",
+		8: "DIHEDRAL_TRANSFORM_FLIP_MINUS_1",
+	}
+	DihedralTransform_value = map[string]int32{
+		"DIHEDRAL_TRANSFORM_UNSPECIFIED":  0,
+		"DIHEDRAL_TRANSFORM_ORIGINAL":     1,
+		"DIHEDRAL_TRANSFORM_ROTATE_90":    2,
+		"DIHEDRAL_TRANSFORM_ROTATE_180":   3,
+		"DIHEDRAL_TRANSFORM_ROTATE_270":   4,
+		"DIHEDRAL_TRANSFORM_FLIP_X":       5,
+		"DIHEDRAL_TRANSFORM_FLIP_Y":       6,
+		"DIHEDRAL_TRANSFORM_FLIP_PLUS_1":  7,
+		"DIHEDRAL_TRANSFORM_FLIP_MINUS_1": 8,
+	}
+)
+
+func (x DihedralTransform) Enum() *DihedralTransform {
+	p := new(DihedralTransform)
+	*p = x
+	return p
+}
+
+func (x DihedralTransform) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (DihedralTransform) Descriptor() protoreflect.EnumDescriptor {
+	return file_pdq_v1_pdq_proto_enumTypes[0].Descriptor()
+}
+
+func (DihedralTransform) Type() protoreflect.EnumType {
+	return &file_pdq_v1_pdq_proto_enumTypes[0]
+}
+
+func (x DihedralTransform) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use DihedralTransform.Descriptor instead.
+func (DihedralTransform) EnumDescriptor() ([]byte, []int) {
+	return file_pdq_v1_pdq_proto_rawDescGZIP(), []int{0}
+}
+
+type HashRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Image []byte `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty"`
+}
+
+func (x *HashRequest) Reset() {
+	*x = HashRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pdq_v1_pdq_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HashRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HashRequest) ProtoMessage() {}
+
+func (x *HashRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pdq_v1_pdq_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HashRequest.ProtoReflect.Descriptor instead.
+func (*HashRequest) Descriptor() ([]byte, []int) {
+	return file_pdq_v1_pdq_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *HashRequest) GetImage() []byte {
+	if x != nil {
+		return x.Image
+	}
+	return nil
+}
+
+type HashResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Hash    string `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	Quality int32  `protobuf:"varint,2,opt,name=quality,proto3" json:"quality,omitempty"`
+}
+
+func (x *HashResponse) Reset() {
+	*x = HashResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pdq_v1_pdq_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HashResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HashResponse) ProtoMessage() {}
+
+func (x *HashResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pdq_v1_pdq_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HashResponse.ProtoReflect.Descriptor instead.
+func (*HashResponse) Descriptor() ([]byte, []int) {
+	return file_pdq_v1_pdq_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *HashResponse) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+func (x *HashResponse) GetQuality() int32 {
+	if x != nil {
+		return x.Quality
+	}
+	return 0
+}
+
+type HashDihedralRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Image []byte `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty"`
+	// Transforms to compute; all of them when empty.
+	Transforms []DihedralTransform `protobuf:"varint,2,rep,packed,name=transforms,proto3,enum=pdq.v1.DihedralTransform" json:"transforms,omitempty"`
+}
+
+func (x *HashDihedralRequest) Reset() {
+	*x = HashDihedralRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pdq_v1_pdq_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HashDihedralRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HashDihedralRequest) ProtoMessage() {}
+
+func (x *HashDihedralRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pdq_v1_pdq_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HashDihedralRequest.ProtoReflect.Descriptor instead.
+func (*HashDihedralRequest) Descriptor() ([]byte, []int) {
+	return file_pdq_v1_pdq_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *HashDihedralRequest) GetImage() []byte {
+	if x != nil {
+		return x.Image
+	}
+	return nil
+}
+
+func (x *HashDihedralRequest) GetTransforms() []DihedralTransform {
+	if x != nil {
+		return x.Transforms
+	}
+	return nil
+}
+
+type TransformHash struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Transform DihedralTransform `protobuf:"varint,1,opt,name=transform,proto3,enum=pdq.v1.DihedralTransform" json:"transform,omitempty"`
+	Hash      string            `protobuf:"bytes,2,opt,name=hash,proto3" json:"hash,omitempty"`
+}
+
+func (x *TransformHash) Reset() {
+	*x = TransformHash{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pdq_v1_pdq_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TransformHash) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransformHash) ProtoMessage() {}
+
+func (x *TransformHash) ProtoReflect() protoreflect.Message {
+	mi := &file_pdq_v1_pdq_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransformHash.ProtoReflect.Descriptor instead.
+func (*TransformHash) Descriptor() ([]byte, []int) {
+	return file_pdq_v1_pdq_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *TransformHash) GetTransform() DihedralTransform {
+	if x != nil {
+		return x.Transform
+	}
+	return DihedralTransform_DIHEDRAL_TRANSFORM_UNSPECIFIED
+}
+
+func (x *TransformHash) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+type HashDihedralResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Quality int32            `protobuf:"varint,1,opt,name=quality,proto3" json:"quality,omitempty"`
+	Hashes  []*TransformHash `protobuf:"bytes,2,rep,name=hashes,proto3" json:"hashes,omitempty"`
+}
+
+func (x *HashDihedralResponse) Reset() {
+	*x = HashDihedralResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pdq_v1_pdq_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HashDihedralResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HashDihedralResponse) ProtoMessage() {}
+
+func (x *HashDihedralResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pdq_v1_pdq_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HashDihedralResponse.ProtoReflect.Descriptor instead.
+func (*HashDihedralResponse) Descriptor() ([]byte, []int) {
+	return file_pdq_v1_pdq_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *HashDihedralResponse) GetQuality() int32 {
+	if x != nil {
+		return x.Quality
+	}
+	return 0
+}
+
+func (x *HashDihedralResponse) GetHashes() []*TransformHash {
+	if x != nil {
+		return x.Hashes
+	}
+	return nil
+}
+
+type HashStreamRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Opaque identifier echoed in the response.
+	Id    string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Image []byte `protobuf:"bytes,2,opt,name=image,proto3" json:"image,omitempty"`
+}
+
+func (x *HashStreamRequest) Reset() {
+	*x = HashStreamRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pdq_v1_pdq_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HashStreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HashStreamRequest) ProtoMessage() {}
+
+func (x *HashStreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pdq_v1_pdq_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HashStreamRequest.ProtoReflect.Descriptor instead.
+func (*HashStreamRequest) Descriptor() ([]byte, []int) {
+	return file_pdq_v1_pdq_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *HashStreamRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *HashStreamRequest) GetImage() []byte {
+	if x != nil {
+		return x.Image
+	}
+	return nil
+}
+
+type HashStreamResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id      string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Hash    string `protobuf:"bytes,2,opt,name=hash,proto3" json:"hash,omitempty"`
+	Quality int32  `protobuf:"varint,3,opt,name=quality,proto3" json:"quality,omitempty"`
+	// Set instead of hash and quality when the image could not be hashed.
+	Error string `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+}
+
+func (x *HashStreamResponse) Reset() {
+	*x = HashStreamResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pdq_v1_pdq_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HashStreamResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HashStreamResponse) ProtoMessage() {}
+
+func (x *HashStreamResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pdq_v1_pdq_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HashStreamResponse.ProtoReflect.Descriptor instead.
+func (*HashStreamResponse) Descriptor() ([]byte, []int) {
+	return file_pdq_v1_pdq_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *HashStreamResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *HashStreamResponse) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+func (x *HashStreamResponse) GetQuality() int32 {
+	if x != nil {
+		return x.Quality
+	}
+	return 0
+}
+
+func (x *HashStreamResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type MatchRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Query:
+	//	*MatchRequest_Hash
+	//	*MatchRequest_Image
+	Query isMatchRequest_Query `protobuf_oneof:"query"`
+	// Maximum Hamming distance; the server default when unset.
+	Threshold *int32 `protobuf:"varint,3,opt,name=threshold,proto3,oneof" json:"threshold,omitempty"`
+	// When positive, return the top_k nearest entries instead.
+	TopK int32 `protobuf:"varint,4,opt,name=top_k,json=topK,proto3" json:"top_k,omitempty"`
+}
+
+func (x *MatchRequest) Reset() {
+	*x = MatchRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pdq_v1_pdq_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MatchRequest) ProtoMessage() {}
+
+func (x *MatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pdq_v1_pdq_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MatchRequest.ProtoReflect.Descriptor instead.
+func (*MatchRequest) Descriptor() ([]byte, []int) {
+	return file_pdq_v1_pdq_proto_rawDescGZIP(), []int{7}
+}
+
+func (m *MatchRequest) GetQuery() isMatchRequest_Query {
+	if m != nil {
+		return m.Query
+	}
+	return nil
+}
+
+func (x *MatchRequest) GetHash() string {
+	if x, ok := x.GetQuery().(*MatchRequest_Hash); ok {
+		return x.Hash
+	}
+	return ""
+}
+
+func (x *MatchRequest) GetImage() []byte {
+	if x, ok := x.GetQuery().(*MatchRequest_Image); ok {
+		return x.Image
+	}
+	return nil
+}
+
+func (x *MatchRequest) GetThreshold() int32 {
+	if x != nil && x.Threshold != nil {
+		return *x.Threshold
+	}
+	return 0
+}
+
+func (x *MatchRequest) GetTopK() int32 {
+	if x != nil {
+		return x.TopK
+	}
+	return 0
+}
+
+type isMatchRequest_Query interface {
+	isMatchRequest_Query()
+}
+
+type MatchRequest_Hash struct {
+	Hash string `protobuf:"bytes,1,opt,name=hash,proto3,oneof"`
+}
+
+type MatchRequest_Image struct {
+	Image []byte `protobuf:"bytes,2,opt,name=image,proto3,oneof"`
+}
+
+func (*MatchRequest_Hash) isMatchRequest_Query() {}
+
+func (*MatchRequest_Image) isMatchRequest_Query() {}
+
+type Match struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Hash     string   `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	Distance int32    `protobuf:"varint,2,opt,name=distance,proto3" json:"distance,omitempty"`
+	Id       string   `protobuf:"bytes,3,opt,name=id,proto3" json:"id,omitempty"`
+	Quality  *int32   `protobuf:"varint,4,opt,name=quality,proto3,oneof" json:"quality,omitempty"`
+	Tags     []string `protobuf:"bytes,5,rep,name=tags,proto3" json:"tags,omitempty"`
+}
+
+func (x *Match) Reset() {
+	*x = Match{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pdq_v1_pdq_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Match) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Match) ProtoMessage() {}
+
+func (x *Match) ProtoReflect() protoreflect.Message {
+	mi := &file_pdq_v1_pdq_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Match.ProtoReflect.Descriptor instead.
+func (*Match) Descriptor() ([]byte, []int) {
+	return file_pdq_v1_pdq_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Match) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+func (x *Match) GetDistance() int32 {
+	if x != nil {
+		return x.Distance
+	}
+	return 0
+}
+
+func (x *Match) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Match) GetQuality() int32 {
+	if x != nil && x.Quality != nil {
+		return *x.Quality
+	}
+	return 0
+}
+
+func (x *Match) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+type MatchResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Hash string `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	// Quality of the query image; unset for hash queries.
+	Quality *int32   `protobuf:"varint,2,opt,name=quality,proto3,oneof" json:"quality,omitempty"`
+	Matches []*Match `protobuf:"bytes,3,rep,name=matches,proto3" json:"matches,omitempty"`
+}
+
+func (x *MatchResponse) Reset() {
+	*x = MatchResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pdq_v1_pdq_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MatchResponse) ProtoMessage() {}
+
+func (x *MatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pdq_v1_pdq_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MatchResponse.ProtoReflect.Descriptor instead.
+func (*MatchResponse) Descriptor() ([]byte, []int) {
+	return file_pdq_v1_pdq_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *MatchResponse) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+func (x *MatchResponse) GetQuality() int32 {
+	if x != nil && x.Quality != nil {
+		return *x.Quality
+	}
+	return 0
+}
+
+func (x *MatchResponse) GetMatches() []*Match {
+	if x != nil {
+		return x.Matches
+	}
+	return nil
+}
+
+var File_pdq_v1_pdq_proto protoreflect.FileDescriptor
+
+var file_pdq_v1_pdq_proto_rawDesc = []byte{
+	0x0a, 0x10, 0x70, 0x64, 0x71, 0x2f, 0x76, 0x31, 0x2f, 0x70, 0x64, 0x71, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x12, 0x06, 0x70, 0x64, 0x71, 0x2e, 0x76, 0x31, 0x22, 0x23, 0x0a, 0x0b, 0x48, 0x61,
+	0x73, 0x68, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x69, 0x6d, 0x61,
+	0x67, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x22,
+	0x3c, 0x0a, 0x0c, 0x48, 0x61, 0x73, 0x68, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
+	0x12, 0x0a, 0x04, 0x68, 0x61, 0x73, 0x68, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x68,
+	0x61, 0x73, 0x68, 0x12, 0x18, 0x0a, 0x07, 0x71, 0x75, 0x61, 0x6c, 0x69, 0x74, 0x79, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x71, 0x75, 0x61, 0x6c, 0x69, 0x74, 0x79, 0x22, 0x66, 0x0a,
+	0x13, 0x48, 0x61, 0x73, 0x68, 0x44, 0x69, 0x68, 0x65, 0x64, 0x72, 0x61, 0x6c, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x05, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x12, 0x39, 0x0a, 0x0a, 0x74, 0x72,
+	0x61, 0x6e, 0x73, 0x66, 0x6f, 0x72, 0x6d, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0e, 0x32, 0x19,
+	0x2e, 0x70, 0x64, 0x71, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x69, 0x68, 0x65, 0x64, 0x72, 0x61, 0x6c,
+	0x54, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x6f, 0x72, 0x6d, 0x52, 0x0a, 0x74, 0x72, 0x61, 0x6e, 0x73,
+	0x66, 0x6f, 0x72, 0x6d, 0x73, 0x22, 0x5c, 0x0a, 0x0d, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x6f,
+	0x72, 0x6d, 0x48, 0x61, 0x73, 0x68, 0x12, 0x37, 0x0a, 0x09, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x66,
+	0x6f, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x19, 0x2e, 0x70, 0x64, 0x71, 0x2e,
+	0x76, 0x31, 0x2e, 0x44, 0x69, 0x68, 0x65, 0x64, 0x72, 0x61, 0x6c, 0x54, 0x72, 0x61, 0x6e, 0x73,
+	0x66, 0x6f, 0x72, 0x6d, 0x52, 0x09, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x6f, 0x72, 0x6d, 0x12,
+	0x12, 0x0a, 0x04, 0x68, 0x61, 0x73, 0x68, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x68,
+	0x61, 0x73, 0x68, 0x22, 0x5f, 0x0a, 0x14, 0x48, 0x61, 0x73, 0x68, 0x44, 0x69, 0x68, 0x65, 0x64,
+	0x72, 0x61, 0x6c, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x71,
+	0x75, 0x61, 0x6c, 0x69, 0x74, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x71, 0x75,
+	0x61, 0x6c, 0x69, 0x74, 0x79, 0x12, 0x2d, 0x0a, 0x06, 0x68, 0x61, 0x73, 0x68, 0x65, 0x73, 0x18,
+	0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x15, 0x2e, 0x70, 0x64, 0x71, 0x2e, 0x76, 0x31, 0x2e, 0x54,
+	0x72, 0x61, 0x6e, 0x73, 0x66, 0x6f, 0x72, 0x6d, 0x48, 0x61, 0x73, 0x68, 0x52, 0x06, 0x68, 0x61,
+	0x73, 0x68, 0x65, 0x73, 0x22, 0x39, 0x0a, 0x11, 0x48, 0x61, 0x73, 0x68, 0x53, 0x74, 0x72, 0x65,
+	0x61, 0x6d, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x69, 0x6d, 0x61,
+	0x67, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x22,
+	0x68, 0x0a, 0x12, 0x48, 0x61, 0x73, 0x68, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x68, 0x61, 0x73, 0x68, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x04, 0x68, 0x61, 0x73, 0x68, 0x12, 0x18, 0x0a, 0x07, 0x71, 0x75, 0x61,
+	0x6c, 0x69, 0x74, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x71, 0x75, 0x61, 0x6c,
+	0x69, 0x74, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x22, 0x8b, 0x01, 0x0a, 0x0c, 0x4d, 0x61,
+	0x74, 0x63, 0x68, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x04, 0x68, 0x61,
+	0x73, 0x68, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x48, 0x00, 0x52, 0x04, 0x68, 0x61, 0x73, 0x68,
+	0x12, 0x16, 0x0a, 0x05, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x48,
+	0x00, 0x52, 0x05, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x12, 0x21, 0x0a, 0x09, 0x74, 0x68, 0x72, 0x65,
+	0x73, 0x68, 0x6f, 0x6c, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x48, 0x01, 0x52, 0x09, 0x74,
+	0x68, 0x72, 0x65, 0x73, 0x68, 0x6f, 0x6c, 0x64, 0x88, 0x01, 0x01, 0x12, 0x13, 0x0a, 0x05, 0x74,
+	0x6f, 0x70, 0x5f, 0x6b, 0x18, 0x04, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x74, 0x6f, 0x70, 0x4b,
+	0x42, 0x07, 0x0a, 0x05, 0x71, 0x75, 0x65, 0x72, 0x79, 0x42, 0x0c, 0x0a, 0x0a, 0x5f, 0x74, 0x68,
+	0x72, 0x65, 0x73, 0x68, 0x6f, 0x6c, 0x64, 0x22, 0x86, 0x01, 0x0a, 0x05, 0x4d, 0x61, 0x74, 0x63,
+	0x68, 0x12, 0x12, 0x0a, 0x04, 0x68, 0x61, 0x73, 0x68, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x04, 0x68, 0x61, 0x73, 0x68, 0x12, 0x1a, 0x0a, 0x08, 0x64, 0x69, 0x73, 0x74, 0x61, 0x6e, 0x63,
+	0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x64, 0x69, 0x73, 0x74, 0x61, 0x6e, 0x63,
+	0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69,
+	0x64, 0x12, 0x1d, 0x0a, 0x07, 0x71, 0x75, 0x61, 0x6c, 0x69, 0x74, 0x79, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x05, 0x48, 0x00, 0x52, 0x07, 0x71, 0x75, 0x61, 0x6c, 0x69, 0x74, 0x79, 0x88, 0x01, 0x01,
+	0x12, 0x12, 0x0a, 0x04, 0x74, 0x61, 0x67, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x09, 0x52, 0x04,
+	0x74, 0x61, 0x67, 0x73, 0x42, 0x0a, 0x0a, 0x08, 0x5f, 0x71, 0x75, 0x61, 0x6c, 0x69, 0x74, 0x79,
+	0x22, 0x77, 0x0a, 0x0d, 0x4d, 0x61, 0x74, 0x63, 0x68, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x12, 0x12, 0x0a, 0x04, 0x68, 0x61, 0x73, 0x68, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x04, 0x68, 0x61, 0x73, 0x68, 0x12, 0x1d, 0x0a, 0x07, 0x71, 0x75, 0x61, 0x6c, 0x69, 0x74, 0x79,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x48, 0x00, 0x52, 0x07, 0x71, 0x75, 0x61, 0x6c, 0x69, 0x74,
+	0x79, 0x88, 0x01, 0x01, 0x12, 0x27, 0x0a, 0x07, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x65, 0x73, 0x18,
+	0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0d, 0x2e, 0x70, 0x64, 0x71, 0x2e, 0x76, 0x31, 0x2e, 0x4d,
+	0x61, 0x74, 0x63, 0x68, 0x52, 0x07, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x65, 0x73, 0x42, 0x0a, 0x0a,
+	0x08, 0x5f, 0x71, 0x75, 0x61, 0x6c, 0x69, 0x74, 0x79, 0x2a, 0xc7, 0x02, 0x0a, 0x11, 0x44, 0x69,
+	0x68, 0x65, 0x64, 0x72, 0x61, 0x6c, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x6f, 0x72, 0x6d, 0x12,
+	0x22, 0x0a, 0x1e, 0x44, 0x49, 0x48, 0x45, 0x44, 0x52, 0x41, 0x4c, 0x5f, 0x54, 0x52, 0x41, 0x4e,
+	0x53, 0x46, 0x4f, 0x52, 0x4d, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45,
+	0x44, 0x10, 0x00, 0x12, 0x1f, 0x0a, 0x1b, 0x44, 0x49, 0x48, 0x45, 0x44, 0x52, 0x41, 0x4c, 0x5f,
+	0x54, 0x52, 0x41, 0x4e, 0x53, 0x46, 0x4f, 0x52, 0x4d, 0x5f, 0x4f, 0x52, 0x49, 0x47, 0x49, 0x4e,
+	0x41, 0x4c, 0x10, 0x01, 0x12, 0x20, 0x0a, 0x1c, 0x44, 0x49, 0x48, 0x45, 0x44, 0x52, 0x41, 0x4c,
+	0x5f, 0x54, 0x52, 0x41, 0x4e, 0x53, 0x46, 0x4f, 0x52, 0x4d, 0x5f, 0x52, 0x4f, 0x54, 0x41, 0x54,
+	0x45, 0x5f, 0x39, 0x30, 0x10, 0x02, 0x12, 0x21, 0x0a, 0x1d, 0x44, 0x49, 0x48, 0x45, 0x44, 0x52,
+	0x41, 0x4c, 0x5f, 0x54, 0x52, 0x41, 0x4e, 0x53, 0x46, 0x4f, 0x52, 0x4d, 0x5f, 0x52, 0x4f, 0x54,
+	0x41, 0x54, 0x45, 0x5f, 0x31, 0x38, 0x30, 0x10, 0x03, 0x12, 0x21, 0x0a, 0x1d, 0x44, 0x49, 0x48,
+	0x45, 0x44, 0x52, 0x41, 0x4c, 0x5f, 0x54, 0x52, 0x41, 0x4e, 0x53, 0x46, 0x4f, 0x52, 0x4d, 0x5f,
+	0x52, 0x4f, 0x54, 0x41, 0x54, 0x45, 0x5f, 0x32, 0x37, 0x30, 0x10, 0x04, 0x12, 0x1d, 0x0a, 0x19,
+	0x44, 0x49, 0x48, 0x45, 0x44, 0x52, 0x41, 0x4c, 0x5f, 0x54, 0x52, 0x41, 0x4e, 0x53, 0x46, 0x4f,
+	0x52, 0x4d, 0x5f, 0x46, 0x4c, 0x49, 0x50, 0x5f, 0x58, 0x10, 0x05, 0x12, 0x1d, 0x0a, 0x19, 0x44,
+	0x49, 0x48, 0x45, 0x44, 0x52, 0x41, 0x4c, 0x5f, 0x54, 0x52, 0x41, 0x4e, 0x53, 0x46, 0x4f, 0x52,
+	0x4d, 0x5f, 0x46, 0x4c, 0x49, 0x50, 0x5f, 0x59, 0x10, 0x06, 0x12, 0x22, 0x0a, 0x1e, 0x44, 0x49,
+	0x48, 0x45, 0x44, 0x52, 0x41, 0x4c, 0x5f, 0x54, 0x52, 0x41, 0x4e, 0x53, 0x46, 0x4f, 0x52, 0x4d,
+	0x5f, 0x46, 0x4c, 0x49, 0x50, 0x5f, 0x50, 0x4c, 0x55, 0x53, 0x5f, 0x31, 0x10, 0x07, 0x12, 0x23,
+	0x0a, 0x1f, 0x44, 0x49, 0x48, 0x45, 0x44, 0x52, 0x41, 0x4c, 0x5f, 0x54, 0x52, 0x41, 0x4e, 0x53,
+	0x46, 0x4f, 0x52, 0x4d, 0x5f, 0x46, 0x4c, 0x49, 0x50, 0x5f, 0x4d, 0x49, 0x4e, 0x55, 0x53, 0x5f,
+	0x31, 0x10, 0x08, 0x32, 0x89, 0x02, 0x0a, 0x0a, 0x50, 0x44, 0x51, 0x53, 0x65, 0x72, 0x76, 0x69,
+	0x63, 0x65, 0x12, 0x31, 0x0a, 0x04, 0x48, 0x61, 0x73, 0x68, 0x12, 0x13, 0x2e, 0x70, 0x64, 0x71,
+	0x2e, 0x76, 0x31, 0x2e, 0x48, 0x61, 0x73, 0x68, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x14, 0x2e, 0x70, 0x64, 0x71, 0x2e, 0x76, 0x31, 0x2e, 0x48, 0x61, 0x73, 0x68, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x49, 0x0a, 0x0c, 0x48, 0x61, 0x73, 0x68, 0x44, 0x69, 0x68,
+	0x65, 0x64, 0x72, 0x61, 0x6c, 0x12, 0x1b, 0x2e, 0x70, 0x64, 0x71, 0x2e, 0x76, 0x31, 0x2e, 0x48,
+	0x61, 0x73, 0x68, 0x44, 0x69, 0x68, 0x65, 0x64, 0x72, 0x61, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x1c, 0x2e, 0x70, 0x64, 0x71, 0x2e, 0x76, 0x31, 0x2e, 0x48, 0x61, 0x73, 0x68,
+	0x44, 0x69, 0x68, 0x65, 0x64, 0x72, 0x61, 0x6c, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x12, 0x47, 0x0a, 0x0a, 0x48, 0x61, 0x73, 0x68, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x12, 0x19,
+	0x2e, 0x70, 0x64, 0x71, 0x2e, 0x76, 0x31, 0x2e, 0x48, 0x61, 0x73, 0x68, 0x53, 0x74, 0x72, 0x65,
+	0x61, 0x6d, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1a, 0x2e, 0x70, 0x64, 0x71, 0x2e,
+	0x76, 0x31, 0x2e, 0x48, 0x61, 0x73, 0x68, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x28, 0x01, 0x30, 0x01, 0x12, 0x34, 0x0a, 0x05, 0x4d, 0x61, 0x74,
+	0x63, 0x68, 0x12, 0x14, 0x2e, 0x70, 0x64, 0x71, 0x2e, 0x76, 0x31, 0x2e, 0x4d, 0x61, 0x74, 0x63,
+	0x68, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x15, 0x2e, 0x70, 0x64, 0x71, 0x2e, 0x76,
+	0x31, 0x2e, 0x4d, 0x61, 0x74, 0x63, 0x68, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42,
+	0x32, 0x5a, 0x30, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x4d, 0x54,
+	0x52, 0x4e, 0x6f, 0x72, 0x64, 0x2f, 0x70, 0x64, 0x71, 0x68, 0x61, 0x73, 0x68, 0x2d, 0x67, 0x6f,
+	0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x70, 0x64, 0x71, 0x2f, 0x76, 0x31, 0x3b, 0x70, 0x64,
+	0x71, 0x76, 0x31, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_pdq_v1_pdq_proto_rawDescOnce sync.Once
+	file_pdq_v1_pdq_proto_rawDescData = file_pdq_v1_pdq_proto_rawDesc
+)
+
+func file_pdq_v1_pdq_proto_rawDescGZIP() []byte {
+	file_pdq_v1_pdq_proto_rawDescOnce.Do(func() {
+		file_pdq_v1_pdq_proto_rawDescData = protoimpl.X.CompressGZIP(file_pdq_v1_pdq_proto_rawDescData)
+	})
+	return file_pdq_v1_pdq_proto_rawDescData
+}
+
+var file_pdq_v1_pdq_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_pdq_v1_pdq_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_pdq_v1_pdq_proto_goTypes = []interface{}{
+	(DihedralTransform)(0),       // 0: pdq.v1.DihedralTransform
+	(*HashRequest)(nil),          // 1: pdq.v1.HashRequest
+	(*HashResponse)(nil),         // 2: pdq.v1.HashResponse
+	(*HashDihedralRequest)(nil),  // 3: pdq.v1.HashDihedralRequest
+	(*TransformHash)(nil),        // 4: pdq.v1.TransformHash
+	(*HashDihedralResponse)(nil), // 5: pdq.v1.HashDihedralResponse
+	(*HashStreamRequest)(nil),    // 6: pdq.v1.HashStreamRequest
+	(*HashStreamResponse)(nil),   // 7: pdq.v1.HashStreamResponse
+	(*MatchRequest)(nil),         // 8: pdq.v1.MatchRequest
+	(*Match)(nil),                // 9: pdq.v1.Match
+	(*MatchResponse)(nil),        // 10: pdq.v1.MatchResponse
+}
+var file_pdq_v1_pdq_proto_depIdxs = []int32{
+	0,  // 0: pdq.v1.HashDihedralRequest.transforms:type_name -> pdq.v1.DihedralTransform
+	0,  // 1: pdq.v1.TransformHash.transform:type_name -> pdq.v1.DihedralTransform
+	4,  // 2: pdq.v1.HashDihedralResponse.hashes:type_name -> pdq.v1.TransformHash
+	9,  // 3: pdq.v1.MatchResponse.matches:type_name -> pdq.v1.Match
+	1,  // 4: pdq.v1.PDQService.Hash:input_type -> pdq.v1.HashRequest
+	3,  // 5: pdq.v1.PDQService.HashDihedral:input_type -> pdq.v1.HashDihedralRequest
+	6,  // 6: pdq.v1.PDQService.HashStream:input_type -> pdq.v1.HashStreamRequest
+	8,  // 7: pdq.v1.PDQService.Match:input_type -> pdq.v1.MatchRequest
+	2,  // 8: pdq.v1.PDQService.Hash:output_type -> pdq.v1.HashResponse
+	5,  // 9: pdq.v1.PDQService.HashDihedral:output_type -> pdq.v1.HashDihedralResponse
+	7,  // 10: pdq.v1.PDQService.HashStream:output_type -> pdq.v1.HashStreamResponse
+	10, // 11: pdq.v1.PDQService.Match:output_type -> pdq.v1.MatchResponse
+	8,  // [8:12] is the sub-list for method output_type
+	4,  // [4:8] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_pdq_v1_pdq_proto_init() }
+func file_pdq_v1_pdq_proto_init() {
+	if File_pdq_v1_pdq_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_pdq_v1_pdq_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HashRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pdq_v1_pdq_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HashResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pdq_v1_pdq_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HashDihedralRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pdq_v1_pdq_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TransformHash); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pdq_v1_pdq_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HashDihedralResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pdq_v1_pdq_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HashStreamRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pdq_v1_pdq_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HashStreamResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pdq_v1_pdq_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MatchRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pdq_v1_pdq_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Match); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pdq_v1_pdq_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MatchResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_pdq_v1_pdq_proto_msgTypes[7].OneofWrappers = []interface{}{
+		(*MatchRequest_Hash)(nil),
+		(*MatchRequest_Image)(nil),
+	}
+	file_pdq_v1_pdq_proto_msgTypes[8].OneofWrappers = []interface{}{}
+	file_pdq_v1_pdq_proto_msgTypes[9].OneofWrappers = []interface{}{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_pdq_v1_pdq_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_pdq_v1_pdq_proto_goTypes,
+		DependencyIndexes: file_pdq_v1_pdq_proto_depIdxs,
+		EnumInfos:         file_pdq_v1_pdq_proto_enumTypes,
+		MessageInfos:      file_pdq_v1_pdq_proto_msgTypes,
+	}.Build()
+	File_pdq_v1_pdq_proto = out.File
+	file_pdq_v1_pdq_proto_rawDesc = nil
+	file_pdq_v1_pdq_proto_goTypes = nil
+	file_pdq_v1_pdq_proto_depIdxs = nil
+}
@@ -0,0 +1,269 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: pdq/v1/pdq.proto
+
+package pdqv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	PDQService_Hash_FullMethodName         = "/pdq.v1.PDQService/Hash"
+	PDQService_HashDihedral_FullMethodName = "/pdq.v1.PDQService/HashDihedral"
+	PDQService_HashStream_FullMethodName   = "/pdq.v1.PDQService/HashStream"
+	PDQService_Match_FullMethodName        = "/pdq.v1.PDQService/Match"
+)
+
+// PDQServiceClient is the client API for PDQService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PDQServiceClient interface {
+	// Hash computes the PDQ hash of an encoded image.
+	Hash(ctx context.Context, in *HashRequest, opts ...grpc.CallOption) (*HashResponse, error)
+	// HashDihedral computes the hashes of an image and its seven dihedral
+	// transforms from a single pass over the pixels.
+	HashDihedral(ctx context.Context, in *HashDihedralRequest, opts ...grpc.CallOption) (*HashDihedralResponse, error)
+	// HashStream hashes a stream of images. Responses arrive in request order,
+	// and a failing image is reported in its response instead of ending the
+	// stream.
+	HashStream(ctx context.Context, opts ...grpc.CallOption) (PDQService_HashStreamClient, error)
+	// Match looks a hash or image up in the index, either by distance
+	// threshold or as the top_k nearest entries.
+	Match(ctx context.Context, in *MatchRequest, opts ...grpc.CallOption) (*MatchResponse, error)
+}
+
+type pDQServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPDQServiceClient(cc grpc.ClientConnInterface) PDQServiceClient {
+	return &pDQServiceClient{cc}
+}
+
+func (c *pDQServiceClient) Hash(ctx context.Context, in *HashRequest, opts ...grpc.CallOption) (*HashResponse, error) {
+	out := new(HashResponse)
+	err := c.cc.Invoke(ctx, PDQService_Hash_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pDQServiceClient) HashDihedral(ctx context.Context, in *HashDihedralRequest, opts ...grpc.CallOption) (*HashDihedralResponse, error) {
+	out := new(HashDihedralResponse)
+	err := c.cc.Invoke(ctx, PDQService_HashDihedral_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pDQServiceClient) HashStream(ctx context.Context, opts ...grpc.CallOption) (PDQService_HashStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &PDQService_ServiceDesc.Streams[0], PDQService_HashStream_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &pDQServiceHashStreamClient{stream}
+	return x, nil
+}
+
+type PDQService_HashStreamClient interface {
+	Send(*HashStreamRequest) error
+	Recv() (*HashStreamResponse, error)
+	grpc.ClientStream
+}
+
+type pDQServiceHashStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *pDQServiceHashStreamClient) Send(m *HashStreamRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *pDQServiceHashStreamClient) Recv() (*HashStreamResponse, error) {
+	m := new(HashStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *pDQServiceClient) Match(ctx context.Context, in *MatchRequest, opts ...grpc.CallOption) (*MatchResponse, error) {
+	out := new(MatchResponse)
+	err := c.cc.Invoke(ctx, PDQService_Match_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PDQServiceServer is the server API for PDQService service.
+// All implementations must embed UnimplementedPDQServiceServer
+// for forward compatibility
+type PDQServiceServer interface {
+	// Hash computes the PDQ hash of an encoded image.
+	Hash(context.Context, *HashRequest) (*HashResponse, error)
+	// HashDihedral computes the hashes of an image and its seven dihedral
+	// transforms from a single pass over the pixels.
+	HashDihedral(context.Context, *HashDihedralRequest) (*HashDihedralResponse, error)
+	// HashStream hashes a stream of images. Responses arrive in request order,
+	// and a failing image is reported in its response instead of ending the
+	// stream.
+	HashStream(PDQService_HashStreamServer) error
+	// Match looks a hash or image up in the index, either by distance
+	// threshold or as the top_k nearest entries.
+	Match(context.Context, *MatchRequest) (*MatchResponse, error)
+	mustEmbedUnimplementedPDQServiceServer()
+}
+
+// UnimplementedPDQServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedPDQServiceServer struct {
+}
+
+func (UnimplementedPDQServiceServer) Hash(context.Context, *HashRequest) (*HashResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Hash not implemented")
+}
+func (UnimplementedPDQServiceServer) HashDihedral(context.Context, *HashDihedralRequest) (*HashDihedralResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HashDihedral not implemented")
+}
+func (UnimplementedPDQServiceServer) HashStream(PDQService_HashStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method HashStream not implemented")
+}
+func (UnimplementedPDQServiceServer) Match(context.Context, *MatchRequest) (*MatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Match not implemented")
+}
+func (UnimplementedPDQServiceServer) mustEmbedUnimplementedPDQServiceServer() {}
+
+// UnsafePDQServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PDQServiceServer will
+// result in compilation errors.
+type UnsafePDQServiceServer interface {
+	mustEmbedUnimplementedPDQServiceServer()
+}
+
+func RegisterPDQServiceServer(s grpc.ServiceRegistrar, srv PDQServiceServer) {
+	s.RegisterService(&PDQService_ServiceDesc, srv)
+}
+
+func _PDQService_Hash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PDQServiceServer).Hash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PDQService_Hash_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PDQServiceServer).Hash(ctx, req.(*HashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PDQService_HashDihedral_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HashDihedralRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PDQServiceServer).HashDihedral(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PDQService_HashDihedral_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PDQServiceServer).HashDihedral(ctx, req.(*HashDihedralRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PDQService_HashStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PDQServiceServer).HashStream(&pDQServiceHashStreamServer{stream})
+}
+
+type PDQService_HashStreamServer interface {
+	Send(*HashStreamResponse) error
+	Recv() (*HashStreamRequest, error)
+	grpc.ServerStream
+}
+
+type pDQServiceHashStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *pDQServiceHashStreamServer) Send(m *HashStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *pDQServiceHashStreamServer) Recv() (*HashStreamRequest, error) {
+	m := new(HashStreamRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _PDQService_Match_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PDQServiceServer).Match(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PDQService_Match_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PDQServiceServer).Match(ctx, req.(*MatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PDQService_ServiceDesc is the grpc.ServiceDesc for PDQService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PDQService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pdq.v1.PDQService",
+	HandlerType: (*PDQServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Hash",
+			Handler:    _PDQService_Hash_Handler,
+		},
+		{
+			MethodName: "HashDihedral",
+			Handler:    _PDQService_HashDihedral_Handler,
+		},
+		{
+			MethodName: "Match",
+			Handler:    _PDQService_Match_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "HashStream",
+			Handler:       _PDQService_HashStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "pdq/v1/pdq.proto",
+}
@@ -0,0 +1,75 @@
+package pdq
+
+import (
+	"fmt"
+)
+
+// What a PDQHasher does with hashes whose quality is below MinQuality.
+// Upstream recommends not trusting hashes of quality below 50: they come
+// from images with too little structure for the bits to be stable.
+const QUALITY_POLICY_KEEP = "keep"
+const QUALITY_POLICY_FLAG = "flag"
+const QUALITY_POLICY_REJECT = "reject"
+
+var QUALITY_POLICIES = []string{QUALITY_POLICY_KEEP, QUALITY_POLICY_FLAG, QUALITY_POLICY_REJECT}
+
+const DEFAULT_MIN_QUALITY = 50
+
+// Why a hash has low quality.
+const LOW_QUALITY_TINY = "tiny"
+const LOW_QUALITY_FLAT = "flat"
+const LOW_QUALITY_LOW_DETAIL = "low_detail"
+
+// Images narrower or shorter than this are upscaled by the 64x64
+// decimation, so their hash describes interpolation more than content.
+const TINY_IMAGE_DIMENSION = 64
+
+// Largest luma range (0..255) of the decimated image that counts as flat.
+const FLAT_LUMA_RANGE = 16.0
+
+// Failure reason for hashes rejected by QUALITY_POLICY_REJECT.
+const FAILURE_LOW_QUALITY = "low_quality"
+
+// LowQualityError is wrapped in the *HashError returned for images
+// rejected by QUALITY_POLICY_REJECT.
+type LowQualityError struct {
+	Quality    int
+	MinQuality int
+	// One of the LOW_QUALITY_* reasons.
+	Reason string
+}
+
+func (e *LowQualityError) Error() string {
+	return fmt.Sprintf("quality %d below minimum %d (%s)", e.Quality, e.MinQuality, e.Reason)
+}
+
+// Returns the LOW_QUALITY_* reason for a hash below MinQuality, or "" if
+// the policy does not look at quality or the hash is good enough.
+func (p *PDQHasher) lowQuality(quality, numRows, numCols int, buffer64x64 []float64) string {
+	if p.QualityPolicy == "" || p.QualityPolicy == QUALITY_POLICY_KEEP || quality >= p.MinQuality {
+		return ""
+	}
+	return lowQualityReason(numRows, numCols, buffer64x64)
+}
+
+/**
+ * numRows and numCols are those of the decoded image, or of the part of it
+ * left after trimming borders, not of the thumbnail: a 2000x100 image
+ * thumbnails to 512x26, but has plenty of pixels along both sides.
+ */
+func lowQualityReason(numRows, numCols int, buffer64x64 []float64) string {
+	if numRows < TINY_IMAGE_DIMENSION || numCols < TINY_IMAGE_DIMENSION {
+		return LOW_QUALITY_TINY
+	}
+
+	lo := buffer64x64[0]
+	hi := lo
+	for _, v := range buffer64x64 {
+		lo = min(lo, v)
+		hi = max(hi, v)
+	}
+	if hi-lo < FLAT_LUMA_RANGE {
+		return LOW_QUALITY_FLAT
+	}
+	return LOW_QUALITY_LOW_DETAIL
+}
@@ -0,0 +1,293 @@
+package pdq
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// Longest side of the thumbnail that regions are cut from. Larger than
+// THUMBNAIL_SIZE, so that each tile of a 3x3 grid still covers more
+// pixels than the 64x64 grid it is decimated to.
+const REGION_THUMBNAIL_SIZE = 1024
+
+/**
+ * Places the regions to hash in a decoded image of width x height pixels.
+ * Boxes that are empty or not within the image, such as the tiles of a
+ * grid finer than the image, fail on their own.
+ */
+type RegionLayout func(width, height int) []CropBox
+
+// The given boxes, whatever the size of the image.
+func Boxes(boxes ...CropBox) RegionLayout {
+	return func(width, height int) []CropBox {
+		return boxes
+	}
+}
+
+// A grid of rows x cols tiles covering the whole image, row by row. Tile
+// edges are rounded down, so tiles differ in size by at most a pixel.
+func Tiles(rows, cols int) RegionLayout {
+	return func(width, height int) []CropBox {
+		var boxes []CropBox
+		for i := 0; i < rows; i++ {
+			for j := 0; j < cols; j++ {
+				boxes = append(boxes, CropBox{
+					Left:   j * width / cols,
+					Top:    i * height / rows,
+					Right:  (j + 1) * width / cols,
+					Bottom: (i + 1) * height / rows,
+				})
+			}
+		}
+		return boxes
+	}
+}
+
+/**
+ * Sliding windows of size times the width and height of the image, moved
+ * by stride times the width and height, row by row. The last window along
+ * each side is moved flush with the edge, so that the whole image is
+ * covered. Windows are at least a pixel and at most the image; strides at
+ * least a pixel.
+ */
+func Windows(size, stride float64) RegionLayout {
+	return func(width, height int) []CropBox {
+		windowWidth := min(max(int(math.Round(size*float64(width))), 1), width)
+		windowHeight := min(max(int(math.Round(size*float64(height))), 1), height)
+		strideX := max(int(math.Round(stride*float64(width))), 1)
+		strideY := max(int(math.Round(stride*float64(height))), 1)
+
+		var boxes []CropBox
+		for _, top := range windowStarts(height, windowHeight, strideY) {
+			for _, left := range windowStarts(width, windowWidth, strideX) {
+				boxes = append(boxes, CropBox{left, top, left + windowWidth, top + windowHeight})
+			}
+		}
+		return boxes
+	}
+}
+
+func windowStarts(length, window, stride int) []int {
+	var starts []int
+	for start := 0; ; start += stride {
+		if start+window >= length {
+			return append(starts, length-window)
+		}
+		starts = append(starts, start)
+	}
+}
+
+/**
+ * The hash of one region. Width and Height are those of the box, and Crop
+ * is in pixels of the whole image. Err is set if this region alone could
+ * not be hashed: its box is empty or outside the image, or, with
+ * QUALITY_POLICY_REJECT, a *LowQualityError.
+ */
+type RegionHash struct {
+	Box CropBox
+	HashAndQuality
+	Err error
+}
+
+// Hashes the regions of an encoded image placed by layout, decoding it
+// only once.
+func (p *PDQHasher) RegionsFromBuffer(buf []byte, layout RegionLayout) ([]RegionHash, error) {
+	return p.RegionsFromBufferContext(context.Background(), buf, layout)
+}
+
+func (p *PDQHasher) RegionsFromBufferContext(ctx context.Context, buf []byte, layout RegionLayout) ([]RegionHash, error) {
+	t := p.startHash(ctx)
+
+	image, err := p.decodeImage(t, buf)
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
+
+	regions, err := p.regionsFromLoadedImage(t, image, layout)
+	if err != nil {
+		return nil, err
+	}
+	t.end(lowestQuality(regions))
+	return regions, nil
+}
+
+// Hashes an encoded image as FromBuffer does, and its regions placed by
+// layout as RegionsFromBuffer does, decoding it only once.
+func (p *PDQHasher) FromBufferWithRegions(buf []byte, layout RegionLayout) (HashAndQuality, []RegionHash, error) {
+	return p.FromBufferWithRegionsContext(context.Background(), buf, layout)
+}
+
+func (p *PDQHasher) FromBufferWithRegionsContext(ctx context.Context, buf []byte, layout RegionLayout) (HashAndQuality, []RegionHash, error) {
+	t := p.startHash(ctx)
+
+	image, err := p.decodeImage(t, buf)
+	if err != nil {
+		return HashAndQuality{}, nil, err
+	}
+	defer image.Close()
+
+	// Both thumbnails are made in place, so the regions get their own
+	// reference to the decoded image. The copy is cheap and not timed.
+	copied, err := image.Copy()
+	if err != nil {
+		return HashAndQuality{}, nil, t.failAt(STAGE_DECODE, FAILURE_PROCESSING, fmt.Errorf("error copying image: %w", err))
+	}
+	defer copied.Close()
+
+	hashAndQuality, err := p.fromLoadedImage(t, image)
+	if err != nil {
+		return HashAndQuality{}, nil, err
+	}
+	regions, err := p.regionsFromLoadedImage(t, copied, layout)
+	if err != nil {
+		return HashAndQuality{}, nil, err
+	}
+	t.end(hashAndQuality.Quality)
+	return hashAndQuality, regions, nil
+}
+
+// Hashes the regions of an image that is already loaded, such as one a
+// face detector ran on. The image itself is left unchanged.
+func (p *PDQHasher) RegionsFromImage(image *vips.ImageRef, layout RegionLayout) ([]RegionHash, error) {
+	t := p.startHash(context.Background())
+
+	copied, err := image.Copy()
+	if err != nil {
+		return nil, t.failAt(STAGE_DECODE, FAILURE_PROCESSING, fmt.Errorf("error copying image: %w", err))
+	}
+	defer copied.Close()
+
+	regions, err := p.regionsFromLoadedImage(t, copied, layout)
+	if err != nil {
+		return nil, err
+	}
+	t.end(lowestQuality(regions))
+	return regions, nil
+}
+
+// Hashes one rectangle of a loaded image, as RegionsFromImage.
+func (p *PDQHasher) RegionFromImage(image *vips.ImageRef, box CropBox) (HashAndQuality, error) {
+	regions, err := p.RegionsFromImage(image, Boxes(box))
+	if err != nil {
+		return HashAndQuality{}, err
+	}
+	return regions[0].HashAndQuality, regions[0].Err
+}
+
+/**
+ * Computes the luma of the whole image once, at REGION_THUMBNAIL_SIZE, and
+ * hashes each region from its part of it. Since that thumbnail is larger
+ * than the one of FromBuffer, a region covering the whole image hashes
+ * close to, but not always equal to, FromBuffer. Boxes are in stored
+ * pixels, whatever the orientation mode, as a detector that ran on the
+ * image would give them. The caller ends the trace.
+ */
+func (p *PDQHasher) regionsFromLoadedImage(t *hashTrace, image *vips.ImageRef, layout RegionLayout) ([]RegionHash, error) {
+	width := image.Width()
+	height := image.Height()
+
+	boxes := layout(width, height)
+	if len(boxes) == 0 {
+		return nil, t.failAt(STAGE_THUMBNAIL, FAILURE_PROCESSING, fmt.Errorf("no regions in the %dx%d image", width, height))
+	}
+
+	_, err := p.thumbnailTo(t, image, REGION_THUMBNAIL_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	numCols := image.Width()
+	numRows := image.Height()
+
+	// Regions are cut from the luma, which is kept in float64 whatever the
+	// precision, and hashed in the precision of the hasher.
+	luma := make([]float64, numCols*numRows)
+	var buffer1, buffer2, buffer64x64, buffer16x64, buffer16x16 []float64
+	var buffer1Float32, buffer2Float32 []float32
+	if p.Precision == PRECISION_FLOAT32 {
+		buffer1Float32 = make([]float32, numCols*numRows)
+		buffer2Float32 = make([]float32, numCols*numRows)
+	} else {
+		buffer1 = make([]float64, numCols*numRows)
+		buffer2 = make([]float64, numCols*numRows)
+		buffer64x64 = make([]float64, 64*64)
+		buffer16x64 = make([]float64, 16*64)
+		buffer16x16 = make([]float64, 16*16)
+	}
+
+	err = p.fillLuma(t, image, &luma)
+	if err != nil {
+		return nil, err
+	}
+
+	// A rejected region must not fail the whole trace, so regions are
+	// flagged and rejected here instead.
+	hasher := p
+	if p.QualityPolicy == QUALITY_POLICY_REJECT {
+		flagging := *p
+		flagging.QualityPolicy = QUALITY_POLICY_FLAG
+		hasher = &flagging
+	}
+
+	regions := make([]RegionHash, len(boxes))
+	for i, box := range boxes {
+		if box.Left < 0 || box.Top < 0 || box.Right > width || box.Bottom > height || box.Left >= box.Right || box.Top >= box.Bottom {
+			regions[i] = RegionHash{Box: box, Err: fmt.Errorf("region %s is empty or outside the %dx%d image", box.String(), width, height)}
+			continue
+		}
+		b := *box.scale(width, height, numCols, numRows)
+		b.Right = min(max(b.Right, b.Left+1), numCols)
+		b.Bottom = min(max(b.Bottom, b.Top+1), numRows)
+		b.Left = min(b.Left, b.Right-1)
+		b.Top = min(b.Top, b.Bottom-1)
+		rows := b.Bottom - b.Top
+		cols := b.Right - b.Left
+		var hashAndQuality HashAndQuality
+		var err error
+		if p.Precision == PRECISION_FLOAT32 {
+			for k := 0; k < rows; k++ {
+				src := luma[(b.Top+k)*numCols+b.Left:]
+				dst := buffer1Float32[k*cols : (k+1)*cols]
+				for l := range dst {
+					dst[l] = float32(src[l])
+				}
+			}
+			hashAndQuality, err = hasher.pdqHash256FromFloat32Luma(t, buffer1Float32, buffer2Float32, rows, cols, box.Right-box.Left, box.Bottom-box.Top)
+		} else {
+			for k := 0; k < rows; k++ {
+				src := (b.Top+k)*numCols + b.Left
+				copy(buffer1[k*cols:(k+1)*cols], luma[src:src+cols])
+			}
+			hashAndQuality, err = hasher.pdqHash256FromFloatLuma(t, buffer1, buffer2, rows, cols, box.Right-box.Left, box.Bottom-box.Top, buffer64x64, buffer16x64, buffer16x16)
+		}
+		if err != nil {
+			return nil, err
+		}
+		hashAndQuality.Width = box.Right - box.Left
+		hashAndQuality.Height = box.Bottom - box.Top
+		if crop := hashAndQuality.Crop.scale(cols, rows, hashAndQuality.Width, hashAndQuality.Height); crop != nil {
+			hashAndQuality.Crop = &CropBox{box.Left + crop.Left, box.Top + crop.Top, box.Left + crop.Right, box.Top + crop.Bottom}
+		}
+
+		regions[i] = RegionHash{Box: box, HashAndQuality: hashAndQuality}
+		if hashAndQuality.LowQuality != "" && p.QualityPolicy == QUALITY_POLICY_REJECT {
+			regions[i].Err = &LowQualityError{hashAndQuality.Quality, p.MinQuality, hashAndQuality.LowQuality}
+		}
+	}
+	return regions, nil
+}
+
+// The lowest quality among the regions that were hashed, which is what
+// their trace reports, or -1 if there were none.
+func lowestQuality(regions []RegionHash) int {
+	lowest := -1
+	for _, region := range regions {
+		if region.Hash != nil && (lowest < 0 || region.Quality < lowest) {
+			lowest = region.Quality
+		}
+	}
+	return lowest
+}
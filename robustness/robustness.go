@@ -0,0 +1,218 @@
+// Package robustness measures how far PDQ hashes move under common image
+// edits. Each original of a corpus is transformed (see ParseTransform),
+// the original and its variants are hashed, and the distances between
+// them are summarised per transform as distributions and as the recall a
+// match threshold would give.
+package robustness
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"sort"
+	"sync"
+
+	// Decoders for the originals, besides PNG.
+	_ "image/gif"
+	_ "image/jpeg"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/MTRNord/pdqhash-go/index"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+type Options struct {
+	Transforms []Transform
+	// Distances at which recall is reported.
+	Thresholds []int
+	// Number of originals evaluated in parallel; values below 1 mean 1.
+	Workers int
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Transforms: DefaultTransforms(),
+		Thresholds: []int{16, index.DEFAULT_MATCH_THRESHOLD, 48},
+		Workers:    1,
+	}
+}
+
+// The distance between an original and one of its variants. Err is set
+// when the original or the variant could not be hashed.
+type Sample struct {
+	Path      string
+	Transform string
+	Distance  int
+	// Quality of the variant's hash.
+	Quality int
+	Err     error
+}
+
+type Recall struct {
+	Threshold int     `json:"threshold"`
+	Recall    float64 `json:"recall"`
+}
+
+// The distribution of distances for one transform. Recall counts
+// failures as misses.
+type Stats struct {
+	Transform string   `json:"transform"`
+	Samples   int      `json:"samples"`
+	Failed    int      `json:"failed"`
+	Mean      float64  `json:"mean"`
+	Min       int      `json:"min"`
+	P50       int      `json:"p50"`
+	P90       int      `json:"p90"`
+	Max       int      `json:"max"`
+	Recall    []Recall `json:"recall"`
+}
+
+type Report struct {
+	// Per original, then per transform, in the order of Options.
+	Samples    []Sample
+	Transforms []string
+	Thresholds []int
+}
+
+/**
+ * Evaluates every transform on every original. Originals are decoded in
+ * Go, as JPEG, PNG, GIF, BMP, TIFF or WebP, and both they and their variants are hashed from
+ * a lossless PNG encoding, so that distances come from the transform
+ * alone and not from differences between decoders.
+ */
+func Evaluate(paths []string, opts Options) *Report {
+	report := &Report{Thresholds: opts.Thresholds}
+	for _, t := range opts.Transforms {
+		report.Transforms = append(report.Transforms, t.Name)
+	}
+
+	samples := make([][]Sample, len(paths))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < max(opts.Workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hasher := pdq.NewPDQHasher()
+			for j := range jobs {
+				samples[j] = evaluateOne(hasher, paths[j], opts.Transforms)
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, s := range samples {
+		report.Samples = append(report.Samples, s...)
+	}
+	return report
+}
+
+func evaluateOne(hasher *pdq.PDQHasher, path string, transforms []Transform) []Sample {
+	samples := make([]Sample, len(transforms))
+	for i, t := range transforms {
+		samples[i] = Sample{Path: path, Transform: t.Name}
+	}
+	fail := func(err error) []Sample {
+		for i := range samples {
+			samples[i].Err = err
+		}
+		return samples
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fail(err)
+	}
+	src, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return fail(err)
+	}
+	original, err := hashImage(hasher, src)
+	if err != nil {
+		return fail(err)
+	}
+
+	for i, t := range transforms {
+		variant, err := t.Apply(src)
+		if err != nil {
+			samples[i].Err = err
+			continue
+		}
+		hash, err := hashImage(hasher, variant)
+		if err != nil {
+			samples[i].Err = err
+			continue
+		}
+		samples[i].Distance = hash.Hash.HammingDistance(original.Hash)
+		samples[i].Quality = hash.Quality
+	}
+	return samples
+}
+
+func hashImage(hasher *pdq.PDQHasher, img image.Image) (pdq.HashAndQuality, error) {
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := enc.Encode(&buf, img); err != nil {
+		return pdq.HashAndQuality{}, err
+	}
+	return hasher.FromBuffer(buf.Bytes())
+}
+
+// Summarises the samples of each transform, in the order evaluated.
+func (r *Report) Stats() []Stats {
+	byTransform := map[string][]Sample{}
+	for _, s := range r.Samples {
+		byTransform[s.Transform] = append(byTransform[s.Transform], s)
+	}
+	stats := make([]Stats, 0, len(r.Transforms))
+	for _, name := range r.Transforms {
+		stats = append(stats, summarise(name, byTransform[name], r.Thresholds))
+	}
+	return stats
+}
+
+func summarise(transform string, samples []Sample, thresholds []int) Stats {
+	s := Stats{Transform: transform, Samples: len(samples)}
+	var distances []int
+	total := 0
+	for _, sample := range samples {
+		if sample.Err != nil {
+			s.Failed++
+			continue
+		}
+		distances = append(distances, sample.Distance)
+		total += sample.Distance
+	}
+	sort.Ints(distances)
+	if n := len(distances); n > 0 {
+		s.Mean = float64(total) / float64(n)
+		s.Min = distances[0]
+		s.P50 = percentile(distances, 50)
+		s.P90 = percentile(distances, 90)
+		s.Max = distances[n-1]
+	}
+	for _, threshold := range thresholds {
+		recall := Recall{Threshold: threshold}
+		if len(samples) > 0 {
+			matched := sort.SearchInts(distances, threshold+1)
+			recall.Recall = float64(matched) / float64(len(samples))
+		}
+		s.Recall = append(s.Recall, recall)
+	}
+	return s
+}
+
+// Nearest-rank percentile of sorted values.
+func percentile(sorted []int, p int) int {
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+	return sorted[max(rank-1, 0)]
+}
@@ -0,0 +1,185 @@
+package robustness
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/davidbyttow/govips/v2/vips"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+const BRIDGE_ORIGINAL = "../test-images/reg-test-input/dih/bridge-1-original.jpg"
+
+func TestMain(m *testing.M) {
+	vips.LoggingSettings(nil, vips.LogLevelMessage)
+	vips.Startup(&vips.Config{
+		ConcurrencyLevel: 0,
+		MaxCacheFiles:    5,
+		MaxCacheMem:      50 * 1024 * 1024,
+		MaxCacheSize:     100,
+		ReportLeaks:      false,
+		CacheTrace:       false,
+		CollectStats:     false,
+	})
+	defer vips.Shutdown()
+
+	os.Exit(m.Run())
+}
+
+// A 4x2 image whose pixels all differ.
+func testImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 60), uint8(y * 200), 7, 255})
+		}
+	}
+	return img
+}
+
+func apply(t *testing.T, name string, src image.Image) image.Image {
+	transform, err := ParseTransform(name)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, name, transform.Name)
+	img, err := transform.Apply(src)
+	assert.ErrorIs(t, err, nil)
+	return img
+}
+
+func TestDihedralTransforms(t *testing.T) {
+	src := testImage()
+	at := func(img image.Image, x, y int) color.NRGBA {
+		return toNRGBA(img).NRGBAAt(x, y)
+	}
+	// Where the top left pixel of the output comes from.
+	for name, from := range map[string]image.Point{
+		"original":   {0, 0},
+		"rotate90":   {0, 1},
+		"rotate180":  {3, 1},
+		"rotate270":  {3, 0},
+		"flipx":      {3, 0},
+		"flipy":      {0, 1},
+		"flipplus1":  {0, 0},
+		"flipminus1": {3, 1},
+	} {
+		img := apply(t, name, src)
+		if name == "original" || name == "rotate180" || name == "flipx" || name == "flipy" {
+			assert.Equal(t, image.Rect(0, 0, 4, 2), img.Bounds(), name)
+		} else {
+			assert.Equal(t, image.Rect(0, 0, 2, 4), img.Bounds(), name)
+		}
+		assert.Equal(t, src.NRGBAAt(from.X, from.Y), at(img, 0, 0), name)
+	}
+	// Rotating clockwise moves the bottom left corner to the top left.
+	assert.Equal(t, src.NRGBAAt(3, 0), at(apply(t, "rotate90", src), 1, 3))
+}
+
+func TestTransforms(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 200, 100))
+	for i := range src.Pix {
+		src.Pix[i] = 128
+	}
+
+	assert.Equal(t, image.Rect(0, 0, 100, 50), apply(t, "scale-50", src).Bounds())
+	assert.Equal(t, image.Rect(0, 0, 400, 200), apply(t, "scale-200", src).Bounds())
+	assert.Equal(t, image.Rect(10, 5, 190, 95), apply(t, "crop-10", src).Bounds())
+	bordered := toNRGBA(apply(t, "border-10", src))
+	assert.Equal(t, image.Rect(0, 0, 240, 120), bordered.Bounds())
+	assert.Equal(t, color.NRGBA{0, 0, 0, 255}, bordered.NRGBAAt(0, 0))
+	assert.Equal(t, color.NRGBA{128, 128, 128, 128}, bordered.NRGBAAt(120, 60))
+
+	assert.Equal(t, uint8(64), toNRGBA(apply(t, "gamma-2", src)).Pix[0])
+	// Blurring a flat image leaves it as it is.
+	assert.Equal(t, src.Pix, toNRGBA(apply(t, "blur-3", src)).Pix)
+	assert.NotEqual(t, src.Pix, toNRGBA(apply(t, "noise-5", src)).Pix)
+	assert.Equal(t, toNRGBA(apply(t, "noise-5", src)).Pix, toNRGBA(apply(t, "noise-5", src)).Pix)
+	assert.NotEqual(t, src.Pix, toNRGBA(apply(t, "text", src)).Pix)
+	assert.Equal(t, src.Bounds(), apply(t, "jpeg-q50", src).Bounds())
+
+	for _, name := range []string{"sharpen-2", "jpeg", "jpeg-q0", "jpeg-qhigh", "scale-0", "crop-100", "gamma-0", "blur--1"} {
+		_, err := ParseTransform(name)
+		assert.Errorf(t, err, "%s", name)
+	}
+	assert.Len(t, DefaultTransforms(), len(DEFAULT_TRANSFORMS))
+}
+
+func TestStats(t *testing.T) {
+	report := &Report{
+		Transforms: []string{"a", "b", "c"},
+		Thresholds: []int{2, 10},
+		Samples: []Sample{
+			{Path: "1", Transform: "a", Distance: 0},
+			{Path: "1", Transform: "b", Distance: 4},
+			{Path: "1", Transform: "c", Err: errors.New("broken")},
+			{Path: "2", Transform: "a", Distance: 2},
+			{Path: "2", Transform: "b", Distance: 20},
+			{Path: "2", Transform: "c", Err: errors.New("broken")},
+			{Path: "3", Transform: "a", Distance: 1},
+			{Path: "3", Transform: "b", Err: errors.New("broken")},
+			{Path: "3", Transform: "c", Err: errors.New("broken")},
+		},
+	}
+	assert.Equal(t, []Stats{
+		{Transform: "a", Samples: 3, Mean: 1, Min: 0, P50: 1, P90: 2, Max: 2, Recall: []Recall{{2, 1}, {10, 1}}},
+		{Transform: "b", Samples: 3, Failed: 1, Mean: 12, Min: 4, P50: 4, P90: 20, Max: 20, Recall: []Recall{{2, 0}, {10, 1.0 / 3}}},
+		{Transform: "c", Samples: 3, Failed: 3, Recall: []Recall{{2, 0}, {10, 0}}},
+	}, report.Stats())
+}
+
+func TestEvaluate(t *testing.T) {
+	transforms, err := ParseTransforms([]string{"original", "jpeg-q70", "rotate90"})
+	assert.ErrorIs(t, err, nil)
+	report := Evaluate([]string{BRIDGE_ORIGINAL, "missing.jpg"}, Options{Transforms: transforms, Thresholds: []int{31}, Workers: 2})
+	assert.Len(t, report.Samples, 6)
+
+	assert.Equal(t, BRIDGE_ORIGINAL, report.Samples[0].Path)
+	assert.ErrorIs(t, report.Samples[0].Err, nil)
+	assert.Equal(t, 0, report.Samples[0].Distance)
+	assert.LessOrEqual(t, report.Samples[1].Distance, 31)
+	assert.Greater(t, report.Samples[2].Distance, 31)
+	assert.ErrorIs(t, report.Samples[3].Err, os.ErrNotExist)
+
+	stats := report.Stats()
+	assert.Equal(t, []Recall{{31, 0.5}}, stats[0].Recall)
+	assert.Equal(t, 1, stats[0].Failed)
+	assert.Equal(t, []Recall{{31, 0}}, stats[2].Recall)
+}
+
+func TestEvaluateFormats(t *testing.T) {
+	f, err := os.Open(BRIDGE_ORIGINAL)
+	assert.ErrorIs(t, err, nil)
+	src, err := jpeg.Decode(f)
+	f.Close()
+	assert.ErrorIs(t, err, nil)
+
+	dir := t.TempDir()
+	var paths []string
+	for name, encode := range map[string]func(io.Writer, image.Image) error{
+		"bridge.bmp": bmp.Encode,
+		"bridge.tif": func(w io.Writer, m image.Image) error { return tiff.Encode(w, m, nil) },
+	} {
+		path := filepath.Join(dir, name)
+		out, err := os.Create(path)
+		assert.ErrorIs(t, err, nil)
+		assert.ErrorIs(t, encode(out, src), nil)
+		assert.ErrorIs(t, out.Close(), nil)
+		paths = append(paths, path)
+	}
+
+	transforms, err := ParseTransforms([]string{"original"})
+	assert.ErrorIs(t, err, nil)
+	report := Evaluate(paths, Options{Transforms: transforms, Thresholds: []int{0}})
+	assert.Len(t, report.Samples, 2)
+	for _, sample := range report.Samples {
+		assert.ErrorIs(t, sample.Err, nil, sample.Path)
+		assert.Equal(t, 0, sample.Distance, sample.Path)
+	}
+}
@@ -0,0 +1,318 @@
+package robustness
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// The text drawn by the text transform.
+const OVERLAY_TEXT = "PDQ ROBUSTNESS"
+
+// Transforms applied when none are chosen: the usual edits made to images
+// as they are shared, and every dihedral transform.
+var DEFAULT_TRANSFORMS = []string{
+	"original",
+	"jpeg-q90", "jpeg-q70", "jpeg-q50", "jpeg-q30", "jpeg-q10",
+	"scale-200", "scale-50", "scale-25",
+	"crop-5", "crop-10", "crop-20",
+	"border-5", "border-10", "border-20",
+	"gamma-0.5", "gamma-2",
+	"blur-1", "blur-3",
+	"noise-5", "noise-15",
+	"text",
+	"rotate90", "rotate180", "rotate270", "flipx", "flipy", "flipplus1", "flipminus1",
+}
+
+// An edit applied to an original image to produce a variant.
+type Transform struct {
+	Name  string
+	Apply func(src image.Image) (image.Image, error)
+}
+
+func DefaultTransforms() []Transform {
+	transforms, err := ParseTransforms(DEFAULT_TRANSFORMS)
+	if err != nil {
+		panic(err)
+	}
+	return transforms
+}
+
+func ParseTransforms(names []string) ([]Transform, error) {
+	transforms := make([]Transform, 0, len(names))
+	for _, name := range names {
+		t, err := ParseTransform(name)
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, t)
+	}
+	return transforms, nil
+}
+
+/**
+ * Parses a transform name: one of pdq.DIHEDRAL_NAMES ("original" leaves
+ * the image as it is), "text", or a family and parameter:
+ *
+ *	jpeg-qN    re-encode as JPEG at quality N
+ *	scale-N    resize to N% of the width and height
+ *	crop-N     cut N% of the width and height, evenly from both sides
+ *	border-N   add a black border of N% of the width and height per side
+ *	gamma-G    apply gamma G to each channel
+ *	blur-R     blur with radius R pixels
+ *	noise-S    add Gaussian noise with standard deviation S (of 255)
+ */
+func ParseTransform(name string) (Transform, error) {
+	if i := slices.Index(pdq.DIHEDRAL_NAMES, name); i >= 0 {
+		return Transform{name, func(src image.Image) (image.Image, error) {
+			return dihedral(src, i), nil
+		}}, nil
+	}
+	if name == "text" {
+		return Transform{name, func(src image.Image) (image.Image, error) {
+			return overlayText(src, OVERLAY_TEXT), nil
+		}}, nil
+	}
+
+	family, param, ok := strings.Cut(name, "-")
+	if !ok {
+		return Transform{}, fmt.Errorf("unknown transform %q", name)
+	}
+	if family == "jpeg" {
+		param = strings.TrimPrefix(param, "q")
+	}
+	value, err := strconv.ParseFloat(param, 64)
+	if err != nil || value < 0 {
+		return Transform{}, fmt.Errorf("transform %q: bad parameter %q", name, param)
+	}
+	n := int(value)
+
+	var apply func(src image.Image) (image.Image, error)
+	switch family {
+	case "jpeg":
+		if n < 1 || n > 100 {
+			return Transform{}, fmt.Errorf("transform %q: JPEG quality must be 1 to 100", name)
+		}
+		apply = func(src image.Image) (image.Image, error) {
+			return reencodeJPEG(src, n)
+		}
+	case "scale":
+		if n < 1 {
+			return Transform{}, fmt.Errorf("transform %q: scale must be at least 1%%", name)
+		}
+		apply = func(src image.Image) (image.Image, error) {
+			b := src.Bounds()
+			return resize(src, max(b.Dx()*n/100, 1), max(b.Dy()*n/100, 1)), nil
+		}
+	case "crop":
+		if n >= 100 {
+			return Transform{}, fmt.Errorf("transform %q: crop must be below 100%%", name)
+		}
+		apply = func(src image.Image) (image.Image, error) {
+			return crop(src, n), nil
+		}
+	case "border":
+		apply = func(src image.Image) (image.Image, error) {
+			return border(src, n), nil
+		}
+	case "gamma":
+		if value == 0 {
+			return Transform{}, fmt.Errorf("transform %q: gamma must be above 0", name)
+		}
+		apply = func(src image.Image) (image.Image, error) {
+			return gamma(src, value), nil
+		}
+	case "blur":
+		apply = func(src image.Image) (image.Image, error) {
+			return blur(src, n), nil
+		}
+	case "noise":
+		apply = func(src image.Image) (image.Image, error) {
+			return noise(src, value), nil
+		}
+	default:
+		return Transform{}, fmt.Errorf("unknown transform %q", name)
+	}
+	return Transform{name, apply}, nil
+}
+
+// Copies src into a new image with its origin at 0, 0.
+func toNRGBA(src image.Image) *image.NRGBA {
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	xdraw.Draw(dst, dst.Bounds(), src, b.Min, xdraw.Src)
+	return dst
+}
+
+func reencodeJPEG(src image.Image, quality int) (image.Image, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return jpeg.Decode(&buf)
+}
+
+func resize(src image.Image, width, height int) image.Image {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), xdraw.Src, nil)
+	return dst
+}
+
+func crop(src image.Image, percent int) image.Image {
+	b := src.Bounds()
+	dx := b.Dx() * percent / 200
+	dy := b.Dy() * percent / 200
+	return toNRGBA(src).SubImage(image.Rect(dx, dy, b.Dx()-dx, b.Dy()-dy))
+}
+
+func border(src image.Image, percent int) image.Image {
+	b := src.Bounds()
+	dx := b.Dx() * percent / 100
+	dy := b.Dy() * percent / 100
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx()+2*dx, b.Dy()+2*dy))
+	xdraw.Draw(dst, dst.Bounds(), image.NewUniform(color.Black), image.Point{}, xdraw.Src)
+	xdraw.Draw(dst, image.Rect(dx, dy, dx+b.Dx(), dy+b.Dy()), src, b.Min, xdraw.Src)
+	return dst
+}
+
+func gamma(src image.Image, g float64) image.Image {
+	var table [256]uint8
+	for i := range table {
+		table[i] = uint8(math.Round(255 * math.Pow(float64(i)/255, g)))
+	}
+	dst := toNRGBA(src)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		dst.Pix[i] = table[dst.Pix[i]]
+		dst.Pix[i+1] = table[dst.Pix[i+1]]
+		dst.Pix[i+2] = table[dst.Pix[i+2]]
+	}
+	return dst
+}
+
+// Three passes of a box blur, close to a Gaussian blur with the same
+// radius.
+func blur(src image.Image, radius int) image.Image {
+	dst := toNRGBA(src)
+	if radius < 1 {
+		return dst
+	}
+	b := dst.Bounds()
+	tmp := make([]uint8, len(dst.Pix))
+	for pass := 0; pass < 3; pass++ {
+		boxBlur(dst.Pix, tmp, b.Dx(), b.Dy(), 4, dst.Stride, radius)
+		boxBlur(tmp, dst.Pix, b.Dy(), b.Dx(), dst.Stride, 4, radius)
+	}
+	return dst
+}
+
+// Averages each channel over a window of 2*radius+1 pixels along lines of
+// length n, stepping step bytes between pixels and stride bytes between
+// lines. Edge pixels are repeated.
+func boxBlur(in, out []uint8, n, lines, step, stride, radius int) {
+	window := 2*radius + 1
+	for line := 0; line < lines; line++ {
+		base := line * stride
+		for c := 0; c < 4; c++ {
+			at := func(i int) int {
+				return int(in[base+min(max(i, 0), n-1)*step+c])
+			}
+			sum := 0
+			for i := -radius; i <= radius; i++ {
+				sum += at(i)
+			}
+			for i := 0; i < n; i++ {
+				out[base+i*step+c] = uint8((sum + window/2) / window)
+				sum += at(i+radius+1) - at(i-radius)
+			}
+		}
+	}
+}
+
+// The noise is seeded so that evaluations are repeatable.
+func noise(src image.Image, sigma float64) image.Image {
+	rng := rand.New(rand.NewSource(1))
+	dst := toNRGBA(src)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			v := float64(dst.Pix[i+c]) + rng.NormFloat64()*sigma
+			dst.Pix[i+c] = uint8(math.Round(min(max(v, 0), 255)))
+		}
+	}
+	return dst
+}
+
+// Draws text in white across the bottom of the image, scaled to 80% of
+// its width, like a caption or watermark.
+func overlayText(src image.Image, text string) image.Image {
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, text).Ceil()
+	height := face.Height
+	label := image.NewNRGBA(image.Rect(0, 0, width, height))
+	d := font.Drawer{Dst: label, Src: image.White, Face: face, Dot: fixed.P(0, face.Ascent)}
+	d.DrawString(text)
+
+	dst := toNRGBA(src)
+	b := dst.Bounds()
+	w := b.Dx() * 8 / 10
+	h := max(w*height/width, 1)
+	x := (b.Dx() - w) / 2
+	y := max(b.Dy()-h-b.Dy()/20, 0)
+	xdraw.NearestNeighbor.Scale(dst, image.Rect(x, y, x+w, y+h), label, label.Bounds(), xdraw.Over, nil)
+	return dst
+}
+
+/**
+ * Applies the transform pdq.DIHEDRAL_NAMES[i]: rotations are clockwise,
+ * flipx mirrors left to right, flipy top to bottom, flipplus1 transposes
+ * about the main diagonal and flipminus1 about the other one.
+ */
+func dihedral(src image.Image, i int) image.Image {
+	in := toNRGBA(src)
+	w, h := in.Bounds().Dx(), in.Bounds().Dy()
+	// Where the pixel at x, y of the output comes from.
+	var from func(x, y int) (int, int)
+	dw, dh := w, h
+	switch pdq.DIHEDRAL_NAMES[i] {
+	case "original":
+		return in
+	case "rotate90":
+		dw, dh = h, w
+		from = func(x, y int) (int, int) { return y, h - 1 - x }
+	case "rotate180":
+		from = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case "rotate270":
+		dw, dh = h, w
+		from = func(x, y int) (int, int) { return w - 1 - y, x }
+	case "flipx":
+		from = func(x, y int) (int, int) { return w - 1 - x, y }
+	case "flipy":
+		from = func(x, y int) (int, int) { return x, h - 1 - y }
+	case "flipplus1":
+		dw, dh = h, w
+		from = func(x, y int) (int, int) { return y, x }
+	case "flipminus1":
+		dw, dh = h, w
+		from = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := from(x, y)
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], in.Pix[in.PixOffset(sx, sy):in.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,212 @@
+// Package scancache remembers the hashes of files already scanned, so that
+// repeated scans over a large tree only hash new or changed files.
+package scancache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Entry is the cached outcome of hashing one file.
+type Entry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	// Hex SHA-256 of the file contents, see Digest.
+	Digest  string `json:"digest"`
+	Hash    string `json:"hash"`
+	Quality int    `json:"quality"`
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
+	MIME    string `json:"mime,omitempty"`
+	// The EXIF orientation, 1 to 8, or 0 if the image has none.
+	Orientation int `json:"orientation,omitempty"`
+}
+
+/**
+ * Cache is an append-only log of entries, one JSON object per line, where
+ * later lines replace earlier ones for the same path. Every Put is written
+ * through immediately, so an interrupted scan keeps the work done so far;
+ * Close rewrites the file without the superseded lines.
+ *
+ * A Cache is safe for concurrent use.
+ */
+type Cache struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	entries map[string]*Entry
+	// Lines in the file that no longer hold a live entry.
+	stale int
+}
+
+// Open loads the cache at path, creating it if it does not exist.
+func Open(path string) (*Cache, error) {
+	c := &Cache{path: path, entries: map[string]*Entry{}}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		entry := &Entry{}
+		if err := json.Unmarshal(line, entry); err != nil {
+			// A torn last line is left by a scan killed mid-write.
+			if i == len(lines)-1 {
+				c.stale++
+				break
+			}
+			return nil, fmt.Errorf("%s: line %d: %w", path, i+1, err)
+		}
+		if _, ok := c.entries[entry.Path]; ok {
+			c.stale++
+		}
+		c.entries[entry.Path] = entry
+	}
+
+	if c.stale > 0 {
+		if err := c.compact(); err != nil {
+			return nil, err
+		}
+	}
+	c.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Digest returns the content digest stored in Entry.Digest.
+func Digest(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Get returns the entry for path, whatever the state of the file.
+func (c *Cache) Get(path string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[path]
+	return entry, ok
+}
+
+// Lookup returns the entry for path if the file still has the recorded
+// size and modification time, without reading it.
+func (c *Cache) Lookup(path string, info fs.FileInfo) (*Entry, bool) {
+	entry, ok := c.Get(path)
+	if !ok || entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) {
+		return nil, false
+	}
+	return entry, true
+}
+
+func (c *Cache) Put(entry *Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[entry.Path]; ok {
+		c.stale++
+	}
+	c.entries[entry.Path] = entry
+	_, err = c.file.Write(append(line, '\n'))
+	return err
+}
+
+// Prune drops the entries whose files no longer exist and returns how
+// many were dropped. exists defaults to checking the filesystem.
+func (c *Cache) Prune(exists func(path string) bool) int {
+	if exists == nil {
+		exists = func(path string) bool {
+			_, err := os.Stat(path)
+			return !errors.Is(err, fs.ErrNotExist)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pruned := 0
+	for path := range c.entries {
+		if !exists(path) {
+			delete(c.entries, path)
+			pruned++
+		}
+	}
+	c.stale += pruned
+	return pruned
+}
+
+// Close compacts the file if entries were replaced or pruned.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.file.Close()
+	if err != nil {
+		return err
+	}
+	if c.stale == 0 {
+		return nil
+	}
+	return c.compact()
+}
+
+// Rewrites the file with one line per live entry, sorted by path. The
+// new file replaces the old one by rename so a crash leaves either.
+func (c *Cache) compact() error {
+	paths := make([]string, 0, len(c.entries))
+	for path := range c.entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, path := range paths {
+		if err := enc.Encode(c.entries[path]); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return err
+	}
+	c.stale = 0
+	return nil
+}
@@ -0,0 +1,109 @@
+package scancache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const HASH = "d8f8f0cce0f4a84f0e370a22028f67f0b36e2ed596623e1d33e6b39c4e9c9b22"
+
+func TestPutAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scan.cache")
+	image := filepath.Join(dir, "a.jpg")
+	assert.ErrorIs(t, os.WriteFile(image, []byte("jpeg"), 0o644), nil)
+	info, _ := os.Stat(image)
+
+	cache, err := Open(path)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, 0, cache.Len())
+
+	entry := &Entry{Path: image, Size: info.Size(), ModTime: info.ModTime(), Digest: Digest([]byte("jpeg")), Hash: HASH, Quality: 100, Orientation: 6}
+	assert.ErrorIs(t, cache.Put(entry), nil)
+	assert.ErrorIs(t, cache.Close(), nil)
+
+	cache, err = Open(path)
+	assert.ErrorIs(t, err, nil)
+	defer cache.Close()
+	found, ok := cache.Lookup(image, info)
+	assert.True(t, ok)
+	assert.Equal(t, HASH, found.Hash)
+	assert.Equal(t, 100, found.Quality)
+	assert.Equal(t, 6, found.Orientation)
+
+	// A touched file no longer matches on size and mtime alone.
+	later := info.ModTime().Add(time.Hour)
+	assert.ErrorIs(t, os.Chtimes(image, later, later), nil)
+	info, _ = os.Stat(image)
+	_, ok = cache.Lookup(image, info)
+	assert.False(t, ok)
+	_, ok = cache.Get(image)
+	assert.True(t, ok)
+}
+
+func TestCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scan.cache")
+	cache, err := Open(path)
+	assert.ErrorIs(t, err, nil)
+	for quality := 0; quality < 3; quality++ {
+		assert.ErrorIs(t, cache.Put(&Entry{Path: "a.jpg", Hash: HASH, Quality: quality}), nil)
+	}
+	assert.ErrorIs(t, cache.Put(&Entry{Path: "b.jpg", Hash: HASH}), nil)
+
+	// Written through before Close.
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, 4, strings.Count(string(data), "\n"))
+
+	assert.ErrorIs(t, cache.Close(), nil)
+	data, _ = os.ReadFile(path)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"))
+
+	cache, err = Open(path)
+	assert.ErrorIs(t, err, nil)
+	defer cache.Close()
+	entry, _ := cache.Get("a.jpg")
+	assert.Equal(t, 2, entry.Quality)
+}
+
+func TestTornLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scan.cache")
+	content := `{"path":"a.jpg","hash":"` + HASH + `"}` + "\n" + `{"path":"b.j`
+	assert.ErrorIs(t, os.WriteFile(path, []byte(content), 0o644), nil)
+
+	cache, err := Open(path)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, 1, cache.Len())
+	assert.ErrorIs(t, cache.Close(), nil)
+
+	assert.ErrorIs(t, os.WriteFile(path, []byte("nonsense\n"+content), 0o644), nil)
+	_, err = Open(path)
+	assert.ErrorContains(t, err, "line 1")
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	kept := filepath.Join(dir, "kept.jpg")
+	assert.ErrorIs(t, os.WriteFile(kept, []byte("jpeg"), 0o644), nil)
+
+	path := filepath.Join(dir, "scan.cache")
+	cache, err := Open(path)
+	assert.ErrorIs(t, err, nil)
+	assert.ErrorIs(t, cache.Put(&Entry{Path: kept, Hash: HASH}), nil)
+	assert.ErrorIs(t, cache.Put(&Entry{Path: filepath.Join(dir, "deleted.jpg"), Hash: HASH}), nil)
+
+	assert.Equal(t, 1, cache.Prune(nil))
+	assert.Equal(t, 1, cache.Len())
+	assert.ErrorIs(t, cache.Close(), nil)
+
+	cache, err = Open(path)
+	assert.ErrorIs(t, err, nil)
+	defer cache.Close()
+	assert.Equal(t, 1, cache.Len())
+	_, ok := cache.Get(kept)
+	assert.True(t, ok)
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"time"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/MTRNord/pdqhash-go/grpcserver"
+	"github.com/MTRNord/pdqhash-go/hashlist"
+	"github.com/MTRNord/pdqhash-go/index"
+	"github.com/MTRNord/pdqhash-go/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+)
+
+// Options for Serve, shared by every binary that runs the service.
+type Options struct {
+	Config Config
+
+	Addr            string
+	GRPCAddr        string
+	HashList        string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	ShutdownTimeout time.Duration
+	Metrics         bool
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Config:          DefaultConfig(),
+		Addr:            ":8080",
+		ReadTimeout:     time.Minute,
+		WriteTimeout:    time.Minute,
+		ShutdownTimeout: 30 * time.Second,
+		Metrics:         true,
+	}
+}
+
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Addr, "addr", o.Addr, "Address to listen on")
+	fs.StringVar(&o.GRPCAddr, "grpc-addr", o.GRPCAddr, "Address to serve the gRPC API on, disabled when empty")
+	fs.StringVar(&o.HashList, "hashlist", o.HashList, "Hash list (CSV or TSV) to answer match queries against")
+	fs.Int64Var(&o.Config.MaxBodyBytes, "max-body-bytes", o.Config.MaxBodyBytes, "Largest accepted request body or gRPC message, unlimited when 0 or less")
+	fs.DurationVar(&o.Config.HandlerTimeout, "handler-timeout", o.Config.HandlerTimeout, "Time allowed to answer a request")
+	fs.IntVar(&o.Config.MatchThreshold, "threshold", o.Config.MatchThreshold, "Default Hamming distance for matches")
+	fs.IntVar(&o.Config.MaxMatches, "max-matches", o.Config.MaxMatches, "Maximum number of matches returned per query, unlimited when 0 or less")
+	fs.DurationVar(&o.ReadTimeout, "read-timeout", o.ReadTimeout, "Time allowed to read a request")
+	fs.DurationVar(&o.WriteTimeout, "write-timeout", o.WriteTimeout, "Time allowed to write a response")
+	fs.DurationVar(&o.ShutdownTimeout, "shutdown-timeout", o.ShutdownTimeout, "Time allowed for in-flight requests on shutdown")
+	fs.BoolVar(&o.Metrics, "metrics", o.Metrics, "Serve Prometheus metrics on /metrics")
+}
+
+/**
+ * Serve runs the HTTP API (and the gRPC API when GRPCAddr is set) until
+ * ctx is cancelled, then shuts both down gracefully. libvips must already
+ * be started.
+ */
+func Serve(ctx context.Context, opts Options) error {
+	var collector *metrics.Collector
+	registry := prometheus.NewRegistry()
+	if opts.Metrics {
+		collector = metrics.NewCollector("pdq")
+		registry.MustRegister(collector, collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+
+	var idx *index.Index
+	if opts.HashList != "" {
+		entries, err := hashlist.ReadFile(opts.HashList)
+		if err != nil {
+			return err
+		}
+		idx = index.FromEntries(entries)
+		if collector != nil {
+			idx.Instrumentation = collector
+		}
+		log.Printf("loaded %d hashes from %s", idx.Len(), opts.HashList)
+	}
+
+	hasher := pdq.NewPDQHasher()
+	if collector != nil {
+		hasher.Instrumentation = collector
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", New(hasher, idx, opts.Config))
+	if collector != nil {
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	}
+
+	srv := &http.Server{
+		Addr:              opts.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       opts.ReadTimeout,
+		WriteTimeout:      opts.WriteTimeout,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	listener, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 2)
+	go func() {
+		log.Printf("listening on %s", listener.Addr())
+		errCh <- srv.Serve(listener)
+	}()
+
+	var grpcSrv *grpc.Server
+	if opts.GRPCAddr != "" {
+		grpcListener, err := net.Listen("tcp", opts.GRPCAddr)
+		if err != nil {
+			srv.Close()
+			return err
+		}
+		grpcConfig := grpcserver.DefaultConfig()
+		grpcConfig.MatchThreshold = opts.Config.MatchThreshold
+		grpcConfig.MaxMatches = opts.Config.MaxMatches
+
+		// gRPC would fall back to its 4 MB default without a limit, so
+		// 0 or less lifts it, as it does for HTTP bodies.
+		maxRecvMsgSize := math.MaxInt32
+		if opts.Config.MaxBodyBytes > 0 {
+			maxRecvMsgSize = int(min(opts.Config.MaxBodyBytes, math.MaxInt32))
+		}
+		grpcSrv = grpc.NewServer(grpc.MaxRecvMsgSize(maxRecvMsgSize))
+		grpcserver.New(hasher, idx, grpcConfig).Register(grpcSrv)
+		go func() {
+			log.Printf("serving gRPC on %s", grpcListener.Addr())
+			errCh <- grpcSrv.Serve(grpcListener)
+		}()
+	}
+
+	select {
+	case err := <-errCh:
+		if grpcSrv != nil {
+			grpcSrv.Stop()
+		}
+		srv.Close()
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+		log.Printf("shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+		defer cancel()
+		if grpcSrv != nil {
+			go func() {
+				<-shutdownCtx.Done()
+				grpcSrv.Stop()
+			}()
+			grpcSrv.GracefulStop()
+		}
+		return srv.Shutdown(shutdownCtx)
+	}
+}
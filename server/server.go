@@ -0,0 +1,366 @@
+// Package server exposes a PDQHasher and an index over HTTP.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/MTRNord/pdqhash-go/index"
+	"github.com/MTRNord/pdqhash-go/types"
+)
+
+const DEFAULT_MAX_BODY_BYTES = 32 << 20
+const DEFAULT_HANDLER_TIMEOUT = 30 * time.Second
+const DEFAULT_MAX_MATCHES = 100
+
+// Name of the multipart field that carries an uploaded image.
+const IMAGE_FIELD = "image"
+
+type Config struct {
+	// Largest accepted request body, and with Serve the largest gRPC
+	// message; 0 or less means no limit.
+	MaxBodyBytes int64
+	// Time after which a request is answered with 503.
+	HandlerTimeout time.Duration
+	// Distance used by /v1/match when the request does not give one.
+	MatchThreshold int
+	// Upper bound on the number of matches returned per query; 0 or
+	// less means no bound.
+	MaxMatches int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxBodyBytes:   DEFAULT_MAX_BODY_BYTES,
+		HandlerTimeout: DEFAULT_HANDLER_TIMEOUT,
+		MatchThreshold: index.DEFAULT_MATCH_THRESHOLD,
+		MaxMatches:     DEFAULT_MAX_MATCHES,
+	}
+}
+
+type Server struct {
+	hasher  *pdq.PDQHasher
+	index   *index.Index
+	config  Config
+	handler http.Handler
+}
+
+type HashResponse struct {
+	Hash    string `json:"hash"`
+	Quality int    `json:"quality"`
+}
+
+type DihedralResponse struct {
+	Quality int               `json:"quality"`
+	Hashes  map[string]string `json:"hashes"`
+}
+
+type MatchRequest struct {
+	Hash      string `json:"hash"`
+	Threshold *int   `json:"threshold,omitempty"`
+	TopK      int    `json:"top_k,omitempty"`
+}
+
+type MatchResult struct {
+	Hash     string   `json:"hash"`
+	Distance int      `json:"distance"`
+	ID       string   `json:"id,omitempty"`
+	Quality  *int     `json:"quality,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+type MatchResponse struct {
+	Hash    string        `json:"hash"`
+	Quality *int          `json:"quality,omitempty"`
+	Matches []MatchResult `json:"matches"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// httpError carries the status code to answer with.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{http.StatusBadRequest, fmt.Errorf(format, args...)}
+}
+
+// New builds a server. idx may be nil, in which case /v1/match reports
+// that no hash list is loaded.
+func New(hasher *pdq.PDQHasher, idx *index.Index, config Config) *Server {
+	s := &Server{hasher: hasher, index: idx, config: config}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.Handle("/v1/hash", s.post(s.handleHash))
+	mux.Handle("/v1/dihedral", s.post(s.handleDihedral))
+	mux.Handle("/v1/match", s.post(s.handleMatch))
+
+	var handler http.Handler = mux
+	if config.HandlerTimeout > 0 {
+		timeout := http.TimeoutHandler(handler, config.HandlerTimeout, `{"error":"request timed out"}`)
+		// The timeout body is written without a Content-Type; responses
+		// that finish in time replace this one with their own.
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			timeout.ServeHTTP(w, r)
+		})
+	}
+	s.handler = handler
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+func (s *Server) post(fn func(*http.Request) (interface{}, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, errorResponse{"method not allowed"})
+			return
+		}
+		if s.config.MaxBodyBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxBodyBytes)
+		}
+
+		response, err := fn(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, response)
+	})
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	size := 0
+	if s.index != nil {
+		size = s.index.Len()
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "hashes": size})
+}
+
+func (s *Server) handleHash(r *http.Request) (interface{}, error) {
+	buf, err := readImage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	hashAndQuality, err := s.hasher.FromBufferContext(r.Context(), buf)
+	if err != nil {
+		return nil, hashError(r, err)
+	}
+	return HashResponse{hashAndQuality.Hash.String(), hashAndQuality.Quality}, nil
+}
+
+func (s *Server) handleDihedral(r *http.Request) (interface{}, error) {
+	buf, err := readImage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes, err := s.hasher.DihedralFromBufferContext(r.Context(), buf, pdq.PDQ_DO_DIH_ALL)
+	if err != nil {
+		return nil, hashError(r, err)
+	}
+	response := DihedralResponse{Quality: hashes.Quality, Hashes: map[string]string{}}
+	for _, dihedral := range hashes.Hashes() {
+		response.Hashes[dihedral.Name] = dihedral.Hash.String()
+	}
+	return response, nil
+}
+
+// /v1/match takes either a JSON MatchRequest or an image upload; for
+// uploads threshold and top_k are read from the query string.
+func (s *Server) handleMatch(r *http.Request) (interface{}, error) {
+	if s.index == nil {
+		return nil, &httpError{http.StatusServiceUnavailable, errors.New("no hash list loaded")}
+	}
+
+	var request MatchRequest
+	var hash *types.Hash256
+	var quality *int
+
+	if mediaType(r) == "application/json" {
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			return nil, bodyError(err)
+		}
+		hash, err = types.Hash256FromHexString(strings.ToLower(request.Hash))
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+	} else {
+		err := parseMatchQuery(r, &request)
+		if err != nil {
+			return nil, err
+		}
+		buf, err := readImage(r)
+		if err != nil {
+			return nil, err
+		}
+		hashAndQuality, err := s.hasher.FromBufferContext(r.Context(), buf)
+		if err != nil {
+			return nil, hashError(r, err)
+		}
+		hash = hashAndQuality.Hash
+		quality = &hashAndQuality.Quality
+	}
+
+	var matches []index.Match
+	if request.TopK > 0 {
+		matches = s.index.TopK(hash, s.maxMatches(request.TopK))
+	} else {
+		threshold := s.config.MatchThreshold
+		if request.Threshold != nil {
+			threshold = *request.Threshold
+		}
+		if threshold < 0 || threshold > 256 {
+			return nil, badRequest("threshold must be between 0 and 256")
+		}
+		matches = s.index.Query(hash, threshold)
+		if n := s.maxMatches(len(matches)); n < len(matches) {
+			matches = matches[:n]
+		}
+	}
+
+	response := MatchResponse{Hash: hash.String(), Quality: quality, Matches: []MatchResult{}}
+	for _, match := range matches {
+		result := MatchResult{
+			Hash:     match.Entry.Hash.String(),
+			Distance: match.Distance,
+			ID:       match.Entry.ID,
+			Tags:     match.Entry.Tags,
+		}
+		if match.Entry.Quality >= 0 {
+			q := match.Entry.Quality
+			result.Quality = &q
+		}
+		response.Matches = append(response.Matches, result)
+	}
+	return response, nil
+}
+
+func parseMatchQuery(r *http.Request, request *MatchRequest) error {
+	query := r.URL.Query()
+	if v := query.Get("threshold"); v != "" {
+		threshold, err := strconv.Atoi(v)
+		if err != nil {
+			return badRequest("incorrect threshold: %s", v)
+		}
+		request.Threshold = &threshold
+	}
+	if v := query.Get("top_k"); v != "" {
+		topK, err := strconv.Atoi(v)
+		if err != nil {
+			return badRequest("incorrect top_k: %s", v)
+		}
+		request.TopK = topK
+	}
+	return nil
+}
+
+// Reads the image either from the IMAGE_FIELD of a multipart form or
+// from the raw request body.
+func readImage(r *http.Request) ([]byte, error) {
+	var reader io.Reader = r.Body
+
+	if mediaType(r) == "multipart/form-data" {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil, badRequest("missing %q field", IMAGE_FIELD)
+			}
+			if err != nil {
+				return nil, bodyError(err)
+			}
+			if part.FormName() == IMAGE_FIELD {
+				reader = part
+				break
+			}
+		}
+	}
+
+	buf, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, bodyError(err)
+	}
+	if len(buf) == 0 {
+		return nil, badRequest("empty image")
+	}
+	return buf, nil
+}
+
+// Images that cannot be decoded or are rejected by the quality policy are
+// answered with 422. Failures to read or process an accepted image are the
+// server's and answered with 500, like Internal in the gRPC service. A
+// request that expired while hashing gets 503, like the handler timeout.
+func hashError(r *http.Request, err error) error {
+	if r.Context().Err() != nil {
+		return &httpError{http.StatusServiceUnavailable, err}
+	}
+	var hashErr *pdq.HashError
+	if errors.As(err, &hashErr) && (hashErr.Reason == pdq.FAILURE_IO || hashErr.Reason == pdq.FAILURE_PROCESSING) {
+		return &httpError{http.StatusInternalServerError, err}
+	}
+	return &httpError{http.StatusUnprocessableEntity, err}
+}
+
+func mediaType(r *http.Request) string {
+	t, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return t
+}
+
+func bodyError(err error) error {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return &httpError{http.StatusRequestEntityTooLarge, fmt.Errorf("request body larger than %d bytes", maxBytesError.Limit)}
+	}
+	return badRequest("%v", err)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var he *httpError
+	if errors.As(err, &he) {
+		status = he.status
+	}
+	writeJSON(w, status, errorResponse{err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// Caps n at MaxMatches, if set.
+func (s *Server) maxMatches(n int) int {
+	if s.config.MaxMatches > 0 {
+		return min(n, s.config.MaxMatches)
+	}
+	return n
+}
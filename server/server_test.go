@@ -0,0 +1,303 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	pdq "github.com/MTRNord/pdqhash-go"
+	"github.com/MTRNord/pdqhash-go/hashlist"
+	"github.com/MTRNord/pdqhash-go/index"
+	"github.com/MTRNord/pdqhash-go/types"
+	"github.com/davidbyttow/govips/v2/vips"
+	"github.com/stretchr/testify/assert"
+)
+
+const BRIDGE_IMAGE = "../test-images/reg-test-input/dih/bridge-1-original.jpg"
+const BRIDGE_HASH = "d8f8f0cce0f4a84f0e370a22028f67f0b36e2ed596623e1d33e6b39c4e9c9b22"
+const Q0004_IMAGE = "../test-images/reg-test-input/labelme-subset/q0004.jpg"
+const Q0004_HASH = "992d44af36d69e6ca6b812585928bac11def254ef5398c6d07466c9abcc65b92"
+const Q0004_ROTATE_90_HASH = "9b323dd22976484c939787013f096d1669874a21dab0d3dadef50f2560cf3e4f"
+
+const HAMMING_TOLERANCE = 16
+
+func TestMain(m *testing.M) {
+	vips.LoggingSettings(nil, vips.LogLevelMessage)
+	vips.Startup(&vips.Config{
+		ConcurrencyLevel: 0,
+		MaxCacheFiles:    5,
+		MaxCacheMem:      50 * 1024 * 1024,
+		MaxCacheSize:     100,
+		ReportLeaks:      false,
+		CacheTrace:       false,
+		CollectStats:     false,
+	})
+	defer vips.Shutdown()
+
+	os.Exit(m.Run())
+}
+
+func newTestServer(t *testing.T, config Config) *httptest.Server {
+	hash, err := types.Hash256FromHexString(BRIDGE_HASH)
+	assert.ErrorIs(t, err, nil)
+	idx := index.FromEntries([]*hashlist.Entry{
+		{Hash: hash, Quality: 100, ID: "bridge", Tags: []string{"landscape"}},
+	})
+
+	ts := httptest.NewServer(New(pdq.NewPDQHasher(), idx, config))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func readFile(t *testing.T, path string) []byte {
+	buf, err := os.ReadFile(path)
+	assert.ErrorIs(t, err, nil)
+	return buf
+}
+
+func multipartBody(t *testing.T, buf []byte) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile(IMAGE_FIELD, "image.jpg")
+	assert.ErrorIs(t, err, nil)
+	_, err = fw.Write(buf)
+	assert.ErrorIs(t, err, nil)
+	assert.ErrorIs(t, mw.Close(), nil)
+	return body, mw.FormDataContentType()
+}
+
+func decode(t *testing.T, resp *http.Response, v interface{}) {
+	defer resp.Body.Close()
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.ErrorIs(t, json.NewDecoder(resp.Body).Decode(v), nil)
+}
+
+func assertHashNear(t *testing.T, expected, actual string) {
+	expectedHash, err := types.Hash256FromHexString(expected)
+	assert.ErrorIs(t, err, nil)
+	actualHash, err := types.Hash256FromHexString(actual)
+	assert.ErrorIs(t, err, nil)
+	assert.LessOrEqual(t, expectedHash.HammingDistance(actualHash), HAMMING_TOLERANCE)
+}
+
+func TestHashRawBody(t *testing.T) {
+	ts := newTestServer(t, DefaultConfig())
+
+	resp, err := http.Post(ts.URL+"/v1/hash", "image/jpeg", bytes.NewReader(readFile(t, BRIDGE_IMAGE)))
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result HashResponse
+	decode(t, resp, &result)
+	assertHashNear(t, BRIDGE_HASH, result.Hash)
+	assert.Greater(t, result.Quality, 0)
+}
+
+func TestHashMultipart(t *testing.T) {
+	ts := newTestServer(t, DefaultConfig())
+
+	body, contentType := multipartBody(t, readFile(t, Q0004_IMAGE))
+	resp, err := http.Post(ts.URL+"/v1/hash", contentType, body)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result HashResponse
+	decode(t, resp, &result)
+	assertHashNear(t, Q0004_HASH, result.Hash)
+}
+
+func TestDihedral(t *testing.T) {
+	ts := newTestServer(t, DefaultConfig())
+
+	resp, err := http.Post(ts.URL+"/v1/dihedral", "image/jpeg", bytes.NewReader(readFile(t, Q0004_IMAGE)))
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result DihedralResponse
+	decode(t, resp, &result)
+	assert.Len(t, result.Hashes, 8)
+	assertHashNear(t, Q0004_HASH, result.Hashes["original"])
+	assertHashNear(t, Q0004_ROTATE_90_HASH, result.Hashes["rotate90"])
+}
+
+func TestMatchHash(t *testing.T) {
+	ts := newTestServer(t, DefaultConfig())
+
+	hash, err := types.Hash256FromHexString(BRIDGE_HASH)
+	assert.ErrorIs(t, err, nil)
+	for _, k := range []int{0, 17, 100, 200} {
+		hash.FlipBit(k)
+	}
+
+	request, err := json.Marshal(MatchRequest{Hash: hash.String()})
+	assert.ErrorIs(t, err, nil)
+	resp, err := http.Post(ts.URL+"/v1/match", "application/json", bytes.NewReader(request))
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result MatchResponse
+	decode(t, resp, &result)
+	assert.Len(t, result.Matches, 1)
+	assert.Equal(t, "bridge", result.Matches[0].ID)
+	assert.Equal(t, 4, result.Matches[0].Distance)
+	assert.Equal(t, 100, *result.Matches[0].Quality)
+	assert.Equal(t, []string{"landscape"}, result.Matches[0].Tags)
+
+	threshold := 3
+	request, err = json.Marshal(MatchRequest{Hash: hash.String(), Threshold: &threshold})
+	assert.ErrorIs(t, err, nil)
+	resp, err = http.Post(ts.URL+"/v1/match", "application/json", bytes.NewReader(request))
+	assert.ErrorIs(t, err, nil)
+	decode(t, resp, &result)
+	assert.Empty(t, result.Matches)
+}
+
+func TestMaxMatches(t *testing.T) {
+	hash, err := types.Hash256FromHexString(BRIDGE_HASH)
+	assert.ErrorIs(t, err, nil)
+	request, err := json.Marshal(MatchRequest{Hash: BRIDGE_HASH})
+	assert.ErrorIs(t, err, nil)
+	topK, err := json.Marshal(MatchRequest{Hash: BRIDGE_HASH, TopK: 3})
+	assert.ErrorIs(t, err, nil)
+
+	for maxMatches, expected := range map[int]int{1: 1, 0: 3, -1: 3} {
+		config := DefaultConfig()
+		config.MaxMatches = maxMatches
+		idx := index.FromEntries([]*hashlist.Entry{{Hash: hash, ID: "a"}, {Hash: hash, ID: "b"}, {Hash: hash, ID: "c"}})
+		ts := httptest.NewServer(New(pdq.NewPDQHasher(), idx, config))
+		defer ts.Close()
+
+		for _, body := range [][]byte{request, topK} {
+			resp, err := http.Post(ts.URL+"/v1/match", "application/json", bytes.NewReader(body))
+			assert.ErrorIs(t, err, nil)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			var result MatchResponse
+			decode(t, resp, &result)
+			assert.Len(t, result.Matches, expected, "max matches %d", maxMatches)
+		}
+	}
+}
+
+func TestHashError(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/v1/hash", nil)
+	for reason, status := range map[string]int{
+		pdq.FAILURE_CORRUPT:            http.StatusUnprocessableEntity,
+		pdq.FAILURE_UNSUPPORTED_FORMAT: http.StatusUnprocessableEntity,
+		pdq.FAILURE_LOW_QUALITY:        http.StatusUnprocessableEntity,
+		pdq.FAILURE_IO:                 http.StatusInternalServerError,
+		pdq.FAILURE_PROCESSING:         http.StatusInternalServerError,
+	} {
+		err := hashError(r, &pdq.HashError{Reason: reason, Err: errors.New(reason)})
+		var he *httpError
+		assert.True(t, errors.As(err, &he))
+		assert.Equal(t, status, he.status, reason)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := hashError(r.WithContext(ctx), &pdq.HashError{Reason: pdq.FAILURE_PROCESSING, Err: context.Canceled})
+	var he *httpError
+	assert.True(t, errors.As(err, &he))
+	assert.Equal(t, http.StatusServiceUnavailable, he.status)
+}
+
+func TestMatchImage(t *testing.T) {
+	ts := newTestServer(t, DefaultConfig())
+
+	resp, err := http.Post(ts.URL+"/v1/match?top_k=1", "image/jpeg", bytes.NewReader(readFile(t, BRIDGE_IMAGE)))
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result MatchResponse
+	decode(t, resp, &result)
+	assert.NotNil(t, result.Quality)
+	assert.Len(t, result.Matches, 1)
+	assert.Equal(t, "bridge", result.Matches[0].ID)
+	assert.LessOrEqual(t, result.Matches[0].Distance, HAMMING_TOLERANCE)
+}
+
+func TestMatchWithoutIndex(t *testing.T) {
+	ts := httptest.NewServer(New(pdq.NewPDQHasher(), nil, DefaultConfig()))
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/v1/match", "application/json", strings.NewReader(`{"hash":"`+BRIDGE_HASH+`"}`))
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestErrors(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxBodyBytes = 1024
+	ts := newTestServer(t, config)
+
+	resp, err := http.Get(ts.URL + "/v1/hash")
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Post(ts.URL+"/v1/hash", "image/jpeg", bytes.NewReader(readFile(t, BRIDGE_IMAGE)))
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Post(ts.URL+"/v1/hash", "image/jpeg", strings.NewReader("not an image"))
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Post(ts.URL+"/v1/hash", "image/jpeg", nil)
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Post(ts.URL+"/v1/match", "application/json", strings.NewReader(`{"hash":"abc"}`))
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	var result errorResponse
+	decode(t, resp, &result)
+	assert.Contains(t, result.Error, "incorrect hash length")
+}
+
+func TestTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.HandlerTimeout = 10 * time.Millisecond
+	s := New(pdq.NewPDQHasher(), nil, config)
+
+	// The body never arrives, so the request outlives the timeout.
+	body, writer := io.Pipe()
+	defer writer.Close()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/hash", body))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var result errorResponse
+	assert.ErrorIs(t, json.Unmarshal(w.Body.Bytes(), &result), nil)
+	assert.Equal(t, "request timed out", result.Error)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+}
+
+func TestHealth(t *testing.T) {
+	ts := newTestServer(t, DefaultConfig())
+
+	resp, err := http.Get(ts.URL + "/healthz")
+	assert.ErrorIs(t, err, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result map[string]interface{}
+	decode(t, resp, &result)
+	assert.Equal(t, float64(1), result["hashes"])
+}